@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// config is the zstorectl configuration file format.  Credentials may be an
+// API token, or a TLS client certificate and key.
+type config struct {
+	Endpoint string `json:"endpoint"`
+	Token    string `json:"token,omitempty"`
+	CA       string `json:"ca,omitempty"`
+	Cert     string `json:"cert,omitempty"`
+	Key      string `json:"key,omitempty"`
+}
+
+// defaultConfigPath returns the default location of the configuration file,
+// in the user's home directory.
+func defaultConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".zstorectl.json"
+	}
+
+	return filepath.Join(home, ".zstorectl.json")
+}
+
+// readConfig reads a configuration file.  If the file does not exist and
+// mustExist is false, an empty configuration is returned.
+func readConfig(path string, mustExist bool) (*config, error) {
+	cfg := new(config)
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !mustExist {
+			return cfg, nil
+		}
+
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// httpClient returns a http.Client which uses the TLS configuration, if any,
+// specified by the configuration.
+func (c *config) httpClient() (*http.Client, error) {
+	if c.CA == "" && c.Cert == "" && c.Key == "" {
+		return &http.Client{}, nil
+	}
+
+	tc := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if c.CA != "" {
+		b, err := ioutil.ReadFile(c.CA)
+		if err != nil {
+			return nil, err
+		}
+
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(b) {
+			return nil, errors.New("no certificates found in CA file")
+		}
+	}
+
+	if c.Cert != "" || c.Key != "" {
+		cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tc,
+		},
+	}, nil
+}
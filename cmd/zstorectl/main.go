@@ -0,0 +1,323 @@
+// Command zstorectl provides a command-line client for a running zstored
+// server, which can create, list, inspect, and destroy volumes.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mdlayher/zstore/storage"
+	"github.com/mdlayher/zstore/zstoreclient"
+	"github.com/mdlayher/zstore/zstored/zstoredhttp"
+)
+
+var (
+	// configPath is the path to the configuration file
+	configPath string
+
+	// endpoint is the zstored server endpoint, overriding the configuration
+	endpoint string
+
+	// token is the API token, overriding the configuration
+	token string
+
+	// output is the output format for command results
+	output string
+)
+
+func init() {
+	flag.StringVar(&configPath, "config", "", "configuration file (default ~/.zstorectl.json)")
+	flag.StringVar(&endpoint, "endpoint", "", "zstored endpoint, such as http://localhost:5000")
+	flag.StringVar(&token, "token", "", "API token")
+	flag.StringVar(&output, "o", "table", "output format [table, json]")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `usage: %s [flags] command [args]
+
+commands:
+  create NAME -s SIZE [-c CLASS] [-l k=v,...] [-p k=v,...]
+                                    create a volume with the specified size slug, storage class, labels, and options
+  ls [-l SELECTOR]                  list all volumes, or volumes matching a label selector
+  get NAME                          show a single volume
+  label NAME k=v... k-...           set labels, or remove labels with a trailing '-'
+  rm NAME                           destroy a volume and all of its snapshots
+  sizes                             list size slugs permitted by the server, and whether they fit in the pool
+  pool                              show the status of the server's pool (administrators only)
+
+flags:
+`, os.Args[0])
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	// Parse CLI flags
+	flag.Parse()
+
+	// Set up logging
+	log.SetFlags(0)
+	log.SetPrefix("zstorectl: ")
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if output != "table" && output != "json" {
+		log.Fatalf("unknown output format %q [formats: table, json]", output)
+	}
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+
+	c, err := client()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch cmd {
+	case "create":
+		fs := flag.NewFlagSet("create", flag.ExitOnError)
+		size := fs.String("s", "", "size slug for the volume, or an exact size such as 1.5G if the server permits")
+		class := fs.String("c", "", "storage class for the volume")
+		labels := fs.String("l", "", "comma-separated labels for the volume, such as env=prod,tier=gold")
+		options := fs.String("p", "", fmt.Sprintf("comma-separated creation options, such as compression=lz4,sparse=true [options: %s]",
+			strings.Join(storage.VolumeOptionNames(), ", ")))
+		name := nameArg(fs, args)
+		if *size == "" {
+			log.Fatal("size must be specified with -s; list valid sizes with 'sizes'")
+		}
+
+		var ls map[string]string
+		if *labels != "" {
+			ls = parseLabels(strings.Split(*labels, ","), false)
+		}
+
+		var opts map[string]string
+		if *options != "" {
+			opts = make(map[string]string)
+			for _, o := range strings.Split(*options, ",") {
+				kv := strings.SplitN(o, "=", 2)
+				if len(kv) != 2 {
+					log.Fatalf("invalid option %q: options must be in key=value form", o)
+				}
+
+				opts[kv[0]] = kv[1]
+			}
+		}
+
+		v, err := c.CreateVolume(name, *size, &zstoreclient.CreateOptions{
+			Class:   *class,
+			Labels:  ls,
+			Options: opts,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		volumes([]*zstoredhttp.Volume{v})
+	case "ls":
+		fs := flag.NewFlagSet("ls", flag.ExitOnError)
+		selector := fs.String("l", "", "label selector, such as env=prod,!deprecated")
+		fs.Parse(args)
+
+		vs, err := c.ListVolumes(*selector)
+		if err != nil {
+			log.Fatal(err)
+		}
+		volumes(vs)
+	case "get":
+		v, err := c.Volume(nameArg(flag.NewFlagSet("get", flag.ExitOnError), args))
+		if err != nil {
+			log.Fatal(err)
+		}
+		volumes([]*zstoredhttp.Volume{v})
+	case "label":
+		if len(args) < 2 {
+			log.Fatal("label: volume name and at least one label must be specified")
+		}
+
+		v, err := c.UpdateLabels(args[0], parseLabels(args[1:], true))
+		if err != nil {
+			log.Fatal(err)
+		}
+		volumes([]*zstoredhttp.Volume{v})
+	case "rm":
+		if err := c.DestroyVolume(nameArg(flag.NewFlagSet("rm", flag.ExitOnError), args)); err != nil {
+			log.Fatal(err)
+		}
+	case "sizes":
+		ss, err := c.Sizes()
+		if err != nil {
+			log.Fatal(err)
+		}
+		sizes(ss)
+	case "pool":
+		p, err := c.Pool()
+		if err != nil {
+			log.Fatal(err)
+		}
+		pool(p)
+	default:
+		log.Printf("unknown command %q", cmd)
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// client creates a zstoreclient.Client using the configuration file and any
+// CLI flags which override it.
+func client() (*zstoreclient.Client, error) {
+	// Only an explicitly specified configuration file must exist
+	path, mustExist := configPath, true
+	if path == "" {
+		path, mustExist = defaultConfigPath(), false
+	}
+
+	cfg, err := readConfig(path, mustExist)
+	if err != nil {
+		return nil, err
+	}
+
+	if endpoint != "" {
+		cfg.Endpoint = endpoint
+	}
+	if token != "" {
+		cfg.Token = token
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "http://localhost:5000"
+	}
+
+	hc, err := cfg.httpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := zstoreclient.NewClient(cfg.Endpoint, hc)
+	if err != nil {
+		return nil, err
+	}
+	c.Token = cfg.Token
+
+	return c, nil
+}
+
+// nameArg parses a volume name and flags for a command, permitting flags to
+// appear before or after the name.
+func nameArg(fs *flag.FlagSet, args []string) string {
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		log.Fatalf("%s: volume name must be specified", fs.Name())
+	}
+
+	name := fs.Arg(0)
+	fs.Parse(fs.Args()[1:])
+	if fs.NArg() > 0 {
+		log.Fatalf("%s: unexpected arguments: %v", fs.Name(), fs.Args())
+	}
+
+	return name
+}
+
+// parseLabels parses labels in key=value form.  If remove is true, labels in
+// key- form are also accepted, and are returned with empty values so that
+// they are removed.
+func parseLabels(args []string, remove bool) map[string]string {
+	labels := make(map[string]string, len(args))
+	for _, a := range args {
+		if kv := strings.SplitN(a, "=", 2); len(kv) == 2 {
+			labels[kv[0]] = kv[1]
+			continue
+		}
+
+		if remove && strings.HasSuffix(a, "-") {
+			labels[strings.TrimSuffix(a, "-")] = ""
+			continue
+		}
+
+		log.Fatalf("invalid label %q: labels must be in key=value form", a)
+	}
+
+	return labels
+}
+
+// volumes prints volumes in the selected output format.
+func volumes(vs []*zstoredhttp.Volume) {
+	if output == "json" {
+		printJSON(os.Stdout, vs)
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSIZE\tCLASS\tORIGIN\tLABELS")
+	for _, v := range vs {
+		class := v.Class
+		if class == "" {
+			class = "-"
+		}
+
+		origin := v.Origin
+		if origin == "" {
+			origin = "-"
+		}
+
+		labels := make([]string, 0, len(v.Labels))
+		for k, v := range v.Labels {
+			labels = append(labels, k+"="+v)
+		}
+		sort.Strings(labels)
+		if len(labels) == 0 {
+			labels = []string{"-"}
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", v.Name, storage.FormatSize(v.Size), class, origin, strings.Join(labels, ","))
+	}
+	tw.Flush()
+}
+
+// sizes prints size slugs in the selected output format.
+func sizes(ss []*zstoredhttp.Size) {
+	if output == "json" {
+		printJSON(os.Stdout, ss)
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SLUG\tBYTES\tFITS")
+	for _, s := range ss {
+		fmt.Fprintf(tw, "%s\t%d\t%t\n", s.Slug, s.Size, s.Fits)
+	}
+	tw.Flush()
+}
+
+// pool prints the status of a pool in the selected output format.
+func pool(p *zstoredhttp.Pool) {
+	if output == "json" {
+		printJSON(os.Stdout, p)
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tHEALTH\tSIZE\tALLOC\tFREE\tFRAG\tDEDUP\tVOLUMES")
+	fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d%%\t%.2fx\t%d\n",
+		p.Name, p.Health,
+		storage.FormatSize(p.Size), storage.FormatSize(p.Allocated), storage.FormatSize(p.Free),
+		p.Fragmentation, p.DedupRatio, p.Volumes,
+	)
+	tw.Flush()
+}
+
+// printJSON prints a value as indented JSON.
+func printJSON(w io.Writer, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Fprintln(w, string(b))
+}
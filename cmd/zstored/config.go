@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+
+	"github.com/mdlayher/zstore/storage"
+	"github.com/mdlayher/zstore/zstored/zstoredhttp"
+)
+
+// config is the zstored configuration file format.  Sizes are the size slugs
+// which clients may use to create and resize volumes; if empty, the default
+// size slugs are used.  If ExactSizes is set, clients may also use exact
+// sizes within its limits.  Classes are the storage classes which clients
+// may select when creating volumes.  Admins are the tenant IDs which may use
+// administrative APIs.
+type config struct {
+	Sizes      []string         `json:"sizes,omitempty"`
+	ExactSizes *exactSizes      `json:"exact_sizes,omitempty"`
+	Classes    []*storage.Class `json:"classes,omitempty"`
+	Admins     []string         `json:"admins,omitempty"`
+
+	// catalog is the SlugCatalog created from Sizes, and limits are the
+	// parsed limits of ExactSizes
+	catalog *storage.SlugCatalog
+	limits  *zstoredhttp.SizeLimits
+}
+
+// exactSizes are the limits of exact volume sizes, in any form accepted by
+// storage.ParseSize.  Max must be set.
+type exactSizes struct {
+	Min string `json:"min,omitempty"`
+	Max string `json:"max"`
+}
+
+// readConfig reads and validates a configuration file.  If path is empty, an
+// empty configuration is returned.
+func readConfig(path string) (*config, error) {
+	cfg := &config{
+		catalog: storage.DefaultSlugCatalog(),
+	}
+	if path == "" {
+		return cfg, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Sizes) > 0 {
+		catalog, err := storage.NewSlugCatalog(cfg.Sizes)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.catalog = catalog
+	}
+
+	if e := cfg.ExactSizes; e != nil {
+		limits, err := e.limits()
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.limits = limits
+	}
+
+	if err := storage.ValidateClasses(cfg.Classes, cfg.catalog); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// limits parses the limits of exact volume sizes.
+func (e *exactSizes) limits() (*zstoredhttp.SizeLimits, error) {
+	if e.Max == "" {
+		return nil, errors.New("exact sizes require a maximum size")
+	}
+
+	max, err := storage.ParseSize(e.Max)
+	if err != nil {
+		return nil, err
+	}
+
+	var min uint64
+	if e.Min != "" {
+		if min, err = storage.ParseSize(e.Min); err != nil {
+			return nil, err
+		}
+	}
+
+	if min > max {
+		return nil, errors.New("minimum exact size is larger than maximum")
+	}
+
+	return &zstoredhttp.SizeLimits{
+		Min: min,
+		Max: max,
+	}, nil
+}
@@ -2,16 +2,24 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/mdlayher/zstore/storage"
 	"github.com/mdlayher/zstore/storage/zfsutil"
+	"github.com/mdlayher/zstore/zstored/zstoredauth"
 	"github.com/mdlayher/zstore/zstored/zstoredhttp"
 
 	"github.com/stretchr/graceful"
@@ -19,12 +27,61 @@ import (
 )
 
 var (
+	// configFile is the optional zstored configuration file
+	configFile string
+
 	// host is the address to which the HTTP server is bound
 	host string
+
+	// backend is the storage backend used to provision volumes
+	backend string
+
+	// fileRoot is the root directory for volumes when using the file backend
+	fileRoot string
+
+	// fileCapacity is the capacity in bytes of the file backend
+	fileCapacity uint64
+
+	// tenant is the method used to identify the tenant for each request
+	tenant string
+
+	// trusted is a comma-separated list of networks containing trusted proxies
+	trusted string
+
+	// tokensFile is the file which stores API tokens for the token tenant
+	// identifier
+	tokensFile string
+
+	// tlsCert and tlsKey are the server certificate and key files which
+	// enable HTTPS
+	tlsCert string
+	tlsKey  string
+
+	// tlsCA is the bundle of CA certificates which issue client certificates
+	tlsCA string
+
+	// tlsSAN selects client certificate DNS SANs, rather than subject common
+	// names, as tenant IDs
+	tlsSAN bool
+
+	// monitorInterval is the interval at which pool health is checked
+	monitorInterval time.Duration
 )
 
 func init() {
+	flag.StringVar(&configFile, "config", "", "configuration file containing size slugs, storage classes, and administrators")
 	flag.StringVar(&host, "host", ":5000", "HTTP server host")
+	flag.StringVar(&backend, "backend", "zfs", "storage backend [zfs, file]")
+	flag.StringVar(&fileRoot, "file.root", filepath.Join(os.TempDir(), zfsutil.ZpoolName), "root directory for file backend volumes")
+	flag.Uint64Var(&fileCapacity, "file.capacity", 0, "capacity in bytes for file backend (0 is unlimited)")
+	flag.StringVar(&tenant, "tenant", "", "tenant identifier [ip, forwarded, proxy, token, cert] (default token if -tokens is set, cert if -tls.cert is set, otherwise ip)")
+	flag.StringVar(&trusted, "trusted", "", "comma-separated CIDR networks of trusted proxies for forwarded and proxy tenant identifiers")
+	flag.StringVar(&tokensFile, "tokens", "", "API token file created by zstoretoken, for token tenant identifier")
+	flag.StringVar(&tlsCert, "tls.cert", "", "server certificate file; if set, HTTPS is served and client certificates are required")
+	flag.StringVar(&tlsKey, "tls.key", "", "server private key file")
+	flag.StringVar(&tlsCA, "tls.ca", "", "CA bundle file used to verify client certificates")
+	flag.BoolVar(&tlsSAN, "tls.san", false, "identify cert tenants by client certificate DNS SAN, rather than subject common name")
+	flag.DurationVar(&monitorInterval, "monitor.interval", 30*time.Second, "interval at which pool health is checked; volumes are read-only while the pool is not online")
 }
 
 func main() {
@@ -36,6 +93,105 @@ func main() {
 	log.SetPrefix("zstored: ")
 	log.Printf("starting [os: %s_%s] [pid: %d]", runtime.GOOS, runtime.GOARCH, os.Getpid())
 
+	// Load configuration file, if one is specified
+	cfg, err := readConfig(configFile)
+	if err != nil {
+		log.Fatalf("failed to read configuration file %q: %v", configFile, err)
+	}
+	log.Printf("size slugs: %s", cfg.catalog.Slugs())
+	if l := cfg.limits; l != nil {
+		log.Printf("exact sizes: %s to %s", storage.FormatSize(l.Min), storage.FormatSize(l.Max))
+	}
+	for _, c := range cfg.Classes {
+		sizes := c.Slugs()
+		if sizes == nil {
+			sizes = cfg.catalog.Slugs()
+		}
+
+		log.Printf("storage class: %s [properties: %v] [sizes: %s]", c.Name, c.Properties, sizes)
+	}
+
+	// Set up the storage pool for the selected backend.  ZFS pools are also
+	// checked for readiness using the same checks as on startup; other pools
+	// are ready while they are online.
+	var (
+		pool  storage.Pool
+		ready zstoredhttp.ReadinessCheck
+	)
+	switch backend {
+	case "zfs":
+		pool = zfsPool()
+		ready = zfsReady
+
+		// Report ZFS command durations and errors as metrics
+		storage.SetZFSObserver(zstoredhttp.ObserveZFS)
+	case "file":
+		pool = filePool()
+	default:
+		log.Fatalf("unknown storage backend %q [backends: zfs, file]", backend)
+	}
+
+	// Check pool health now and periodically, so that volumes become
+	// read-only while the pool is not online
+	monitor := zstoredhttp.NewPoolMonitor(pool)
+	monitor.Check()
+
+	monitorDone := make(chan struct{})
+	defer close(monitorDone)
+	go monitor.Run(monitorInterval, monitorDone)
+
+	// Listen for HTTP connections, and set up tenant identification
+	l, err := net.Listen("tcp", host)
+	if err != nil {
+		log.Fatal(err)
+	}
+	l, tenants := tenantIdentifier(l)
+
+	// If configured, serve HTTPS and require client certificates; this
+	// follows any PROXY protocol header, which is sent in plaintext
+	if tlsCert != "" {
+		l = tlsListener(l)
+	}
+
+	// Receive errors from HTTP server
+	httpErrC := make(chan error, 1)
+	go func() {
+		// Configure HTTP server
+		httpServer := graceful.Server{
+			Timeout: 10 * time.Second,
+			Server: &http.Server{
+				Addr: host,
+				Handler: zstoredhttp.NewServeMux(pool, &zstoredhttp.Config{
+					Tenants:    tenants,
+					Sizes:      cfg.catalog,
+					ExactSizes: cfg.limits,
+					Classes:    cfg.Classes,
+					Admins:     cfg.Admins,
+					Ready:      ready,
+					Monitor:    monitor,
+				}),
+			},
+		}
+
+		// Start serving HTTP
+		log.Printf("HTTP listening: %s [TLS: %t]", l.Addr(), tlsCert != "")
+		httpErrC <- httpServer.Serve(l)
+	}()
+
+	// Check for HTTP server errors
+	if err := <-httpErrC; err != nil {
+		// Ignore error when shutting down
+		if !strings.Contains(err.Error(), "use of closed network connection") {
+			log.Fatalln("HTTP server error:", err)
+		}
+	}
+
+	log.Println("graceful shutdown complete")
+}
+
+// zfsPool verifies that ZFS is available on this system, and returns a Pool
+// backed by the zstore zpool.
+func zfsPool() storage.Pool {
 	// Check if ZFS is enabled on this operating system
 	ok, err := zfsutil.IsEnabled()
 	if err != nil {
@@ -79,35 +235,144 @@ func main() {
 
 	log.Printf("zpool: %s [%s] [%03.3f / %03.3f GB, %03d%%]", zpool.Name, zpool.Health, allocGB, totalGB, percent)
 
-	// Ensure zpool is online
+	// An unhealthy zpool is not fatal, since the pool monitor serves its
+	// volumes read-only until it recovers
 	if zpool.Health != zfs.ZpoolOnline {
-		log.Fatalf("zpool %q unhealthy, status: %q; exiting", zpool.Name, zpool.Health)
+		log.Printf("zpool %q unhealthy, status: %q; volumes are read-only", zpool.Name, zpool.Health)
 	}
 
-	// Receive errors from HTTP server
-	httpErrC := make(chan error, 1)
-	go func() {
-		// Configure HTTP server
-		httpServer := graceful.Server{
-			Timeout: 10 * time.Second,
-			Server: &http.Server{
-				Addr:    host,
-				Handler: zstoredhttp.NewServeMux(storage.NewZpool(zpool)),
-			},
+	return storage.NewZpool(zpool)
+}
+
+// zfsReady returns an error if ZFS is no longer available on this system, or
+// if the zstore zpool can no longer be reached or is not online.  Unlike
+// zfsPool, it can be called repeatedly, so that the server can report when it
+// is no longer ready to serve requests.
+func zfsReady() error {
+	ok, err := zfsutil.IsEnabled()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("ZFS kernel module not loaded")
+	}
+
+	zpool, err := zfsutil.Zpool()
+	if err != nil {
+		return err
+	}
+
+	if zpool.Health != zfs.ZpoolOnline {
+		return fmt.Errorf("zpool %q unhealthy, status: %q", zpool.Name, zpool.Health)
+	}
+
+	return nil
+}
+
+// filePool returns a Pool backed by sparse files beneath the configured root
+// directory, for use on systems which do not have ZFS available.
+func filePool() storage.Pool {
+	// Ensure that the root directory can be created and used
+	if err := os.MkdirAll(fileRoot, 0700); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("file pool: %s [%s] [capacity: %d bytes]", zfsutil.ZpoolName, fileRoot, fileCapacity)
+
+	return storage.NewFilePool(zfsutil.ZpoolName, fileRoot, fileCapacity)
+}
+
+// tenantIdentifier returns the TenantIdentifier selected by CLI flags.  If
+// proxies must send the PROXY protocol, the input net.Listener is wrapped
+// so that client addresses are read from the protocol header.
+func tenantIdentifier(l net.Listener) (net.Listener, zstoredhttp.TenantIdentifier) {
+	// Default to API tokens if a token file is configured, or client
+	// certificates if TLS is configured
+	if tenant == "" {
+		switch {
+		case tokensFile != "":
+			tenant = "token"
+		case tlsCert != "":
+			tenant = "cert"
+		default:
+			tenant = "ip"
 		}
+	}
 
-		// Start listening on HTTP
-		log.Println("HTTP listening:", httpServer.Server.Addr)
-		httpErrC <- httpServer.ListenAndServe()
-	}()
+	// Parse trusted proxy networks
+	var nets []*net.IPNet
+	for _, s := range strings.Split(trusted, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
 
-	// Check for HTTP server errors
-	if err := <-httpErrC; err != nil {
-		// Ignore error when shutting down
-		if !strings.Contains(err.Error(), "use of closed network connection") {
-			log.Fatalln("HTTP server error:", err)
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			log.Fatalf("invalid trusted proxy network %q: %v", s, err)
 		}
+		nets = append(nets, n)
 	}
 
-	log.Println("graceful shutdown complete")
+	if (tenant == "forwarded" || tenant == "proxy") && len(nets) == 0 {
+		log.Fatalf("tenant identifier %q requires -trusted proxy networks", tenant)
+	}
+
+	log.Printf("tenant identifier: %s", tenant)
+
+	switch tenant {
+	case "ip":
+		return l, zstoredhttp.RemoteIP{}
+	case "forwarded":
+		return l, zstoredhttp.NewForwardedFor(nets)
+	case "proxy":
+		return zstoredhttp.NewProxyListener(l, nets), zstoredhttp.RemoteIP{}
+	case "token":
+		if tokensFile == "" {
+			log.Fatal("tenant identifier \"token\" requires -tokens file")
+		}
+
+		log.Println("API tokens:", tokensFile)
+		return l, zstoredhttp.NewBearerToken(zstoredauth.NewTokenStore(tokensFile))
+	case "cert":
+		if tlsCert == "" {
+			log.Fatal("tenant identifier \"cert\" requires -tls.cert")
+		}
+
+		return l, zstoredhttp.ClientCertificate{SAN: tlsSAN}
+	}
+
+	log.Fatalf("unknown tenant identifier %q [identifiers: ip, forwarded, proxy, token, cert]", tenant)
+	return nil, nil
+}
+
+// tlsListener wraps a net.Listener so that it serves TLS, requiring clients to
+// present certificates issued by the configured CAs.  Certificates and CAs
+// are reloaded from their files on SIGHUP; connections which are already
+// established are unaffected.
+func tlsListener(l net.Listener) net.Listener {
+	if tlsKey == "" || tlsCA == "" {
+		log.Fatal("TLS requires -tls.cert, -tls.key, and -tls.ca")
+	}
+
+	certs, err := zstoredauth.NewCertificateReloader(tlsCert, tlsKey, tlsCA)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Reload certificates on SIGHUP, keeping the current certificates if
+	// any file cannot be loaded
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, syscall.SIGHUP)
+	go func() {
+		for range sigC {
+			if err := certs.Reload(); err != nil {
+				log.Println("failed to reload TLS certificates:", err)
+				continue
+			}
+
+			log.Println("reloaded TLS certificates")
+		}
+	}()
+
+	return tls.NewListener(l, certs.TLSConfig())
 }
@@ -0,0 +1,91 @@
+// Command zstoretoken provides an administrative utility which mints, revokes,
+// and lists API tokens used by zstored to identify tenants.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mdlayher/zstore/zstored/zstoredauth"
+)
+
+var (
+	// file is the API token file which is shared with zstored
+	file string
+
+	// tenant is the tenant ID for which a token is minted
+	tenant string
+)
+
+func init() {
+	flag.StringVar(&file, "file", "tokens.json", "API token file used by zstored")
+	flag.StringVar(&tenant, "tenant", "", "tenant ID for minted token")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] mint -tenant ID | revoke TOKEN_ID | ls\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	// Parse CLI flags
+	flag.Parse()
+
+	// Set up logging
+	log.SetFlags(0)
+	log.SetPrefix("zstoretoken: ")
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	tokens := zstoredauth.NewTokenStore(file)
+
+	switch cmd := flag.Arg(0); cmd {
+	case "mint":
+		if tenant == "" {
+			log.Fatal("tenant ID must be specified with -tenant")
+		}
+
+		token, t, err := tokens.Mint(tenant)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// Token is only displayed once, so print its ID to stderr so the
+		// token itself may be captured from stdout
+		log.Printf("minted token %s for tenant %q", t.ID, t.Tenant)
+		fmt.Println(token)
+	case "revoke":
+		if flag.NArg() != 2 {
+			log.Fatal("token ID must be specified")
+		}
+
+		if err := tokens.Revoke(flag.Arg(1)); err != nil {
+			log.Fatal(err)
+		}
+	case "ls":
+		ts, err := tokens.Tokens()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tTENANT\tCREATED\tREVOKED")
+		for _, t := range ts {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%t\n", t.ID, t.Tenant, t.Created.Format(time.RFC3339), t.Revoked)
+		}
+		if err := tw.Flush(); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Printf("unknown command %q", cmd)
+		flag.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrInvalidClass is returned when a storage class is not valid, or
+	// when an unknown storage class is selected.
+	ErrInvalidClass = errors.New("invalid storage class")
+)
+
+const (
+	// classProperty is the ZFS user property which records the storage
+	// class of a volume
+	classProperty = "zstore:class"
+)
+
+var (
+	// classNameRegexp matches valid storage class names.
+	classNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+	// propertyNameRegexp matches valid names of native ZFS properties.
+	propertyNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+)
+
+// reservedProperties are ZFS properties which zstore manages itself, and
+// which a storage class may not set.
+var reservedProperties = map[string]struct{}{
+	"volsize": {},
+}
+
+// A Class is a storage class, configured by an administrator, which bundles a
+// set of ZFS properties behind a name which describes the intended use of
+// a volume, such as "fast" or "archive".
+//
+// Properties are the ZFS properties set on each volume of the class, and
+// Sizes are the size slugs which volumes of the class may use.  If Sizes is
+// empty, any size slug in the server's SlugCatalog may be used.
+type Class struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Properties  map[string]string `json:"properties,omitempty"`
+	Sizes       []string          `json:"sizes,omitempty"`
+}
+
+// Validate returns ErrInvalidClass if a storage class has an invalid name,
+// attempts to set ZFS properties which are not native properties or which
+// are managed by zstore, or permits invalid size slugs.
+func (c *Class) Validate() error {
+	if !classNameRegexp.MatchString(c.Name) {
+		return ErrInvalidClass
+	}
+
+	for k, v := range c.Properties {
+		if !propertyNameRegexp.MatchString(k) || v == "" || strings.ContainsAny(v, "\t\n") {
+			return ErrInvalidClass
+		}
+
+		if _, ok := reservedProperties[k]; ok {
+			return ErrInvalidClass
+		}
+	}
+
+	for _, s := range c.Sizes {
+		if _, err := parseSlug(s); err != nil {
+			return ErrInvalidClass
+		}
+	}
+
+	return nil
+}
+
+// AllowsSize determines if a volume of this storage class may use the
+// specified size slug.
+func (c *Class) AllowsSize(slug string) bool {
+	if len(c.Sizes) == 0 {
+		return true
+	}
+
+	for _, s := range c.Sizes {
+		if s == slug {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Slugs returns a sorted list of the size slugs which volumes of this storage
+// class may use.  If the class permits any size slug, nil is returned.
+func (c *Class) Slugs() []string {
+	if len(c.Sizes) == 0 {
+		return nil
+	}
+
+	slugs := make([]string, len(c.Sizes))
+	copy(slugs, c.Sizes)
+
+	sort.Sort(bySizeSlug(slugs))
+	return slugs
+}
+
+// sparse determines if a storage class creates sparse volumes, with no
+// space reserved.
+func (c *Class) sparse() bool {
+	return c != nil && c.Properties["refreservation"] == "none"
+}
+
+// ValidateClasses returns ErrInvalidClass if any storage class is invalid,
+// permits a size slug which is not in catalog, or if more than one storage
+// class has the same name.
+func ValidateClasses(classes []*Class, catalog *SlugCatalog) error {
+	seen := make(map[string]struct{}, len(classes))
+	for _, c := range classes {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+
+		for _, s := range c.Sizes {
+			if _, ok := catalog.SlugSize(s); !ok {
+				return ErrInvalidClass
+			}
+		}
+
+		if _, ok := seen[c.Name]; ok {
+			return ErrInvalidClass
+		}
+		seen[c.Name] = struct{}{}
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestClassValidate verifies that invalid storage classes are rejected.
+func TestClassValidate(t *testing.T) {
+	var tests = []struct {
+		description string
+		class       *Class
+		ok          bool
+	}{
+		{
+			description: "valid class",
+			class: &Class{
+				Name: "db",
+				Properties: map[string]string{
+					"volblocksize": "16384",
+					"logbias":      "latency",
+				},
+				Sizes: []string{"1G", "2G"},
+			},
+			ok: true,
+		},
+		{
+			description: "invalid name",
+			class:       &Class{Name: "Fast!"},
+		},
+		{
+			description: "user property",
+			class: &Class{
+				Name:       "fast",
+				Properties: map[string]string{"zstore:class": "slow"},
+			},
+		},
+		{
+			description: "reserved property",
+			class: &Class{
+				Name:       "fast",
+				Properties: map[string]string{"volsize": "1G"},
+			},
+		},
+		{
+			description: "empty property value",
+			class: &Class{
+				Name:       "fast",
+				Properties: map[string]string{"logbias": ""},
+			},
+		},
+		{
+			description: "invalid size slug",
+			class: &Class{
+				Name:  "fast",
+				Sizes: []string{"3X"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		if ok := test.class.Validate() == nil; ok != test.ok {
+			t.Fatalf("unexpected result: %v != %v [description: %s]", ok, test.ok, test.description)
+		}
+	}
+
+	// Class names must be unique
+	catalog := DefaultSlugCatalog()
+	if err := ValidateClasses([]*Class{{Name: "fast"}, {Name: "fast"}}, catalog); err != ErrInvalidClass {
+		t.Fatalf("unexpected error for duplicate classes: %v != %v", err, ErrInvalidClass)
+	}
+
+	// Class sizes must be in the catalog
+	if err := ValidateClasses([]*Class{{Name: "big", Sizes: []string{"16G"}}}, catalog); err != ErrInvalidClass {
+		t.Fatalf("unexpected error for class with unknown size: %v != %v", err, ErrInvalidClass)
+	}
+}
+
+// TestClassSizes verifies that storage classes permit only their own sizes,
+// or all sizes if none are specified.
+func TestClassSizes(t *testing.T) {
+	archive := &Class{
+		Name:  "archive",
+		Sizes: []string{"8G", "4G"},
+	}
+	if s := archive.Slugs(); !reflect.DeepEqual(s, []string{"4G", "8G"}) {
+		t.Fatalf("unexpected sizes: %v", s)
+	}
+	if archive.AllowsSize("1G") || !archive.AllowsSize("4G") {
+		t.Fatal("archive class allowed unexpected sizes")
+	}
+
+	fast := &Class{Name: "fast"}
+	if s := fast.Slugs(); s != nil || !fast.AllowsSize("1G") {
+		t.Fatalf("unexpected sizes for unrestricted class: %v", s)
+	}
+}
@@ -0,0 +1,1083 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/zstore/storage/zfsutil"
+
+	"gopkg.in/mistifyio/go-zfs.v2"
+)
+
+// FilePool is a sparse file-backed implementation of Pool.  It enables zstored
+// to run on systems which do not have ZFS available.  Buckets are mapped to
+// directories beneath a root directory, and volumes are mapped to sparse files
+// within those directories.  Snapshots are sparse copies of a volume's file,
+// stored alongside it using the ZFS 'volume@snapshot' naming convention.
+// Metadata, such as the origin of a clone, is stored in a hidden file
+// alongside each volume's file.
+type FilePool struct {
+	name     string
+	root     string
+	capacity uint64
+
+	// mu serializes volume and snapshot creation, so that capacity checks
+	// cannot race
+	mu sync.Mutex
+}
+
+// NewFilePool creates a new FilePool with the specified name, which stores
+// its volumes beneath the root directory.  If capacity is 0, the pool will
+// never run out of space.
+func NewFilePool(name string, root string, capacity uint64) *FilePool {
+	return &FilePool{
+		name:     name,
+		root:     root,
+		capacity: capacity,
+	}
+}
+
+// Name returns the name of a FilePool.
+func (p *FilePool) Name() string {
+	return p.name
+}
+
+// Free returns the number of bytes of a FilePool's capacity which are not
+// allocated to volumes.  If the pool has no capacity, math.MaxUint64 is
+// returned.
+func (p *FilePool) Free() (uint64, error) {
+	if p.capacity == 0 {
+		return math.MaxUint64, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n, err := p.allocated()
+	if err != nil {
+		return 0, err
+	}
+
+	if n < p.capacity {
+		return p.capacity - n, nil
+	}
+
+	return 0, nil
+}
+
+// Status returns the current capacity of a FilePool, and the number of
+// volumes within it and each of its buckets.  A FilePool is always online,
+// and does not support fragmentation tracking or deduplication.
+func (p *FilePool) Status() (*PoolStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	allocated, err := p.allocated()
+	if err != nil {
+		return nil, err
+	}
+
+	buckets, err := p.bucketVolumes()
+	if err != nil {
+		return nil, err
+	}
+
+	var volumes int
+	for _, n := range buckets {
+		volumes += n
+	}
+
+	s := &PoolStatus{
+		Name:       p.name,
+		Health:     zfs.ZpoolOnline,
+		Allocated:  allocated,
+		Size:       p.capacity,
+		DedupRatio: 1,
+		Volumes:    volumes,
+		Buckets:    buckets,
+	}
+	if allocated < p.capacity {
+		s.Free = p.capacity - allocated
+	}
+
+	return s, nil
+}
+
+// Stats returns the I/O statistics of a FilePool.  I/O to backing files is
+// not tracked, so no statistics are reported.
+func (p *FilePool) Stats() (*PoolStats, error) {
+	return &PoolStats{}, nil
+}
+
+// CreateVolume creates a new FileVolume from a FilePool with the specified
+// name, size in bytes, and optional creation options.  Any parent bucket
+// directories are created as needed.  Backing files are always sparse, and
+// files cannot be compressed or have their block size changed, so options are
+// validated but otherwise ignored, except for recording the storage class.
+func (p *FilePool) CreateVolume(name string, size uint64, options *VolumeOptions) (Volume, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	file, err := p.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Check if pool has the capacity to store this volume
+	if err := p.checkCapacity(size); err != nil {
+		return nil, err
+	}
+
+	f, err := p.create(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// Extend the file to the requested size without allocating any blocks
+	if err := f.Truncate(int64(size)); err != nil {
+		f.Close()
+		os.Remove(file)
+		return nil, err
+	}
+
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	// Record the creation time of the volume, since files do not portably
+	// record their creation time
+	if err := writeFileMetadata(file, &fileMetadata{
+		Created: time.Now().Unix(),
+		Class:   options.className(),
+	}); err != nil {
+		os.Remove(file)
+		return nil, err
+	}
+
+	return &FileVolume{
+		pool: p,
+		name: name,
+		file: file,
+		size: size,
+	}, nil
+}
+
+// CloneVolume creates a new FileVolume from a FilePool with the specified name,
+// which is a clone of the named snapshot of the source volume.  Unlike a ZFS
+// clone, the new volume is a sparse copy of the snapshot's backing file, so
+// it consumes additional capacity.
+func (p *FilePool) CloneVolume(name string, source string, snapshot string) (Volume, error) {
+	if !validSnapshotName(snapshot) {
+		return nil, ErrInvalidSnapshotName
+	}
+
+	file, err := p.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure source volume and snapshot exist
+	v, err := p.Volume(source)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := os.Open(v.(*FileVolume).snapshotFile(snapshot))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrSnapshotNotExists
+		}
+
+		return nil, err
+	}
+	defer src.Close()
+
+	// Like a ZFS clone, the clone takes the size of the volume at the time
+	// of the snapshot
+	fi, err := src.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := uint64(fi.Size())
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Check if pool has the capacity to store a full copy of the snapshot
+	if err := p.checkCapacity(size); err != nil {
+		return nil, err
+	}
+
+	f, err := p.create(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := copySparse(f, src); err != nil {
+		f.Close()
+		os.Remove(file)
+		return nil, err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(file)
+		return nil, err
+	}
+
+	// Record the origin of the clone, so that it can be reported and so that
+	// its origin snapshot cannot be destroyed
+	origin := source + "@" + snapshot
+	if err := writeFileMetadata(file, &fileMetadata{
+		Origin:  origin,
+		Created: time.Now().Unix(),
+	}); err != nil {
+		os.Remove(file)
+		return nil, err
+	}
+
+	return &FileVolume{
+		pool:   p,
+		name:   name,
+		file:   file,
+		size:   size,
+		origin: origin,
+	}, nil
+}
+
+// ListVolumes returns a list of all volumes which belong in the specified bucket,
+// typically by user.
+func (p *FilePool) ListVolumes(bucket string) ([]Volume, error) {
+	dir, err := p.path(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	// Attempt to read bucket directory
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		// If bucket does not exist, return not exists
+		if os.IsNotExist(err) {
+			return nil, ErrVolumeNotExists
+		}
+
+		// All other errors
+		return nil, err
+	}
+
+	// Generate output list of volumes
+	var volumes []Volume
+	for _, fi := range fis {
+		// Skip any non-volume files, including snapshots and metadata
+		if !fi.Mode().IsRegular() || strings.Contains(fi.Name(), "@") || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+
+		file := filepath.Join(dir, fi.Name())
+		m, err := readFileMetadata(file)
+		if err != nil {
+			return nil, err
+		}
+
+		// Add volume to slice
+		volumes = append(volumes, &FileVolume{
+			pool:   p,
+			name:   bucket + "/" + fi.Name(),
+			file:   file,
+			size:   uint64(fi.Size()),
+			origin: m.Origin,
+		})
+	}
+
+	return volumes, nil
+}
+
+// Volume attempts to retrieve a FileVolume from a FilePool by its name.
+func (p *FilePool) Volume(name string) (Volume, error) {
+	file, err := p.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Attempt to fetch volume file by name
+	fi, err := os.Stat(file)
+	if err != nil {
+		// If file does not exist, return not exists
+		if os.IsNotExist(err) {
+			return nil, ErrVolumeNotExists
+		}
+
+		// All other errors
+		return nil, err
+	}
+
+	// Ensure file is a volume; if not, tell client the volume does not exist
+	if !fi.Mode().IsRegular() {
+		return nil, ErrVolumeNotExists
+	}
+
+	m, err := readFileMetadata(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileVolume{
+		pool:   p,
+		name:   name,
+		file:   file,
+		size:   uint64(fi.Size()),
+		origin: m.Origin,
+	}, nil
+}
+
+// path maps a dataset name within a FilePool to a path on the filesystem.
+func (p *FilePool) path(name string) (string, error) {
+	// Ensure dataset is within this pool, and cannot escape its root
+	if !strings.HasPrefix(name, p.name+"/") {
+		return "", fmt.Errorf("dataset %q is not in pool %q", name, p.name)
+	}
+
+	// Snapshot names are reserved for snapshot files
+	if strings.Contains(name, "@") {
+		return "", fmt.Errorf("invalid dataset name: %q", name)
+	}
+
+	// Names beginning with a dot are reserved for metadata files, which also
+	// prevents escaping the root directory
+	for _, c := range strings.Split(name[len(p.name)+1:], "/") {
+		if c == "" || strings.HasPrefix(c, ".") {
+			return "", fmt.Errorf("invalid dataset name: %q", name)
+		}
+	}
+
+	return filepath.Join(p.root, filepath.FromSlash(name[len(p.name)+1:])), nil
+}
+
+// create creates all parent bucket directories and a new, empty file at the
+// specified path, failing if any file or directory already exists with the
+// same name.  The caller must hold p.mu.
+func (p *FilePool) create(file string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, ErrVolumeExists
+		}
+
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// checkCapacity returns ErrPoolOutOfSpace if the pool does not have the
+// capacity to store an additional size bytes.  The caller must hold p.mu.
+func (p *FilePool) checkCapacity(size uint64) error {
+	if p.capacity == 0 {
+		return nil
+	}
+
+	allocated, err := p.allocated()
+	if err != nil {
+		return err
+	}
+
+	if allocated+size > p.capacity {
+		return ErrPoolOutOfSpace
+	}
+
+	return nil
+}
+
+// hasClones determines if any volume in the pool is a clone of the named
+// snapshot.
+func (p *FilePool) hasClones(origin string) (bool, error) {
+	var found bool
+	err := filepath.Walk(p.root, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Only metadata files record an origin, and temporary files are
+		// skipped
+		if !fi.Mode().IsRegular() || !strings.HasPrefix(fi.Name(), ".") || strings.Contains(fi.Name(), "@") {
+			return nil
+		}
+
+		m, err := readFileMetadata(filepath.Join(filepath.Dir(file), fi.Name()[1:]))
+		if err != nil {
+			return err
+		}
+
+		if m.Origin == origin {
+			found = true
+		}
+
+		return nil
+	})
+
+	// An empty pool may not have created its root directory yet
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	return found, nil
+}
+
+// allocated returns the total number of bytes allocated to volumes in the
+// pool.  Sparse files are counted at their full size, since they may be
+// filled at any time.
+func (p *FilePool) allocated() (uint64, error) {
+	var n uint64
+	err := filepath.Walk(p.root, func(_ string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.Mode().IsRegular() && !strings.HasPrefix(fi.Name(), ".") {
+			n += uint64(fi.Size())
+		}
+
+		return nil
+	})
+
+	// An empty pool may not have created its root directory yet
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// bucketVolumes returns the number of volumes in each bucket of the pool
+// which contains volumes.
+func (p *FilePool) bucketVolumes() (map[string]int, error) {
+	buckets := make(map[string]int)
+	err := filepath.Walk(p.root, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Skip any non-volume files, including snapshots and metadata
+		if !fi.Mode().IsRegular() || strings.Contains(fi.Name(), "@") || strings.HasPrefix(fi.Name(), ".") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(p.root, filepath.Dir(file))
+		if err != nil {
+			return err
+		}
+
+		buckets[p.name+"/"+filepath.ToSlash(rel)]++
+		return nil
+	})
+
+	// An empty pool may not have created its root directory yet
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return buckets, nil
+}
+
+// FileVolume is a sparse file-backed implementation of Volume, which is
+// allocated from a FilePool.
+type FileVolume struct {
+	pool   *FilePool
+	name   string
+	file   string
+	size   uint64
+	origin string
+}
+
+// Destroy completely destroys this volume, removing its backing file and
+// the files for all of its snapshots.
+func (v *FileVolume) Destroy() error {
+	v.pool.mu.Lock()
+	defer v.pool.mu.Unlock()
+
+	snapshots, err := v.Snapshots()
+	if err != nil {
+		return err
+	}
+
+	// Snapshots with clones cannot be destroyed
+	for _, s := range snapshots {
+		ok, err := v.pool.hasClones(v.name + "@" + s.Name)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return ErrDependentClones
+		}
+	}
+
+	if err := os.Remove(v.file); err != nil {
+		// If file does not exist, return not exists
+		if os.IsNotExist(err) {
+			return ErrVolumeNotExists
+		}
+
+		return err
+	}
+
+	for _, s := range snapshots {
+		if err := os.Remove(v.snapshotFile(s.Name)); err != nil {
+			return err
+		}
+	}
+
+	// Metadata only exists for some volumes
+	if err := os.Remove(fileMetadataPath(v.file)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Resize sets the size of this volume to the specified size in bytes, by
+// truncating or extending its backing file.
+func (v *FileVolume) Resize(size uint64) error {
+	v.pool.mu.Lock()
+	defer v.pool.mu.Unlock()
+
+	// Ensure volume was not already destroyed
+	if err := v.exists(); err != nil {
+		return err
+	}
+
+	// Check if pool has the capacity to grow this volume
+	if size > v.size {
+		if err := v.pool.checkCapacity(size - v.size); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Truncate(v.file, int64(size)); err != nil {
+		return err
+	}
+
+	v.size = size
+	return nil
+}
+
+// Labels returns the labels of this volume, which are stored in its metadata.
+func (v *FileVolume) Labels() (map[string]string, error) {
+	v.pool.mu.Lock()
+	defer v.pool.mu.Unlock()
+
+	// Ensure volume was not already destroyed
+	if err := v.exists(); err != nil {
+		return nil, err
+	}
+
+	m, err := readFileMetadata(v.file)
+	if err != nil {
+		return nil, err
+	}
+
+	return copyLabels(m.Labels), nil
+}
+
+// SetLabels replaces the labels of this volume in its metadata.
+func (v *FileVolume) SetLabels(labels map[string]string) error {
+	if err := ValidateLabels(labels); err != nil {
+		return err
+	}
+
+	v.pool.mu.Lock()
+	defer v.pool.mu.Unlock()
+
+	// Ensure volume was not already destroyed
+	if err := v.exists(); err != nil {
+		return err
+	}
+
+	m, err := readFileMetadata(v.file)
+	if err != nil {
+		return err
+	}
+
+	m.Labels = copyLabels(labels)
+	return writeFileMetadata(v.file, m)
+}
+
+// Name returns the name of a FileVolume.
+func (v *FileVolume) Name() string {
+	return v.name
+}
+
+// Size returns the size of a FileVolume.
+func (v *FileVolume) Size() uint64 {
+	v.pool.mu.Lock()
+	defer v.pool.mu.Unlock()
+
+	return v.size
+}
+
+// Origin returns the name of the snapshot from which a FileVolume was cloned,
+// or an empty string if it is not a clone.
+func (v *FileVolume) Origin() string {
+	return v.origin
+}
+
+// Metadata returns metadata about this volume.  Space usage is the number of
+// bytes allocated on disk for the volume's sparse backing file, and for the
+// backing files of its snapshots.  The device is the backing file itself.
+func (v *FileVolume) Metadata() (*VolumeMetadata, error) {
+	v.pool.mu.Lock()
+	defer v.pool.mu.Unlock()
+
+	fi, err := os.Stat(v.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrVolumeNotExists
+		}
+
+		return nil, err
+	}
+
+	snapshots, err := v.Snapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := readFileMetadata(v.file)
+	if err != nil {
+		return nil, err
+	}
+
+	// Volumes created before creation times were recorded fall back to
+	// their modification time
+	created := fi.ModTime()
+	if m.Created != 0 {
+		created = time.Unix(m.Created, 0)
+	}
+
+	referenced := diskUsage(fi)
+	used := referenced
+	for _, s := range snapshots {
+		used += s.Used
+	}
+
+	return &VolumeMetadata{
+		Used:          used,
+		Referenced:    referenced,
+		CompressRatio: 1,
+		Created:       created,
+		Device:        v.file,
+		Class:         m.Class,
+	}, nil
+}
+
+// Stats returns the I/O statistics of this volume.  I/O to backing files is
+// not tracked, so nil is returned.
+func (v *FileVolume) Stats() (*zfsutil.IOStats, error) {
+	return nil, nil
+}
+
+// CreateSnapshot creates a new snapshot of this volume with the specified name,
+// by making a sparse copy of the volume's backing file.
+func (v *FileVolume) CreateSnapshot(name string) (*Snapshot, error) {
+	if !validSnapshotName(name) {
+		return nil, ErrInvalidSnapshotName
+	}
+
+	v.pool.mu.Lock()
+	defer v.pool.mu.Unlock()
+
+	// Open the volume file to be copied
+	src, err := os.Open(v.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrVolumeNotExists
+		}
+
+		return nil, err
+	}
+	defer src.Close()
+
+	// Check if pool has the capacity to store a full copy of this volume
+	if err := v.pool.checkCapacity(v.size); err != nil {
+		return nil, err
+	}
+
+	// Create the snapshot file, failing if it already exists
+	file := v.snapshotFile(name)
+	dst, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0400)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, ErrSnapshotExists
+		}
+
+		return nil, err
+	}
+
+	if err := copySparse(dst, src); err != nil {
+		dst.Close()
+		os.Remove(file)
+		return nil, err
+	}
+
+	if err := dst.Close(); err != nil {
+		return nil, err
+	}
+
+	// Record the snapshot in the volume's metadata, since file timestamps may
+	// not be precise enough to order snapshots
+	m, err := readFileMetadata(v.file)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Snapshots = append(m.Snapshots, name)
+	if err := writeFileMetadata(v.file, m); err != nil {
+		os.Remove(file)
+		return nil, err
+	}
+
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return fileSnapshot(name, fi), nil
+}
+
+// Snapshots returns a list of all snapshots of this volume, ordered from
+// oldest to newest.
+func (v *FileVolume) Snapshots() ([]*Snapshot, error) {
+	// Ensure volume was not already destroyed
+	if err := v.exists(); err != nil {
+		return nil, err
+	}
+
+	m, err := readFileMetadata(v.file)
+	if err != nil {
+		return nil, err
+	}
+
+	// Snapshots are recorded in metadata in the order they were created
+	snapshots := make([]*Snapshot, 0, len(m.Snapshots))
+	for _, name := range m.Snapshots {
+		fi, err := os.Stat(v.snapshotFile(name))
+		if err != nil {
+			return nil, err
+		}
+
+		snapshots = append(snapshots, fileSnapshot(name, fi))
+	}
+
+	return snapshots, nil
+}
+
+// DestroySnapshot destroys a single snapshot of this volume by name.
+func (v *FileVolume) DestroySnapshot(name string) error {
+	if !validSnapshotName(name) {
+		return ErrInvalidSnapshotName
+	}
+
+	v.pool.mu.Lock()
+	defer v.pool.mu.Unlock()
+
+	// Snapshots with clones cannot be destroyed
+	ok, err := v.pool.hasClones(v.name + "@" + name)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return ErrDependentClones
+	}
+
+	if err := os.Remove(v.snapshotFile(name)); err != nil {
+		// If file does not exist, return not exists
+		if os.IsNotExist(err) {
+			return ErrSnapshotNotExists
+		}
+
+		return err
+	}
+
+	m, err := readFileMetadata(v.file)
+	if err != nil {
+		return err
+	}
+
+	for i, s := range m.Snapshots {
+		if s == name {
+			m.Snapshots = append(m.Snapshots[:i], m.Snapshots[i+1:]...)
+			break
+		}
+	}
+
+	return writeFileMetadata(v.file, m)
+}
+
+// Rollback reverts this volume to the named snapshot, by copying the snapshot's
+// backing file over the volume's backing file, which also restores the
+// volume's size at the time of the snapshot.  ErrPoolOutOfSpace is returned
+// if the pool lacks the capacity to restore a larger size.  If destroyNewer
+// is true, any snapshots newer than the named snapshot are destroyed;
+// otherwise, ErrNewerSnapshotsExist is returned if any exist.
+func (v *FileVolume) Rollback(name string, destroyNewer bool) error {
+	if !validSnapshotName(name) {
+		return ErrInvalidSnapshotName
+	}
+
+	v.pool.mu.Lock()
+	defer v.pool.mu.Unlock()
+
+	snapshots, err := v.Snapshots()
+	if err != nil {
+		return err
+	}
+
+	// Find the named snapshot; any which follow it are newer
+	i := -1
+	for j, s := range snapshots {
+		if s.Name == name {
+			i = j
+			break
+		}
+	}
+	if i == -1 {
+		return ErrSnapshotNotExists
+	}
+
+	newer := snapshots[i+1:]
+	if len(newer) > 0 && !destroyNewer {
+		return ErrNewerSnapshotsExist
+	}
+
+	// Newer snapshots with clones cannot be destroyed
+	for _, s := range newer {
+		ok, err := v.pool.hasClones(v.name + "@" + s.Name)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return ErrDependentClones
+		}
+	}
+
+	src, err := os.Open(v.snapshotFile(name))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	sfi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(v.file)
+	if err != nil {
+		return err
+	}
+
+	// Check if pool has the capacity to restore a snapshot which is larger
+	// than the volume
+	size := uint64(sfi.Size())
+	if current := uint64(fi.Size()); size > current {
+		if err := v.pool.checkCapacity(size - current); err != nil {
+			return err
+		}
+	}
+
+	// Copy the snapshot's contents to a temporary file and rename it over
+	// the volume, so that a failed copy leaves the volume intact
+	dst, err := createTemp(v.file)
+	if err != nil {
+		return err
+	}
+
+	if err := copySparse(dst, src); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return err
+	}
+
+	if err := dst.Close(); err != nil {
+		os.Remove(dst.Name())
+		return err
+	}
+
+	if err := os.Rename(dst.Name(), v.file); err != nil {
+		os.Remove(dst.Name())
+		return err
+	}
+
+	v.size = size
+
+	// Destroy newer snapshots only after a successful rollback
+	if len(newer) == 0 {
+		return nil
+	}
+
+	for _, s := range newer {
+		if err := os.Remove(v.snapshotFile(s.Name)); err != nil {
+			return err
+		}
+	}
+
+	m, err := readFileMetadata(v.file)
+	if err != nil {
+		return err
+	}
+
+	m.Snapshots = m.Snapshots[:i+1]
+	return writeFileMetadata(v.file, m)
+}
+
+// exists returns ErrVolumeNotExists if this volume's backing file no longer
+// exists.
+func (v *FileVolume) exists() error {
+	if _, err := os.Stat(v.file); err != nil {
+		if os.IsNotExist(err) {
+			return ErrVolumeNotExists
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// snapshotFile returns the path to the backing file for the named snapshot.
+func (v *FileVolume) snapshotFile(name string) string {
+	return v.file + "@" + name
+}
+
+// fileMetadata is metadata about a FileVolume which cannot be stored in the
+// volume's backing file.  It is stored as JSON in a hidden file alongside the
+// backing file.
+type fileMetadata struct {
+	Origin    string            `json:"origin,omitempty"`
+	Created   int64             `json:"created,omitempty"`
+	Class     string            `json:"class,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Snapshots []string          `json:"snapshots,omitempty"`
+}
+
+// fileMetadataPath returns the path to the metadata file for a volume's
+// backing file.
+func fileMetadataPath(file string) string {
+	return filepath.Join(filepath.Dir(file), "."+filepath.Base(file))
+}
+
+// readFileMetadata reads the metadata for a volume's backing file.  If no
+// metadata exists, empty metadata is returned.
+func readFileMetadata(file string) (*fileMetadata, error) {
+	m := new(fileMetadata)
+
+	b, err := ioutil.ReadFile(fileMetadataPath(file))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// writeFileMetadata atomically writes the metadata for a volume's backing
+// file.
+func writeFileMetadata(file string, m *fileMetadata) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it, so that a concurrent read
+	// never observes partially written metadata
+	f, err := createTemp(file)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := os.Rename(f.Name(), fileMetadataPath(file)); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
+
+// createTemp creates a temporary file alongside a volume's backing file, which
+// may be renamed over the backing file or its metadata.  Temporary files are
+// hidden and contain '@', so they are never mistaken for volumes, snapshots,
+// or metadata.
+func createTemp(file string) (*os.File, error) {
+	return ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+"@")
+}
+
+// fileSnapshot creates a Snapshot using information from its backing file.
+// Snapshot files are never modified after creation, so their modification
+// time is also their creation time.
+func fileSnapshot(name string, fi os.FileInfo) *Snapshot {
+	return &Snapshot{
+		Name:    name,
+		Created: fi.ModTime(),
+		Used:    diskUsage(fi),
+	}
+}
+
+// copySparse copies the contents of src to dst, skipping over any blocks
+// which contain only zeros, so that dst remains sparse.
+func copySparse(dst *os.File, src *os.File) error {
+	buf := make([]byte, 64*1024)
+	zero := make([]byte, len(buf))
+
+	var off int64
+	for {
+		n, err := src.Read(buf)
+		if n > 0 && !bytes.Equal(buf[:n], zero[:n]) {
+			if _, err := dst.WriteAt(buf[:n], off); err != nil {
+				return err
+			}
+		}
+		off += int64(n)
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Extend file to full size, in case it ends with zero blocks
+	return dst.Truncate(off)
+}
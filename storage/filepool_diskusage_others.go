@@ -0,0 +1,13 @@
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package storage
+
+import (
+	"os"
+)
+
+// diskUsage returns the size of a file on operating systems where the number
+// of bytes allocated on disk cannot be determined.
+func diskUsage(fi os.FileInfo) uint64 {
+	return uint64(fi.Size())
+}
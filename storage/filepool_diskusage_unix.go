@@ -0,0 +1,19 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// diskUsage returns the number of bytes allocated on disk for a file, which
+// may be less than its size if the file is sparse.
+func diskUsage(fi os.FileInfo) uint64 {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return uint64(fi.Size())
+	}
+
+	return uint64(st.Blocks) * 512
+}
@@ -0,0 +1,584 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// TestFilePoolCreateVolume verifies that FilePool.CreateVolume creates sparse
+// files and enforces pool naming, duplicate names, and capacity.
+func TestFilePoolCreateVolume(t *testing.T) {
+	pool, done := testFilePool(t, 1*GB)
+	defer done()
+
+	// Create a volume within a bucket
+	volume, err := pool.CreateVolume("zstore/foo/bar", 512*MB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := volume.Name(); name != "zstore/foo/bar" {
+		t.Fatalf("unexpected volume name: %v != %v", name, "zstore/foo/bar")
+	}
+
+	// Verify backing file exists with the correct size
+	fi, err := os.Stat(filepath.Join(pool.root, "foo", "bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := fi.Size(); size != 512*MB {
+		t.Fatalf("unexpected file size: %v != %v", size, 512*MB)
+	}
+
+	// Duplicate volumes and buckets cannot be created
+	if _, err := pool.CreateVolume("zstore/foo/bar", 256*MB, nil); err != ErrVolumeExists {
+		t.Fatalf("unexpected error for duplicate volume: %v != %v", err, ErrVolumeExists)
+	}
+	if _, err := pool.CreateVolume("zstore/foo", 256*MB, nil); err != ErrVolumeExists {
+		t.Fatalf("unexpected error for duplicate bucket: %v != %v", err, ErrVolumeExists)
+	}
+
+	// Volumes cannot be created outside the pool or its root directory
+	for _, name := range []string{
+		"other/foo/bar",
+		"zstore/../foo",
+		"zstore/foo/../../bar",
+	} {
+		if _, err := pool.CreateVolume(name, 256*MB, nil); err == nil {
+			t.Fatalf("expected error for volume %q", name)
+		}
+	}
+
+	// Pool capacity is enforced
+	if _, err := pool.CreateVolume("zstore/foo/baz", 1*GB, nil); err != ErrPoolOutOfSpace {
+		t.Fatalf("unexpected error for full pool: %v != %v", err, ErrPoolOutOfSpace)
+	}
+	if _, err := pool.CreateVolume("zstore/foo/baz", 512*MB, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestFilePoolFree verifies that FilePool.Free reports the capacity which is not
+// allocated to volumes.
+func TestFilePoolFree(t *testing.T) {
+	pool, done := testFilePool(t, 1*GB)
+	defer done()
+
+	var tests = []struct {
+		description string
+		name        string
+		size        uint64
+		options     *VolumeOptions
+		free        uint64
+	}{
+		{
+			description: "empty pool",
+			free:        1 * GB,
+		},
+		{
+			description: "allocated volume",
+			name:        "zstore/foo/bar",
+			size:        256 * MB,
+			free:        768 * MB,
+		},
+	}
+
+	for _, test := range tests {
+		if test.name != "" {
+			if _, err := pool.CreateVolume(test.name, test.size, test.options); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		free, err := pool.Free()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if free != test.free {
+			t.Fatalf("unexpected free space: %v != %v [description: %s]", free, test.free, test.description)
+		}
+	}
+
+	// Pools without a capacity have unlimited space
+	if free, _ := NewFilePool("zstore", "", 0).Free(); free != math.MaxUint64 {
+		t.Fatalf("unexpected free space: %v != %v", free, uint64(math.MaxUint64))
+	}
+}
+
+// TestFilePoolStatus verifies that FilePool.Status reports the capacity and volume
+// count of the pool.
+func TestFilePoolStatus(t *testing.T) {
+	pool, done := testFilePool(t, 1*GB)
+	defer done()
+
+	volume, err := pool.CreateVolume("zstore/foo/bar", 256*MB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.CreateVolume("zstore/baz/qux", 256*MB, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Snapshots are not volumes
+	if _, err := volume.CreateSnapshot("snap"); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := pool.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := PoolStatus{
+		Name:       "zstore",
+		Health:     "ONLINE",
+		Allocated:  768 * MB,
+		Free:       256 * MB,
+		Size:       1 * GB,
+		DedupRatio: 1,
+		Volumes:    2,
+		Buckets: map[string]int{
+			"zstore/foo": 1,
+			"zstore/baz": 1,
+		},
+	}
+	if !reflect.DeepEqual(*s, want) {
+		t.Fatalf("unexpected status: %v != %v", *s, want)
+	}
+}
+
+// TestFilePoolListVolumes verifies that FilePool.ListVolumes only returns
+// volumes which are direct children of a bucket.
+func TestFilePoolListVolumes(t *testing.T) {
+	pool, done := testFilePool(t, 0)
+	defer done()
+
+	// Unknown buckets do not exist
+	if _, err := pool.ListVolumes("zstore/foo"); err != ErrVolumeNotExists {
+		t.Fatalf("unexpected error for unknown bucket: %v != %v", err, ErrVolumeNotExists)
+	}
+
+	for _, name := range []string{
+		"zstore/foo/bar",
+		"zstore/foo/baz",
+		"zstore/qux/bar",
+	} {
+		if _, err := pool.CreateVolume(name, 256*MB, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	volumes, err := pool.ListVolumes("zstore/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 2 {
+		t.Fatalf("unexpected number of volumes: %v != %v", len(volumes), 2)
+	}
+
+	for _, v := range volumes {
+		if v.Size() != 256*MB {
+			t.Fatalf("unexpected volume size: %v != %v", v.Size(), 256*MB)
+		}
+	}
+}
+
+// TestFilePoolConcurrentMetadata verifies that volumes can be read while their
+// metadata is rewritten, without observing partially written metadata.
+func TestFilePoolConcurrentMetadata(t *testing.T) {
+	pool, done := testFilePool(t, 0)
+	defer done()
+
+	volume, err := pool.CreateVolume("zstore/foo/bar", 256*MB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 200
+	errC := make(chan error, 1)
+	go func() {
+		for i := 0; i < n; i++ {
+			if err := volume.SetLabels(map[string]string{"n": strconv.Itoa(i)}); err != nil {
+				errC <- err
+				return
+			}
+		}
+
+		errC <- nil
+	}()
+
+	for i := 0; i < n; i++ {
+		if _, err := pool.Volume("zstore/foo/bar"); err != nil {
+			t.Fatalf("unexpected error reading volume: %v", err)
+		}
+		if _, err := pool.ListVolumes("zstore/foo"); err != nil {
+			t.Fatalf("unexpected error listing volumes: %v", err)
+		}
+	}
+
+	if err := <-errC; err != nil {
+		t.Fatal(err)
+	}
+
+	// No temporary files are left behind
+	fis, err := ioutil.ReadDir(filepath.Join(pool.root, "foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 2 {
+		t.Fatalf("unexpected number of files: %v != %v", len(fis), 2)
+	}
+}
+
+// TestFileVolumeDestroy verifies that FileVolume.Destroy removes a volume
+// and releases its space back to the pool.
+func TestFileVolumeDestroy(t *testing.T) {
+	pool, done := testFilePool(t, 1*GB)
+	defer done()
+
+	if _, err := pool.CreateVolume("zstore/foo/bar", 1*GB, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	volume, err := pool.Volume("zstore/foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := volume.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if err := volume.Destroy(); err != ErrVolumeNotExists {
+		t.Fatalf("unexpected error for destroyed volume: %v != %v", err, ErrVolumeNotExists)
+	}
+	if _, err := pool.Volume("zstore/foo/bar"); err != ErrVolumeNotExists {
+		t.Fatalf("unexpected error for destroyed volume: %v != %v", err, ErrVolumeNotExists)
+	}
+
+	// Buckets are not volumes
+	if _, err := pool.Volume("zstore/foo"); err != ErrVolumeNotExists {
+		t.Fatalf("unexpected error for bucket: %v != %v", err, ErrVolumeNotExists)
+	}
+
+	// Space is available again
+	if _, err := pool.CreateVolume("zstore/foo/bar", 1*GB, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestFileVolumeSnapshots verifies that FileVolume snapshots can be created,
+// listed in order, and destroyed, and that snapshots copy volume data.
+func TestFileVolumeSnapshots(t *testing.T) {
+	pool, done := testFilePool(t, 0)
+	defer done()
+
+	volume, err := pool.CreateVolume("zstore/foo/bar", 256*MB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write some data to the volume so the snapshot is not empty
+	file := filepath.Join(pool.root, "foo", "bar")
+	f, err := os.OpenFile(file, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("zstore"), 128*MB); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	testVolumeSnapshots(t, volume)
+
+	// Snapshot files must not be listed as volumes
+	volumes, err := pool.ListVolumes("zstore/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 1 {
+		t.Fatalf("unexpected number of volumes: %v != %v", len(volumes), 1)
+	}
+
+	// Snapshot contains a full copy of the volume's data
+	b, err := ioutil.ReadFile(file + "@two")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 256*MB {
+		t.Fatalf("unexpected snapshot size: %v != %v", len(b), 256*MB)
+	}
+	if s := string(b[128*MB : 128*MB+6]); s != "zstore" {
+		t.Fatalf("unexpected snapshot data: %q != %q", s, "zstore")
+	}
+
+	// Destroying the volume also destroys its snapshots
+	if err := volume.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file + "@two"); !os.IsNotExist(err) {
+		t.Fatalf("snapshot file should not exist: %v", err)
+	}
+}
+
+// TestFileVolumeRollback verifies that FileVolume rollback restores volume
+// data, and only destroys newer snapshots when requested.
+func TestFileVolumeRollback(t *testing.T) {
+	pool, done := testFilePool(t, 0)
+	defer done()
+
+	volume, err := pool.CreateVolume("zstore/foo/bar", 16*MB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testVolumeRollback(t, volume)
+
+	// Modify the volume, and verify rollback reverts the modification
+	file := filepath.Join(pool.root, "foo", "bar")
+	if err := ioutil.WriteFile(file, []byte("zstore"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := volume.Rollback("two", false); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := fi.Size(); size != 16*MB {
+		t.Fatalf("unexpected file size: %v != %v", size, 16*MB)
+	}
+}
+
+// TestFileVolumeRollbackSize verifies that FileVolume rollback restores the
+// size of a volume at the time of a snapshot, and enforces pool capacity when
+// that size is larger.
+func TestFileVolumeRollbackSize(t *testing.T) {
+	pool, done := testFilePool(t, 1*GB)
+	defer done()
+
+	volume, err := pool.CreateVolume("zstore/foo/bar", 512*MB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := volume.CreateSnapshot("large"); err != nil {
+		t.Fatal(err)
+	}
+	if err := volume.Resize(256 * MB); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := volume.CreateSnapshot("small"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The snapshots consume the remaining capacity of the pool
+	if err := volume.Rollback("large", true); err != ErrPoolOutOfSpace {
+		t.Fatalf("unexpected error for full pool: %v != %v", err, ErrPoolOutOfSpace)
+	}
+	if size := volume.Size(); size != 256*MB {
+		t.Fatalf("unexpected volume size: %v != %v", size, 256*MB)
+	}
+
+	if err := volume.DestroySnapshot("small"); err != nil {
+		t.Fatal(err)
+	}
+	if err := volume.Rollback("large", false); err != nil {
+		t.Fatal(err)
+	}
+	if size := volume.Size(); size != 512*MB {
+		t.Fatalf("unexpected volume size: %v != %v", size, 512*MB)
+	}
+
+	// The size is also reported when the volume is retrieved again
+	v, err := pool.Volume("zstore/foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := v.Size(); size != 512*MB {
+		t.Fatalf("unexpected volume size: %v != %v", size, 512*MB)
+	}
+
+	// No temporary files are left behind
+	fis, err := ioutil.ReadDir(filepath.Join(pool.root, "foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 3 {
+		t.Fatalf("unexpected number of files: %v != %v", len(fis), 3)
+	}
+}
+
+// TestFilePoolCloneVolume verifies that FilePool clones report their origin,
+// and prevent their origin from being destroyed.
+func TestFilePoolCloneVolume(t *testing.T) {
+	pool, done := testFilePool(t, 0)
+	defer done()
+
+	testPoolClone(t, pool)
+}
+
+// TestFileVolumeResize verifies that FileVolume.Resize changes the size of
+// its backing file, and enforces pool capacity when growing a volume.
+func TestFileVolumeResize(t *testing.T) {
+	pool, done := testFilePool(t, 1*GB)
+	defer done()
+
+	volume, err := pool.CreateVolume("zstore/foo/bar", 512*MB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := volume.Resize(2 * GB); err != ErrPoolOutOfSpace {
+		t.Fatalf("unexpected error for full pool: %v != %v", err, ErrPoolOutOfSpace)
+	}
+
+	for _, size := range []uint64{1 * GB, 256 * MB} {
+		if err := volume.Resize(size); err != nil {
+			t.Fatal(err)
+		}
+
+		fi, err := os.Stat(filepath.Join(pool.root, "foo", "bar"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uint64(fi.Size()) != size {
+			t.Fatalf("unexpected file size: %v != %v", fi.Size(), size)
+		}
+	}
+}
+
+// TestFileVolumeConcurrentResize verifies that the size of a FileVolume can be
+// read while it is resized.
+func TestFileVolumeConcurrentResize(t *testing.T) {
+	pool, done := testFilePool(t, 0)
+	defer done()
+
+	volume, err := pool.CreateVolume("zstore/foo/bar", 512*MB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- volume.Resize(1 * GB)
+	}()
+
+	if size := volume.Size(); size != 512*MB && size != 1*GB {
+		t.Fatalf("unexpected volume size: %v", size)
+	}
+
+	if err := <-errC; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestFileVolumeLabels verifies that FileVolume labels are stored in volume
+// metadata, and are preserved by other metadata changes.
+func TestFileVolumeLabels(t *testing.T) {
+	pool, done := testFilePool(t, 0)
+	defer done()
+
+	volume, err := pool.CreateVolume("zstore/foo/bar", 16*MB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testVolumeLabels(t, volume)
+
+	// Snapshots also update volume metadata
+	if _, err := volume.CreateSnapshot("one"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Labels persist when a volume is retrieved again
+	volume, err = pool.Volume("zstore/foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels, err := volume.Labels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 1 || labels["env"] != "dev" {
+		t.Fatalf("unexpected labels: %v", labels)
+	}
+}
+
+// TestFileVolumeMetadata verifies that FileVolume metadata reports space
+// allocated on disk by the volume and its snapshots.
+func TestFileVolumeMetadata(t *testing.T) {
+	pool, done := testFilePool(t, 0)
+	defer done()
+
+	volume, err := pool.CreateVolume("zstore/foo/bar", 16*MB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write a single block of data to the sparse file
+	file := filepath.Join(pool.root, "foo", "bar")
+	f, err := os.OpenFile(file, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(bytes.Repeat([]byte{1}, 64*1024), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := volume.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Device != file {
+		t.Fatalf("unexpected device: %v != %v", m.Device, file)
+	}
+	if m.Created.IsZero() || m.CompressRatio != 1 {
+		t.Fatalf("unexpected metadata: %+v", m)
+	}
+	if m.Used != m.Referenced {
+		t.Fatalf("unexpected used for volume without snapshots: %v != %v", m.Used, m.Referenced)
+	}
+
+	// Snapshots consume additional space
+	if _, err := volume.CreateSnapshot("one"); err != nil {
+		t.Fatal(err)
+	}
+
+	m2, err := volume.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m2.Referenced != m.Referenced || m2.Used <= m.Used {
+		t.Fatalf("unexpected metadata after snapshot: %+v", m2)
+	}
+	if !m2.Created.Equal(m.Created) {
+		t.Fatalf("unexpected creation time: %v != %v", m2.Created, m.Created)
+	}
+}
+
+// testFilePool creates a FilePool in a temporary directory, and returns a
+// function which cleans up the directory when called.
+func testFilePool(t *testing.T, capacity uint64) (*FilePool, func()) {
+	root, err := ioutil.TempDir(os.TempDir(), "zstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewFilePool("zstore", root, capacity), func() {
+		if err := os.RemoveAll(root); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
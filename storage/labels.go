@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	// ErrInvalidLabel is returned when a label key or value is not valid.
+	ErrInvalidLabel = errors.New("invalid label")
+
+	// ErrInvalidLabelSelector is returned when a label selector cannot
+	// be parsed.
+	ErrInvalidLabelSelector = errors.New("invalid label selector")
+)
+
+const (
+	// labelProperty is the prefix of the ZFS user properties which store
+	// volume labels
+	labelProperty = "zstore:label:"
+
+	// maxLabelValue is the maximum length of a label value
+	maxLabelValue = 256
+)
+
+// labelKeyRegexp matches valid label keys.  Keys are also used in ZFS user
+// property names, so they are limited to characters which ZFS permits.
+var labelKeyRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9._-]{0,61}[a-z0-9])?$`)
+
+// ValidateLabels returns ErrInvalidLabel if any label in the input map has an
+// invalid key or value.  Keys must be lowercase alphanumeric, and may contain
+// '.', '_', and '-' between alphanumeric characters.  Values must be non-empty
+// printable text of no more than 256 bytes.
+func ValidateLabels(labels map[string]string) error {
+	for k, v := range labels {
+		if !labelKeyRegexp.MatchString(k) {
+			return ErrInvalidLabel
+		}
+
+		if v == "" || len(v) > maxLabelValue || strings.IndexFunc(v, func(r rune) bool {
+			return !unicode.IsPrint(r)
+		}) != -1 {
+			return ErrInvalidLabel
+		}
+	}
+
+	return nil
+}
+
+// copyLabels returns a copy of a label map, which is never nil.
+func copyLabels(labels map[string]string) map[string]string {
+	out := make(map[string]string, len(labels))
+	for k, v := range labels {
+		out[k] = v
+	}
+
+	return out
+}
+
+// A LabelSelector selects volumes by their labels.  The zero value selects
+// all volumes.
+type LabelSelector []labelRequirement
+
+// labelRequirement is a single requirement of a LabelSelector.
+type labelRequirement struct {
+	key    string
+	value  string
+	op     labelOp
+	negate bool
+}
+
+// labelOp is a comparison made by a labelRequirement.
+type labelOp int
+
+// Possible labelOp values.
+const (
+	// labelExists requires that a label is present
+	labelExists labelOp = iota
+
+	// labelEquals requires that a label is present with a value
+	labelEquals
+)
+
+// ParseLabelSelector parses a comma-separated label selector.  Each
+// requirement may be one of:
+//   - key=value: label is present with value
+//   - key!=value: label is not present with value
+//   - key: label is present
+//   - !key: label is not present
+//
+// A volume is selected if it satisfies all requirements.  An empty selector
+// selects all volumes.
+func ParseLabelSelector(s string) (LabelSelector, error) {
+	var sel LabelSelector
+	if strings.TrimSpace(s) == "" {
+		return sel, nil
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+
+		var r labelRequirement
+		switch {
+		case strings.Contains(part, "!="):
+			kv := strings.SplitN(part, "!=", 2)
+			r = labelRequirement{key: kv[0], value: kv[1], op: labelEquals, negate: true}
+		case strings.Contains(part, "="):
+			kv := strings.SplitN(part, "=", 2)
+			r = labelRequirement{key: kv[0], value: kv[1], op: labelEquals}
+		case strings.HasPrefix(part, "!"):
+			r = labelRequirement{key: part[1:], op: labelExists, negate: true}
+		default:
+			r = labelRequirement{key: part, op: labelExists}
+		}
+
+		r.key = strings.TrimSpace(r.key)
+		r.value = strings.TrimSpace(r.value)
+		if !labelKeyRegexp.MatchString(r.key) {
+			return nil, ErrInvalidLabelSelector
+		}
+
+		sel = append(sel, r)
+	}
+
+	return sel, nil
+}
+
+// Matches determines if a set of labels satisfies all requirements of a
+// LabelSelector.
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		v, ok := labels[r.key]
+
+		var match bool
+		switch r.op {
+		case labelExists:
+			match = ok
+		case labelEquals:
+			match = ok && v == r.value
+		}
+
+		if match == r.negate {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestValidateLabels verifies that invalid label keys and values are rejected.
+func TestValidateLabels(t *testing.T) {
+	var tests = []struct {
+		key   string
+		value string
+		ok    bool
+	}{
+		{key: "env", value: "prod", ok: true},
+		{key: "team.name", value: "storage team", ok: true},
+		{key: "a", value: "b", ok: true},
+		{key: "", value: "prod"},
+		{key: "Env", value: "prod"},
+		{key: "-env", value: "prod"},
+		{key: "env-", value: "prod"},
+		{key: "env:prod", value: "prod"},
+		{key: strings.Repeat("a", 64), value: "prod"},
+		{key: "env", value: ""},
+		{key: "env", value: "prod\ndev"},
+		{key: "env", value: strings.Repeat("a", maxLabelValue+1)},
+	}
+
+	for _, test := range tests {
+		err := ValidateLabels(map[string]string{test.key: test.value})
+		if ok := err == nil; ok != test.ok {
+			t.Fatalf("unexpected result: %v != %v [key: %q, value: %q]", ok, test.ok, test.key, test.value)
+		}
+	}
+}
+
+// TestLabelSelector verifies that label selectors are parsed and matched
+// correctly.
+func TestLabelSelector(t *testing.T) {
+	labels := map[string]string{
+		"env":  "prod",
+		"tier": "gold",
+	}
+
+	var tests = []struct {
+		selector string
+		match    bool
+		err      error
+	}{
+		{selector: "", match: true},
+		{selector: "env=prod", match: true},
+		{selector: "env = prod, tier=gold", match: true},
+		{selector: "env=dev", match: false},
+		{selector: "env!=dev", match: true},
+		{selector: "env!=prod", match: false},
+		{selector: "tier", match: true},
+		{selector: "team", match: false},
+		{selector: "!team", match: true},
+		{selector: "!tier", match: false},
+		{selector: "env=prod,!tier", match: false},
+		{selector: "Env=prod", err: ErrInvalidLabelSelector},
+		{selector: "env=prod,", err: ErrInvalidLabelSelector},
+		{selector: "=prod", err: ErrInvalidLabelSelector},
+	}
+
+	for _, test := range tests {
+		sel, err := ParseLabelSelector(test.selector)
+		if err != test.err {
+			t.Fatalf("unexpected error: %v != %v [selector: %q]", err, test.err, test.selector)
+		}
+		if err != nil {
+			continue
+		}
+
+		if match := sel.Matches(labels); match != test.match {
+			t.Fatalf("unexpected match: %v != %v [selector: %q]", match, test.match, test.selector)
+		}
+	}
+}
+
+// testVolumeLabels verifies common label behavior for any Volume
+// implementation.
+func testVolumeLabels(t *testing.T, volume Volume) {
+	labels, err := volume.Labels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 0 {
+		t.Fatalf("unexpected labels for new volume: %v", labels)
+	}
+
+	if err := volume.SetLabels(map[string]string{"Env": "prod"}); err != ErrInvalidLabel {
+		t.Fatalf("unexpected error for invalid label: %v != %v", err, ErrInvalidLabel)
+	}
+
+	if err := volume.SetLabels(map[string]string{"env": "prod", "tier": "gold"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Labels are replaced, not merged
+	if err := volume.SetLabels(map[string]string{"env": "dev"}); err != nil {
+		t.Fatal(err)
+	}
+
+	labels, err = volume.Labels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 1 || labels["env"] != "dev" {
+		t.Fatalf("unexpected labels: %v", labels)
+	}
+
+	// Modifying returned labels does not modify the volume
+	labels["env"] = "prod"
+	if labels, _ := volume.Labels(); labels["env"] != "dev" {
+		t.Fatalf("unexpected labels after modifying copy: %v", labels)
+	}
+}
@@ -0,0 +1,530 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/zstore/storage/zfsutil"
+
+	"gopkg.in/mistifyio/go-zfs.v2"
+)
+
+// MemPool is an in-memory implementation of Pool.  It mimics the semantics of
+// a ZFS zpool, so that code which depends on a Pool can be developed and
+// tested on systems which do not have ZFS available.
+type MemPool struct {
+	name     string
+	capacity uint64
+
+	mu      sync.RWMutex
+	buckets map[string]struct{}
+	volumes map[string]*MemVolume
+}
+
+// NewMemPool creates a new MemPool with the specified name and capacity in
+// bytes.  If capacity is 0, the pool will never run out of space.
+func NewMemPool(name string, capacity uint64) *MemPool {
+	return &MemPool{
+		name:     name,
+		capacity: capacity,
+
+		buckets: make(map[string]struct{}),
+		volumes: make(map[string]*MemVolume),
+	}
+}
+
+// Name returns the name of a MemPool.
+func (p *MemPool) Name() string {
+	return p.name
+}
+
+// Free returns the number of bytes of a MemPool's capacity which are not
+// allocated to volumes.  If the pool has no capacity, math.MaxUint64 is
+// returned.
+func (p *MemPool) Free() (uint64, error) {
+	if p.capacity == 0 {
+		return math.MaxUint64, nil
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if n := p.allocated(); n < p.capacity {
+		return p.capacity - n, nil
+	}
+
+	return 0, nil
+}
+
+// Status returns the current capacity of a MemPool, and the number of volumes
+// within it and each of its buckets.  A MemPool is always online, and does not
+// support fragmentation tracking or deduplication.
+func (p *MemPool) Status() (*PoolStatus, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	s := &PoolStatus{
+		Name:       p.name,
+		Health:     zfs.ZpoolOnline,
+		Allocated:  p.allocated(),
+		Size:       p.capacity,
+		DedupRatio: 1,
+		Volumes:    len(p.volumes),
+		Buckets:    make(map[string]int),
+	}
+	for name := range p.volumes {
+		s.Buckets[path.Dir(name)]++
+	}
+	if s.Allocated < p.capacity {
+		s.Free = p.capacity - s.Allocated
+	}
+
+	return s, nil
+}
+
+// Stats returns the I/O statistics of a MemPool.  In-memory volumes hold no
+// data and perform no I/O, so no statistics are reported.
+func (p *MemPool) Stats() (*PoolStats, error) {
+	return &PoolStats{}, nil
+}
+
+// CreateVolume creates a new MemVolume from a MemPool with the specified name,
+// size in bytes, and optional creation options.  Any parent buckets are created
+// as needed, in the same way as 'zfs create -p'.  Like a sparse zvol, a sparse
+// MemVolume consumes no capacity.
+func (p *MemPool) CreateVolume(name string, size uint64, options *VolumeOptions) (Volume, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Check if pool has the capacity to store this volume
+	if !options.sparse() && p.capacity > 0 && p.allocated()+size > p.capacity {
+		return nil, ErrPoolOutOfSpace
+	}
+
+	v, err := p.create(name, size, "")
+	if err != nil {
+		return nil, err
+	}
+	v.sparse = options.sparse()
+	v.class = options.className()
+	v.blockSize = options.VolumeBlockSize()
+
+	return v, nil
+}
+
+// CloneVolume creates a new MemVolume from a MemPool with the specified name,
+// which is a clone of the named snapshot of the source volume.  Clones share
+// the space of their origin, so they consume no additional capacity.
+func (p *MemPool) CloneVolume(name string, source string, snapshot string) (Volume, error) {
+	if !validSnapshotName(snapshot) {
+		return nil, ErrInvalidSnapshotName
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Ensure source volume and snapshot exist
+	v, ok := p.volumes[source]
+	if !ok {
+		return nil, ErrVolumeNotExists
+	}
+	if v.snapshotIndex(snapshot) == -1 {
+		return nil, ErrSnapshotNotExists
+	}
+
+	// Like a ZFS clone, the clone takes the size of the volume at the time
+	// of the snapshot
+	return p.create(name, v.snapshotSizes[snapshot], source+"@"+snapshot)
+}
+
+// create creates a new MemVolume with the specified name, size, and origin
+// snapshot, if it is a clone.  The caller must hold p.mu.
+func (p *MemPool) create(name string, size uint64, origin string) (*MemVolume, error) {
+	// Ensure volume is created within this pool
+	if !strings.HasPrefix(name, p.name+"/") {
+		return nil, fmt.Errorf("volume %q is not in pool %q", name, p.name)
+	}
+
+	// Volume names and bucket names share the same namespace
+	if _, ok := p.volumes[name]; ok {
+		return nil, ErrVolumeExists
+	}
+	if _, ok := p.buckets[name]; ok {
+		return nil, ErrVolumeExists
+	}
+
+	// Volumes cannot contain other datasets, so ensure no parent is a volume
+	for dir := path.Dir(name); dir != p.name; dir = path.Dir(dir) {
+		if _, ok := p.volumes[dir]; ok {
+			return nil, fmt.Errorf("parent %q of volume %q is not a bucket", dir, name)
+		}
+	}
+
+	// Create all parent buckets below the pool itself
+	for dir := path.Dir(name); dir != p.name; dir = path.Dir(dir) {
+		p.buckets[dir] = struct{}{}
+	}
+
+	v := &MemVolume{
+		pool:    p,
+		name:    name,
+		size:    size,
+		origin:  origin,
+		created: time.Now(),
+
+		snapshotSizes: make(map[string]uint64),
+	}
+	p.volumes[name] = v
+
+	return v, nil
+}
+
+// ListVolumes returns a list of all volumes which belong in the specified bucket,
+// typically by user.
+func (p *MemPool) ListVolumes(bucket string) ([]Volume, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	// If bucket does not exist, return not exists
+	if _, ok := p.buckets[bucket]; !ok {
+		return nil, ErrVolumeNotExists
+	}
+
+	// Gather only direct children of the bucket which are volumes
+	var volumes []Volume
+	for name, v := range p.volumes {
+		if path.Dir(name) != bucket {
+			continue
+		}
+
+		volumes = append(volumes, v)
+	}
+
+	return volumes, nil
+}
+
+// Volume attempts to retrieve a MemVolume from a MemPool by its name.
+func (p *MemPool) Volume(name string) (Volume, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	v, ok := p.volumes[name]
+	if !ok {
+		return nil, ErrVolumeNotExists
+	}
+
+	return v, nil
+}
+
+// allocated returns the total number of bytes allocated to volumes in the
+// pool.  Clones are not counted, since they share space with their origin,
+// and sparse volumes are not counted, since no space is reserved for them.
+// The caller must hold p.mu.
+func (p *MemPool) allocated() uint64 {
+	var n uint64
+	for _, v := range p.volumes {
+		if v.origin == "" && !v.sparse {
+			n += v.size
+		}
+	}
+
+	return n
+}
+
+// hasClones determines if any volume in the pool is a clone of the named
+// snapshot.  The caller must hold p.mu.
+func (p *MemPool) hasClones(origin string) bool {
+	for _, v := range p.volumes {
+		if v.origin == origin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MemVolume is an in-memory implementation of Volume, which is allocated
+// from a MemPool.
+type MemVolume struct {
+	pool      *MemPool
+	name      string
+	size      uint64
+	origin    string
+	sparse    bool
+	class     string
+	blockSize uint64
+	created   time.Time
+
+	// labels and snapshots are guarded by the mutex of pool; snapshots are
+	// ordered from oldest to newest, and snapshotSizes records the size of
+	// the volume at the time of each snapshot
+	labels        map[string]string
+	snapshots     []*Snapshot
+	snapshotSizes map[string]uint64
+}
+
+// Destroy completely destroys this volume, including all of its snapshots,
+// releasing its space back to its MemPool.
+func (v *MemVolume) Destroy() error {
+	v.pool.mu.Lock()
+	defer v.pool.mu.Unlock()
+
+	// Ensure volume was not already destroyed
+	if _, ok := v.pool.volumes[v.name]; !ok {
+		return ErrVolumeNotExists
+	}
+
+	// Snapshots with clones cannot be destroyed
+	for _, s := range v.snapshots {
+		if v.pool.hasClones(v.name + "@" + s.Name) {
+			return ErrDependentClones
+		}
+	}
+
+	delete(v.pool.volumes, v.name)
+	return nil
+}
+
+// Resize sets the size of this volume to the specified size in bytes.
+func (v *MemVolume) Resize(size uint64) error {
+	v.pool.mu.Lock()
+	defer v.pool.mu.Unlock()
+
+	// Ensure volume was not already destroyed
+	if _, ok := v.pool.volumes[v.name]; !ok {
+		return ErrVolumeNotExists
+	}
+
+	// Check if pool has the capacity to grow this volume
+	if p := v.pool; size > v.size && p.capacity > 0 && p.allocated()+(size-v.size) > p.capacity {
+		return ErrPoolOutOfSpace
+	}
+
+	v.size = size
+	return nil
+}
+
+// Labels returns the labels of this volume.
+func (v *MemVolume) Labels() (map[string]string, error) {
+	v.pool.mu.RLock()
+	defer v.pool.mu.RUnlock()
+
+	// Ensure volume was not already destroyed
+	if _, ok := v.pool.volumes[v.name]; !ok {
+		return nil, ErrVolumeNotExists
+	}
+
+	return copyLabels(v.labels), nil
+}
+
+// SetLabels replaces the labels of this volume.
+func (v *MemVolume) SetLabels(labels map[string]string) error {
+	if err := ValidateLabels(labels); err != nil {
+		return err
+	}
+
+	v.pool.mu.Lock()
+	defer v.pool.mu.Unlock()
+
+	// Ensure volume was not already destroyed
+	if _, ok := v.pool.volumes[v.name]; !ok {
+		return ErrVolumeNotExists
+	}
+
+	v.labels = copyLabels(labels)
+	return nil
+}
+
+// Name returns the name of a MemVolume.
+func (v *MemVolume) Name() string {
+	return v.name
+}
+
+// Size returns the size of a MemVolume.
+func (v *MemVolume) Size() uint64 {
+	v.pool.mu.RLock()
+	defer v.pool.mu.RUnlock()
+
+	return v.size
+}
+
+// Origin returns the name of the snapshot from which a MemVolume was cloned,
+// or an empty string if it is not a clone.
+func (v *MemVolume) Origin() string {
+	return v.origin
+}
+
+// Metadata returns metadata about this volume.  In-memory volumes hold no
+// data, so they are reported as fully allocated with no compression unless
+// they are sparse, and have no device.
+func (v *MemVolume) Metadata() (*VolumeMetadata, error) {
+	v.pool.mu.RLock()
+	defer v.pool.mu.RUnlock()
+
+	// Ensure volume was not already destroyed
+	if _, ok := v.pool.volumes[v.name]; !ok {
+		return nil, ErrVolumeNotExists
+	}
+
+	// Clones share space with their origin, and sparse volumes hold no data
+	var used uint64
+	if v.origin == "" && !v.sparse {
+		used = v.size
+	}
+
+	return &VolumeMetadata{
+		Used:          used,
+		Referenced:    v.size,
+		CompressRatio: 1,
+		Created:       v.created,
+		Class:         v.class,
+		BlockSize:     v.blockSize,
+	}, nil
+}
+
+// Stats returns the I/O statistics of this volume.  In-memory volumes perform
+// no I/O, so nil is returned.
+func (v *MemVolume) Stats() (*zfsutil.IOStats, error) {
+	return nil, nil
+}
+
+// CreateSnapshot creates a new snapshot of this volume with the specified name.
+func (v *MemVolume) CreateSnapshot(name string) (*Snapshot, error) {
+	if !validSnapshotName(name) {
+		return nil, ErrInvalidSnapshotName
+	}
+
+	v.pool.mu.Lock()
+	defer v.pool.mu.Unlock()
+
+	// Ensure volume was not already destroyed
+	if _, ok := v.pool.volumes[v.name]; !ok {
+		return nil, ErrVolumeNotExists
+	}
+
+	if v.snapshotIndex(name) != -1 {
+		return nil, ErrSnapshotExists
+	}
+
+	// In-memory volumes hold no data, so snapshots never consume space
+	s := &Snapshot{
+		Name:    name,
+		Created: time.Now(),
+	}
+	v.snapshots = append(v.snapshots, s)
+	v.snapshotSizes[name] = v.size
+
+	return s, nil
+}
+
+// Snapshots returns a list of all snapshots of this volume, ordered from
+// oldest to newest.
+func (v *MemVolume) Snapshots() ([]*Snapshot, error) {
+	v.pool.mu.RLock()
+	defer v.pool.mu.RUnlock()
+
+	// Ensure volume was not already destroyed
+	if _, ok := v.pool.volumes[v.name]; !ok {
+		return nil, ErrVolumeNotExists
+	}
+
+	snapshots := make([]*Snapshot, len(v.snapshots))
+	copy(snapshots, v.snapshots)
+	return snapshots, nil
+}
+
+// DestroySnapshot destroys a single snapshot of this volume by name.
+func (v *MemVolume) DestroySnapshot(name string) error {
+	if !validSnapshotName(name) {
+		return ErrInvalidSnapshotName
+	}
+
+	v.pool.mu.Lock()
+	defer v.pool.mu.Unlock()
+
+	i := v.snapshotIndex(name)
+	if i == -1 {
+		return ErrSnapshotNotExists
+	}
+
+	// Snapshots with clones cannot be destroyed
+	if v.pool.hasClones(v.name + "@" + name) {
+		return ErrDependentClones
+	}
+
+	v.snapshots = append(v.snapshots[:i], v.snapshots[i+1:]...)
+	delete(v.snapshotSizes, name)
+	return nil
+}
+
+// Rollback reverts this volume to the named snapshot, which restores the
+// volume's size at the time of the snapshot.  ErrPoolOutOfSpace is returned
+// if the pool lacks the capacity to restore a larger size.  If destroyNewer
+// is true, any snapshots newer than the named snapshot are destroyed;
+// otherwise, ErrNewerSnapshotsExist is returned if any exist.
+func (v *MemVolume) Rollback(name string, destroyNewer bool) error {
+	if !validSnapshotName(name) {
+		return ErrInvalidSnapshotName
+	}
+
+	v.pool.mu.Lock()
+	defer v.pool.mu.Unlock()
+
+	i := v.snapshotIndex(name)
+	if i == -1 {
+		return ErrSnapshotNotExists
+	}
+
+	// Snapshots are ordered, so any following this one are newer
+	newer := i < len(v.snapshots)-1
+	if newer {
+		if !destroyNewer {
+			return ErrNewerSnapshotsExist
+		}
+
+		// Newer snapshots with clones cannot be destroyed
+		for _, s := range v.snapshots[i+1:] {
+			if v.pool.hasClones(v.name + "@" + s.Name) {
+				return ErrDependentClones
+			}
+		}
+	}
+
+	// Check if pool has the capacity to restore the size of the volume at
+	// the time of the snapshot
+	size := v.snapshotSizes[name]
+	if p := v.pool; size > v.size && p.capacity > 0 && p.allocated()+(size-v.size) > p.capacity {
+		return ErrPoolOutOfSpace
+	}
+
+	if newer {
+		for _, s := range v.snapshots[i+1:] {
+			delete(v.snapshotSizes, s.Name)
+		}
+		v.snapshots = v.snapshots[:i+1]
+	}
+
+	// In-memory volumes hold no data, so only the size must be reverted
+	v.size = size
+	return nil
+}
+
+// snapshotIndex returns the index of the named snapshot, or -1 if no
+// snapshot exists with that name.  The caller must hold the mutex of pool.
+func (v *MemVolume) snapshotIndex(name string) int {
+	for i, s := range v.snapshots {
+		if s.Name == name {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,406 @@
+package storage
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// TestMemPoolCreateVolume verifies that MemPool.CreateVolume creates volumes
+// and enforces pool naming, duplicate names, and capacity.
+func TestMemPoolCreateVolume(t *testing.T) {
+	pool := NewMemPool("zstore", 1*GB)
+
+	// Create a volume within a bucket
+	volume, err := pool.CreateVolume("zstore/foo/bar", 512*MB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := volume.Name(); name != "zstore/foo/bar" {
+		t.Fatalf("unexpected volume name: %v != %v", name, "zstore/foo/bar")
+	}
+	if size := volume.Size(); size != 512*MB {
+		t.Fatalf("unexpected volume size: %v != %v", size, 512*MB)
+	}
+
+	// Duplicate volumes and buckets cannot be created
+	if _, err := pool.CreateVolume("zstore/foo/bar", 256*MB, nil); err != ErrVolumeExists {
+		t.Fatalf("unexpected error for duplicate volume: %v != %v", err, ErrVolumeExists)
+	}
+	if _, err := pool.CreateVolume("zstore/foo", 256*MB, nil); err != ErrVolumeExists {
+		t.Fatalf("unexpected error for duplicate bucket: %v != %v", err, ErrVolumeExists)
+	}
+
+	// Volumes cannot be created outside the pool, or inside another volume
+	if _, err := pool.CreateVolume("other/foo/bar", 256*MB, nil); err == nil {
+		t.Fatal("expected error for volume outside pool")
+	}
+	if _, err := pool.CreateVolume("zstore/foo/bar/baz", 256*MB, nil); err == nil {
+		t.Fatal("expected error for volume inside volume")
+	}
+
+	// Pool capacity is enforced
+	if _, err := pool.CreateVolume("zstore/foo/baz", 1*GB, nil); err != ErrPoolOutOfSpace {
+		t.Fatalf("unexpected error for full pool: %v != %v", err, ErrPoolOutOfSpace)
+	}
+	if _, err := pool.CreateVolume("zstore/foo/baz", 512*MB, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestMemPoolFree verifies that MemPool.Free reports the capacity which is not
+// allocated to volumes.
+func TestMemPoolFree(t *testing.T) {
+	pool := NewMemPool("zstore", 1*GB)
+
+	var tests = []struct {
+		description string
+		name        string
+		size        uint64
+		options     *VolumeOptions
+		free        uint64
+	}{
+		{
+			description: "empty pool",
+			free:        1 * GB,
+		},
+		{
+			description: "allocated volume",
+			name:        "zstore/foo/bar",
+			size:        256 * MB,
+			free:        768 * MB,
+		},
+		{
+			description: "sparse volume",
+			name:        "zstore/foo/baz",
+			size:        512 * MB,
+			options:     &VolumeOptions{Sparse: true},
+			free:        768 * MB,
+		},
+	}
+
+	for _, test := range tests {
+		if test.name != "" {
+			if _, err := pool.CreateVolume(test.name, test.size, test.options); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		free, err := pool.Free()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if free != test.free {
+			t.Fatalf("unexpected free space: %v != %v [description: %s]", free, test.free, test.description)
+		}
+	}
+
+	// Pools without a capacity have unlimited space
+	if free, _ := NewMemPool("zstore", 0).Free(); free != math.MaxUint64 {
+		t.Fatalf("unexpected free space: %v != %v", free, uint64(math.MaxUint64))
+	}
+}
+
+// TestMemPoolStatus verifies that MemPool.Status reports the capacity and volume
+// count of the pool.
+func TestMemPoolStatus(t *testing.T) {
+	pool := NewMemPool("zstore", 1*GB)
+
+	volume, err := pool.CreateVolume("zstore/foo/bar", 256*MB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.CreateVolume("zstore/baz/qux", 256*MB, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Snapshots are not volumes
+	if _, err := volume.CreateSnapshot("snap"); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := pool.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := PoolStatus{
+		Name:       "zstore",
+		Health:     "ONLINE",
+		Allocated:  512 * MB,
+		Free:       512 * MB,
+		Size:       1 * GB,
+		DedupRatio: 1,
+		Volumes:    2,
+		Buckets: map[string]int{
+			"zstore/foo": 1,
+			"zstore/baz": 1,
+		},
+	}
+	if !reflect.DeepEqual(*s, want) {
+		t.Fatalf("unexpected status: %v != %v", *s, want)
+	}
+}
+
+// TestMemPoolListVolumes verifies that MemPool.ListVolumes only returns
+// volumes which are direct children of a bucket.
+func TestMemPoolListVolumes(t *testing.T) {
+	pool := NewMemPool("zstore", 0)
+
+	// Unknown buckets do not exist
+	if _, err := pool.ListVolumes("zstore/foo"); err != ErrVolumeNotExists {
+		t.Fatalf("unexpected error for unknown bucket: %v != %v", err, ErrVolumeNotExists)
+	}
+
+	for _, name := range []string{
+		"zstore/foo/bar",
+		"zstore/foo/baz",
+		"zstore/qux/bar",
+	} {
+		if _, err := pool.CreateVolume(name, 256*MB, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	volumes, err := pool.ListVolumes("zstore/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 2 {
+		t.Fatalf("unexpected number of volumes: %v != %v", len(volumes), 2)
+	}
+
+	// Buckets remain after all of their volumes are destroyed
+	for _, v := range volumes {
+		if err := v.Destroy(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	volumes, err = pool.ListVolumes("zstore/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 0 {
+		t.Fatalf("unexpected number of volumes: %v != %v", len(volumes), 0)
+	}
+}
+
+// TestMemVolumeDestroy verifies that MemVolume.Destroy removes a volume
+// and releases its space back to the pool.
+func TestMemVolumeDestroy(t *testing.T) {
+	pool := NewMemPool("zstore", 1*GB)
+
+	volume, err := pool.CreateVolume("zstore/foo/bar", 1*GB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := volume.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if err := volume.Destroy(); err != ErrVolumeNotExists {
+		t.Fatalf("unexpected error for destroyed volume: %v != %v", err, ErrVolumeNotExists)
+	}
+	if _, err := pool.Volume("zstore/foo/bar"); err != ErrVolumeNotExists {
+		t.Fatalf("unexpected error for destroyed volume: %v != %v", err, ErrVolumeNotExists)
+	}
+
+	// Space is available again
+	if _, err := pool.CreateVolume("zstore/foo/bar", 1*GB, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestMemVolumeSnapshots verifies that MemVolume snapshots can be created,
+// listed in order, and destroyed.
+func TestMemVolumeSnapshots(t *testing.T) {
+	pool := NewMemPool("zstore", 0)
+
+	volume, err := pool.CreateVolume("zstore/foo/bar", 256*MB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testVolumeSnapshots(t, volume)
+}
+
+// TestMemVolumeRollback verifies that MemVolume rollback only destroys newer
+// snapshots when requested.
+func TestMemVolumeRollback(t *testing.T) {
+	pool := NewMemPool("zstore", 0)
+
+	volume, err := pool.CreateVolume("zstore/foo/bar", 256*MB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testVolumeRollback(t, volume)
+}
+
+// TestMemVolumeRollbackSize verifies that MemVolume rollback restores the
+// size of a volume at the time of a snapshot, and enforces pool capacity when
+// that size is larger.
+func TestMemVolumeRollbackSize(t *testing.T) {
+	pool := NewMemPool("zstore", 1*GB)
+
+	volume, err := pool.CreateVolume("zstore/foo/bar", 512*MB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := volume.CreateSnapshot("large"); err != nil {
+		t.Fatal(err)
+	}
+	if err := volume.Resize(256 * MB); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := volume.CreateSnapshot("small"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another volume consumes the remaining capacity of the pool
+	other, err := pool.CreateVolume("zstore/foo/baz", 768*MB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := volume.Rollback("large", true); err != ErrPoolOutOfSpace {
+		t.Fatalf("unexpected error for full pool: %v != %v", err, ErrPoolOutOfSpace)
+	}
+	if size := volume.Size(); size != 256*MB {
+		t.Fatalf("unexpected volume size: %v != %v", size, 256*MB)
+	}
+
+	// Newer snapshots are kept when rollback fails
+	snapshots, err := volume.Snapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("unexpected number of snapshots: %v != %v", len(snapshots), 2)
+	}
+
+	if err := other.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if err := volume.Rollback("large", true); err != nil {
+		t.Fatal(err)
+	}
+	if size := volume.Size(); size != 512*MB {
+		t.Fatalf("unexpected volume size: %v != %v", size, 512*MB)
+	}
+
+	// The size is also reported when the volume is retrieved again
+	v, err := pool.Volume("zstore/foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := v.Size(); size != 512*MB {
+		t.Fatalf("unexpected volume size: %v != %v", size, 512*MB)
+	}
+}
+
+// TestMemPoolCloneVolume verifies that MemPool clones report their origin,
+// and prevent their origin from being destroyed.
+func TestMemPoolCloneVolume(t *testing.T) {
+	testPoolClone(t, NewMemPool("zstore", 0))
+}
+
+// TestMemVolumeResize verifies that MemVolume.Resize enforces pool capacity
+// when growing a volume.
+func TestMemVolumeResize(t *testing.T) {
+	pool := NewMemPool("zstore", 1*GB)
+
+	volume, err := pool.CreateVolume("zstore/foo/bar", 512*MB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := volume.Resize(2 * GB); err != ErrPoolOutOfSpace {
+		t.Fatalf("unexpected error for full pool: %v != %v", err, ErrPoolOutOfSpace)
+	}
+	if err := volume.Resize(1 * GB); err != nil {
+		t.Fatal(err)
+	}
+	if size := volume.Size(); size != 1*GB {
+		t.Fatalf("unexpected volume size: %v != %v", size, 1*GB)
+	}
+}
+
+// TestMemVolumeConcurrentResize verifies that the size of a MemVolume can be
+// read while it is resized.
+func TestMemVolumeConcurrentResize(t *testing.T) {
+	pool := NewMemPool("zstore", 0)
+
+	volume, err := pool.CreateVolume("zstore/foo/bar", 512*MB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- volume.Resize(1 * GB)
+	}()
+
+	if size := volume.Size(); size != 512*MB && size != 1*GB {
+		t.Fatalf("unexpected volume size: %v", size)
+	}
+
+	if err := <-errC; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestMemVolumeLabels verifies that MemVolume labels can be set and replaced.
+func TestMemVolumeLabels(t *testing.T) {
+	pool := NewMemPool("zstore", 0)
+
+	volume, err := pool.CreateVolume("zstore/foo/bar", 256*MB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testVolumeLabels(t, volume)
+
+	if err := volume.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := volume.Labels(); err != ErrVolumeNotExists {
+		t.Fatalf("unexpected error for destroyed volume: %v != %v", err, ErrVolumeNotExists)
+	}
+}
+
+// TestMemVolumeMetadata verifies that MemVolume metadata reports volumes as
+// fully allocated, except for clones.
+func TestMemVolumeMetadata(t *testing.T) {
+	pool := NewMemPool("zstore", 0)
+
+	volume, err := pool.CreateVolume("zstore/foo/bar", 256*MB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := volume.CreateSnapshot("one"); err != nil {
+		t.Fatal(err)
+	}
+
+	clone, err := pool.CloneVolume("zstore/foo/baz", "zstore/foo/bar", "one")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		volume Volume
+		used   uint64
+	}{
+		{volume: volume, used: 256 * MB},
+		{volume: clone, used: 0},
+	} {
+		m, err := test.volume.Metadata()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Used != test.used || m.Referenced != 256*MB || m.Created.IsZero() {
+			t.Fatalf("unexpected metadata for %s: %+v", test.volume.Name(), m)
+		}
+	}
+}
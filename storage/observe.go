@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"time"
+)
+
+// A ZFSObserver observes each ZFS command made by a Zpool or Zvol, such as to
+// collect metrics.  op names the operation, such as "create_volume" or
+// "snapshot", d is the time it took, and err is the error it returned, if any.
+// Errors may be classified using the functions in package zfsutil.
+type ZFSObserver func(op string, d time.Duration, err error)
+
+// zfsObserver is the ZFSObserver set by SetZFSObserver.
+var zfsObserver ZFSObserver
+
+// SetZFSObserver sets a ZFSObserver which observes all ZFS commands made by
+// all Zpools and Zvols.  It must be called before any Zpool is used, and may
+// not be called concurrently with any ZFS commands.
+func SetZFSObserver(o ZFSObserver) {
+	zfsObserver = o
+}
+
+// observeZFS reports a ZFS command which began at start to the ZFSObserver,
+// if one is set.
+func observeZFS(op string, start time.Time, err error) {
+	if zfsObserver == nil {
+		return
+	}
+
+	zfsObserver(op, time.Since(start), err)
+}
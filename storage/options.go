@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+)
+
+var (
+	// ErrInvalidVolumeOption is returned when a volume creation option is
+	// unknown, or has an invalid value.
+	ErrInvalidVolumeOption = errors.New("invalid volume option")
+)
+
+// Names of the volume creation options accepted by ParseVolumeOptions.
+const (
+	OptionCompression = "compression"
+	OptionBlockSize   = "volblocksize"
+	OptionSparse      = "sparse"
+)
+
+const (
+	// minBlockSize and maxBlockSize are the bounds of a valid zvol
+	// volblocksize
+	minBlockSize = 512
+	maxBlockSize = 128 * 1024
+)
+
+// compressionAlgorithms is the set of ZFS compression algorithms which may be
+// selected for a volume.
+var compressionAlgorithms = map[string]struct{}{
+	"off":    {},
+	"on":     {},
+	"lz4":    {},
+	"lzjb":   {},
+	"zle":    {},
+	"gzip":   {},
+	"gzip-1": {},
+	"gzip-2": {},
+	"gzip-3": {},
+	"gzip-4": {},
+	"gzip-5": {},
+	"gzip-6": {},
+	"gzip-7": {},
+	"gzip-8": {},
+	"gzip-9": {},
+}
+
+// VolumeOptions are options which may be set when a volume is created.  Only
+// this fixed set of ZFS properties may be chosen by a caller; the zero value
+// uses the defaults of the pool.
+//
+// Compression is a ZFS compression algorithm, and BlockSize is the volume's
+// block size in bytes, which must be a power of two between 512 bytes and
+// 128KB.  If Sparse is true, no space is reserved for the volume, so writes to
+// it may fail if the pool runs out of space.
+//
+// Class is an optional storage class, whose properties are also set on the
+// volume.  Options may not set a property which is also set by the class.
+type VolumeOptions struct {
+	Compression string
+	BlockSize   uint64
+	Sparse      bool
+
+	Class *Class
+}
+
+// VolumeOptionNames returns the sorted names of all volume creation options
+// accepted by ParseVolumeOptions.
+func VolumeOptionNames() []string {
+	names := []string{
+		OptionCompression,
+		OptionBlockSize,
+		OptionSparse,
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// ParseVolumeOptions parses VolumeOptions from a map of option names to
+// values, such as those in an API request.  ErrInvalidVolumeOption is returned
+// if any option is unknown or invalid.  If options is empty, nil is returned.
+func ParseVolumeOptions(options map[string]string) (*VolumeOptions, error) {
+	if len(options) == 0 {
+		return nil, nil
+	}
+
+	o := new(VolumeOptions)
+	for k, v := range options {
+		switch k {
+		case OptionCompression:
+			o.Compression = v
+		case OptionBlockSize:
+			size, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return nil, ErrInvalidVolumeOption
+			}
+
+			o.BlockSize = size
+		case OptionSparse:
+			sparse, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, ErrInvalidVolumeOption
+			}
+
+			o.Sparse = sparse
+		default:
+			return nil, ErrInvalidVolumeOption
+		}
+	}
+
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// Validate returns ErrInvalidVolumeOption if any of the options are invalid.
+// A nil VolumeOptions is valid.
+func (o *VolumeOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+
+	if o.Compression != "" {
+		if _, ok := compressionAlgorithms[o.Compression]; !ok {
+			return ErrInvalidVolumeOption
+		}
+	}
+
+	// Block size must be a power of two within bounds
+	if bs := o.BlockSize; bs != 0 {
+		if bs < minBlockSize || bs > maxBlockSize || bs&(bs-1) != 0 {
+			return ErrInvalidVolumeOption
+		}
+	}
+
+	if o.Class == nil {
+		return nil
+	}
+
+	if err := o.Class.Validate(); err != nil {
+		return err
+	}
+
+	// Options may not override the properties of a storage class
+	for k := range o.options() {
+		if _, ok := o.Class.Properties[k]; ok {
+			return ErrInvalidVolumeOption
+		}
+	}
+
+	return nil
+}
+
+// properties returns the ZFS properties which apply the options when a zvol
+// is created, including the properties of its storage class, and the name of
+// the class itself.
+func (o *VolumeOptions) properties() map[string]string {
+	if o == nil {
+		return nil
+	}
+
+	props := o.options()
+	if c := o.Class; c != nil {
+		for k, v := range c.Properties {
+			props[k] = v
+		}
+
+		props[classProperty] = c.Name
+	}
+
+	if len(props) == 0 {
+		return nil
+	}
+
+	return props
+}
+
+// options returns the ZFS properties which apply the options, excluding any
+// storage class.  A sparse zvol is one with no refreservation, which is what
+// 'zfs create -s' produces.
+func (o *VolumeOptions) options() map[string]string {
+	props := make(map[string]string)
+	if o.Compression != "" {
+		props["compression"] = o.Compression
+	}
+	if o.BlockSize != 0 {
+		props["volblocksize"] = strconv.FormatUint(o.BlockSize, 10)
+	}
+	if o.Sparse {
+		props["refreservation"] = "none"
+	}
+
+	return props
+}
+
+// sparse determines if the options create a sparse volume, either directly
+// or through a storage class.
+func (o *VolumeOptions) sparse() bool {
+	return o != nil && (o.Sparse || o.Class.sparse())
+}
+
+// VolumeBlockSize returns the block size in bytes of a volume created with
+// the options, which is set either directly or through a storage class.  If
+// the options do not set a block size, the pool's default is used, and 0 is
+// returned.
+func (o *VolumeOptions) VolumeBlockSize() uint64 {
+	if o == nil {
+		return 0
+	}
+	if o.BlockSize != 0 {
+		return o.BlockSize
+	}
+	if o.Class == nil {
+		return 0
+	}
+
+	// Class properties are validated, but may not be a number
+	bs, err := strconv.ParseUint(o.Class.Properties["volblocksize"], 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return bs
+}
+
+// className returns the name of the storage class selected by the options,
+// if any.
+func (o *VolumeOptions) className() string {
+	if o == nil || o.Class == nil {
+		return ""
+	}
+
+	return o.Class.Name
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestParseVolumeOptions verifies that only permitted volume options with
+// valid values are accepted.
+func TestParseVolumeOptions(t *testing.T) {
+	var tests = []struct {
+		description string
+		options     map[string]string
+		out         *VolumeOptions
+		err         error
+	}{
+		{
+			description: "no options",
+		},
+		{
+			description: "all options",
+			options: map[string]string{
+				"compression":  "gzip-9",
+				"volblocksize": "16384",
+				"sparse":       "true",
+			},
+			out: &VolumeOptions{
+				Compression: "gzip-9",
+				BlockSize:   16384,
+				Sparse:      true,
+			},
+		},
+		{
+			description: "unknown option",
+			options:     map[string]string{"copies": "3"},
+			err:         ErrInvalidVolumeOption,
+		},
+		{
+			description: "dangerous option",
+			options:     map[string]string{"refreservation": "none"},
+			err:         ErrInvalidVolumeOption,
+		},
+		{
+			description: "unknown compression algorithm",
+			options:     map[string]string{"compression": "gzip-10"},
+			err:         ErrInvalidVolumeOption,
+		},
+		{
+			description: "block size not a power of two",
+			options:     map[string]string{"volblocksize": "3000"},
+			err:         ErrInvalidVolumeOption,
+		},
+		{
+			description: "block size too large",
+			options:     map[string]string{"volblocksize": "262144"},
+			err:         ErrInvalidVolumeOption,
+		},
+		{
+			description: "invalid sparse value",
+			options:     map[string]string{"sparse": "maybe"},
+			err:         ErrInvalidVolumeOption,
+		},
+	}
+
+	for _, test := range tests {
+		out, err := ParseVolumeOptions(test.options)
+		if err != test.err {
+			t.Fatalf("unexpected error: %v != %v [description: %s]", err, test.err, test.description)
+		}
+		if !reflect.DeepEqual(out, test.out) {
+			t.Fatalf("unexpected options: %+v != %+v [description: %s]", out, test.out, test.description)
+		}
+	}
+}
+
+// TestVolumeOptionsProperties verifies that volume options are converted to
+// the correct ZFS properties.
+func TestVolumeOptionsProperties(t *testing.T) {
+	var tests = []struct {
+		options *VolumeOptions
+		props   map[string]string
+	}{
+		{},
+		{options: &VolumeOptions{}},
+		{
+			options: &VolumeOptions{
+				Compression: "lz4",
+				BlockSize:   8192,
+				Sparse:      true,
+			},
+			props: map[string]string{
+				"compression":    "lz4",
+				"volblocksize":   "8192",
+				"refreservation": "none",
+			},
+		}, {
+			options: &VolumeOptions{
+				Compression: "lz4",
+				Class: &Class{
+					Name:       "db",
+					Properties: map[string]string{"logbias": "latency"},
+				},
+			},
+			props: map[string]string{
+				"compression":  "lz4",
+				"logbias":      "latency",
+				"zstore:class": "db",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		if props := test.options.properties(); !reflect.DeepEqual(props, test.props) {
+			t.Fatalf("unexpected properties: %v != %v", props, test.props)
+		}
+	}
+}
+
+// TestVolumeOptionsClassConflict verifies that options cannot override the
+// properties of a storage class.
+func TestVolumeOptionsClassConflict(t *testing.T) {
+	class := &Class{
+		Name:       "archive",
+		Properties: map[string]string{"compression": "gzip-9"},
+	}
+
+	o := &VolumeOptions{
+		BlockSize: 16384,
+		Class:     class,
+	}
+	if err := o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	o.Compression = "lz4"
+	if err := o.Validate(); err != ErrInvalidVolumeOption {
+		t.Fatalf("unexpected error: %v != %v", err, ErrInvalidVolumeOption)
+	}
+}
@@ -2,6 +2,10 @@ package storage
 
 import (
 	"errors"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/mdlayher/zstore/storage/zfsutil"
 
@@ -17,14 +21,62 @@ var (
 // Pool is a storage pool from which Volumes can be created.  Typically, this
 // is a ZFS-based storage pool.  The implementation is swappable to enable
 // proper testing.
+//
+// Free returns the number of bytes which are available for new volumes.  If a
+// Pool has no limit on its capacity, Free returns math.MaxUint64.  Status
+// returns the current health and capacity of a Pool, and Stats returns its
+// I/O statistics.
 type Pool interface {
 	Name() string
+	Free() (uint64, error)
+	Status() (*PoolStatus, error)
+	Stats() (*PoolStats, error)
 
-	CreateVolume(string, uint64) (Volume, error)
+	CreateVolume(string, uint64, *VolumeOptions) (Volume, error)
+	CloneVolume(string, string, string) (Volume, error)
 	ListVolumes(string) ([]Volume, error)
 	Volume(string) (Volume, error)
 }
 
+// PoolStatus is the current health and capacity of a Pool, in the form
+// reported by 'zpool list'.
+//
+// Allocated, Free, and Size are numbers of bytes.  If a Pool has no limit on
+// its capacity, Size and Free are 0.  Fragmentation is the percentage of free
+// space which is fragmented, and DedupRatio is the ratio of deduplication
+// achieved by the pool; pools which do not support them report 0 and 1.
+// Volumes is the number of volumes in all buckets of the pool, and Buckets
+// maps the name of each bucket which contains volumes to its number of
+// volumes.
+type PoolStatus struct {
+	Name          string
+	Health        string
+	Allocated     uint64
+	Free          uint64
+	Size          uint64
+	Fragmentation uint64
+	DedupRatio    float64
+	Volumes       int
+	Buckets       map[string]int
+}
+
+// Online determines if a pool is healthy, and can serve all of its volumes.
+func (s *PoolStatus) Online() bool {
+	return s.Health == zfs.ZpoolOnline
+}
+
+// PoolStats are the I/O statistics of a Pool and its volumes, and of the ZFS
+// ARC, which is shared by all pools on a host.  Statistics which a Pool does
+// not report are nil.
+//
+// IO is the I/O performed by the pool as a whole, and Volumes maps the name
+// of each volume with I/O statistics to its I/O since it was opened.
+type PoolStats struct {
+	ARC     *zfsutil.ARCStats
+	IO      *zfsutil.IOStats
+	Volumes map[string]*zfsutil.IOStats
+}
+
 // Zpool is a ZFS-backed implementation of Pool.  It enables creation of Zvols,
 // which implement Volume.
 type Zpool struct {
@@ -36,12 +88,172 @@ func (z *Zpool) Name() string {
 	return z.zpool.Name
 }
 
-// CreateVolume creates a new Zvol from a Zpool with the specified name and
-// size in bytes.
-func (z *Zpool) CreateVolume(name string, size uint64) (Volume, error) {
-	// Attempt to create volume by name with specified size
-	zvol, err := zfs.CreateVolume(name, size, nil)
+// Free returns the number of bytes available for new volumes in a Zpool,
+// which is the space available to its root dataset.  Unlike the free space
+// reported by 'zpool list', this accounts for the space reserved by existing
+// volumes.
+func (z *Zpool) Free() (uint64, error) {
+	start := time.Now()
+	root, err := zfs.GetDataset(z.zpool.Name)
+	observeZFS("get_dataset", start, err)
+	if err != nil {
+		return 0, err
+	}
+
+	return root.Avail, nil
+}
+
+// Status returns the current health and capacity of a Zpool, and the number
+// of zvols within it and each of its buckets.
+func (z *Zpool) Status() (*PoolStatus, error) {
+	start := time.Now()
+	props, err := zfsutil.PoolProperties(z.zpool.Name, "health", "allocated", "free", "size", "fragmentation", "dedupratio")
+	observeZFS("get_pool_properties", start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &PoolStatus{
+		Name:   z.zpool.Name,
+		Health: props["health"],
+	}
+
+	for _, p := range []struct {
+		name string
+		v    *uint64
+	}{
+		{name: "allocated", v: &s.Allocated},
+		{name: "free", v: &s.Free},
+		{name: "size", v: &s.Size},
+	} {
+		if *p.v, err = strconv.ParseUint(props[p.name], 10, 64); err != nil {
+			return nil, err
+		}
+	}
+
+	// Fragmentation is reported as '-' by pools which do not track it, and
+	// may be suffixed with '%', depending on platform
+	if f := strings.TrimSuffix(props["fragmentation"], "%"); f != "-" {
+		if s.Fragmentation, err = strconv.ParseUint(f, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+
+	// Deduplication ratio may be suffixed with 'x', depending on platform
+	if s.DedupRatio, err = strconv.ParseFloat(strings.TrimSuffix(props["dedupratio"], "x"), 64); err != nil {
+		return nil, err
+	}
+
+	start = time.Now()
+	zvols, err := zfs.Volumes(z.zpool.Name)
+	observeZFS("list_volumes", start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Volumes = len(zvols)
+	s.Buckets = make(map[string]int)
+	for _, zvol := range zvols {
+		s.Buckets[path.Dir(zvol.Name)]++
+	}
+
+	return s, nil
+}
+
+// Stats returns the I/O statistics of a Zpool and its zvols, and of the ARC,
+// from the kernel statistics exported by ZFS on Linux.  On other systems, and
+// for statistics which the loaded version of ZFS does not export, the
+// statistics are nil.
+func (z *Zpool) Stats() (*PoolStats, error) {
+	s := new(PoolStats)
+
+	arc, err := zfsutil.ReadARCStats()
+	if err != nil && !zfsutil.IsStatsUnavailable(err) {
+		return nil, err
+	}
+	s.ARC = arc
+
+	pio, err := zfsutil.ReadPoolIOStats(z.zpool.Name)
+	if err != nil && !zfsutil.IsStatsUnavailable(err) {
+		return nil, err
+	}
+	s.IO = pio
+
+	objsets, err := zfsutil.ReadObjsetStats(z.zpool.Name)
 	if err != nil {
+		if zfsutil.IsStatsUnavailable(err) {
+			return s, nil
+		}
+
+		return nil, err
+	}
+
+	// Statistics are exported for every open dataset, but zvols are always
+	// named by pool, bucket, and volume
+	s.Volumes = make(map[string]*zfsutil.IOStats)
+	for name, vio := range objsets {
+		if strings.Count(name, "/") == 2 {
+			s.Volumes[name] = vio
+		}
+	}
+
+	return s, nil
+}
+
+// CreateVolume creates a new Zvol from a Zpool with the specified name, size
+// in bytes, and optional creation options.
+func (z *Zpool) CreateVolume(name string, size uint64, options *VolumeOptions) (Volume, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Attempt to create volume by name with specified size and properties
+	start := time.Now()
+	zvol, err := zfs.CreateVolume(name, size, options.properties())
+	observeZFS("create_volume", start, err)
+	if err != nil {
+		// If volume already exists, return exists
+		if zfsutil.IsDatasetExists(err) {
+			return nil, ErrVolumeExists
+		}
+
+		// If pool is out of space, return out of space
+		if zfsutil.IsOutOfSpace(err) {
+			return nil, ErrPoolOutOfSpace
+		}
+
+		return nil, err
+	}
+
+	return &Zvol{
+		zvol: zvol,
+	}, nil
+}
+
+// CloneVolume creates a new Zvol from a Zpool with the specified name, which is
+// a ZFS clone of the named snapshot of the source volume.
+func (z *Zpool) CloneVolume(name string, source string, snapshot string) (Volume, error) {
+	// Ensure source is a volume, so snapshots of other datasets cannot be cloned
+	v, err := z.Volume(source)
+	if err != nil {
+		return nil, err
+	}
+
+	snap, err := v.(*Zvol).snapshot(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	// Attempt to clone snapshot to volume by name
+	start := time.Now()
+	zvol, err := snap.Clone(name, nil)
+	observeZFS("clone", start, err)
+	if err != nil {
+		// If volume already exists, return exists
+		if zfsutil.IsDatasetExists(err) {
+			return nil, ErrVolumeExists
+		}
+
 		// If pool is out of space, return out of space
 		if zfsutil.IsOutOfSpace(err) {
 			return nil, ErrPoolOutOfSpace
@@ -59,7 +271,9 @@ func (z *Zpool) CreateVolume(name string, size uint64) (Volume, error) {
 // typically by user.
 func (z *Zpool) ListVolumes(bucket string) ([]Volume, error) {
 	// Attempt to retrieve 'root' dataset for user
+	start := time.Now()
 	root, err := zfs.GetDataset(bucket)
+	observeZFS("get_dataset", start, err)
 	if err != nil {
 		// If dataset does not exist, return not exists
 		if zfsutil.IsDatasetNotExists(err) {
@@ -71,7 +285,9 @@ func (z *Zpool) ListVolumes(bucket string) ([]Volume, error) {
 	}
 
 	// Fetch child datasets which are also volumes
+	start = time.Now()
 	children, err := root.Children(1)
+	observeZFS("list_children", start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -96,7 +312,9 @@ func (z *Zpool) ListVolumes(bucket string) ([]Volume, error) {
 // Volume attempts to retrieve a Zvol from a Zpool by its name.
 func (z *Zpool) Volume(name string) (Volume, error) {
 	// Attempt to fetch volume by name
+	start := time.Now()
 	zvol, err := zfs.GetDataset(name)
+	observeZFS("get_dataset", start, err)
 	if err != nil {
 		// If dataset does not exist, return not exists
 		if zfsutil.IsDatasetNotExists(err) {
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidSize is returned when a size cannot be parsed.
+	ErrInvalidSize = errors.New("invalid size")
+)
+
+// sizeUnits maps the unit prefixes accepted by ParseSize to their number of
+// bytes.  As with ZFS, all units are powers of 1024.
+var sizeUnits = map[string]uint64{
+	"":  1,
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+	"T": 1 << 40,
+	"P": 1 << 50,
+	"E": 1 << 60,
+}
+
+// formatUnits are the units used by FormatSize, from largest to smallest.
+var formatUnits = []string{"E", "P", "T", "G", "M", "K"}
+
+// ParseSize parses a human-readable size into a number of bytes.  A size is
+// a decimal number, optionally followed by a unit such as K, M, G, or T, which
+// may be suffixed with 'B' or 'iB'.  Units are case-insensitive, and as with
+// ZFS, all units are powers of 1024: 1536M, 1.5G, 1.5GiB, and 1.5GB are all
+// the same size.  A number without a unit, or with only 'B', is a number of
+// bytes.  Fractional bytes are truncated.
+//
+// ErrInvalidSize is returned if the size cannot be parsed, or if it does not
+// fit in a uint64.
+func ParseSize(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+
+	// Split the number from its unit
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i == -1 {
+		i = len(s)
+	}
+	num, unit := s[:i], strings.ToUpper(strings.TrimSpace(s[i:]))
+
+	// Units may be suffixed with 'B' or 'iB', and 'B' alone means bytes
+	switch {
+	case strings.HasSuffix(unit, "IB") && len(unit) == 3:
+		unit = unit[:1]
+	case unit == "B" || (strings.HasSuffix(unit, "B") && len(unit) == 2):
+		unit = unit[:len(unit)-1]
+	}
+
+	mult, ok := sizeUnits[unit]
+	if !ok || num == "" || num[0] == '.' || strings.Count(num, ".") > 1 {
+		return 0, ErrInvalidSize
+	}
+
+	// Use exact arithmetic, so that fractional sizes are not subject to
+	// floating point rounding
+	r, ok := new(big.Rat).SetString(num)
+	if !ok {
+		return 0, ErrInvalidSize
+	}
+	r.Mul(r, new(big.Rat).SetInt(new(big.Int).SetUint64(mult)))
+
+	size := new(big.Int).Quo(r.Num(), r.Denom())
+	if !size.IsUint64() {
+		return 0, ErrInvalidSize
+	}
+
+	return size.Uint64(), nil
+}
+
+// FormatSize formats a number of bytes as a human-readable size, using the
+// largest unit in which the size is at least 1, such as 1.5G.  Sizes are
+// rounded to at most two decimal places, and sizes smaller than 1K are
+// formatted as a number of bytes, such as 512B.  Any size formatted by
+// FormatSize without rounding can be parsed by ParseSize.
+func FormatSize(size uint64) string {
+	for _, u := range formatUnits {
+		mult := sizeUnits[u]
+		if size < mult {
+			continue
+		}
+
+		v := float64(size) / float64(mult)
+		v = math.Round(v*100) / 100
+
+		return strconv.FormatFloat(v, 'f', -1, 64) + u
+	}
+
+	return strconv.FormatUint(size, 10) + "B"
+}
+
+// RoundSize rounds a size in bytes up to the nearest multiple of a volume's
+// block size, since the size of a zvol must be a multiple of its block size.
+// If blockSize is 0, the size is rounded to a multiple of the largest valid
+// block size, which is also a multiple of every other valid block size.  Sizes
+// which cannot be rounded up without overflowing are rounded down instead.
+func RoundSize(size uint64, blockSize uint64) uint64 {
+	if blockSize == 0 {
+		blockSize = maxBlockSize
+	}
+
+	r := size % blockSize
+	if r == 0 {
+		return size
+	}
+
+	if size > math.MaxUint64-(blockSize-r) {
+		return size - r
+	}
+
+	return size + (blockSize - r)
+}
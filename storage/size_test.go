@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"testing"
+)
+
+// TestParseSize verifies that human-readable sizes are parsed correctly, and
+// that invalid sizes return errors.
+func TestParseSize(t *testing.T) {
+	var tests = []struct {
+		in   string
+		size uint64
+		err  error
+	}{
+		{in: "1073741824", size: 1 * GB},
+		{in: "512B", size: 512},
+		{in: "1536M", size: 1536 * MB},
+		{in: "1.5G", size: 1536 * MB},
+		{in: "1.5GiB", size: 1536 * MB},
+		{in: "1.5gb", size: 1536 * MB},
+		{in: "10GiB", size: 10 * GB},
+		{in: "512MB", size: 512 * MB},
+		{in: " 2 T ", size: 2048 * GB},
+		{in: "0.5K", size: 512},
+		{in: "1.0001K", size: 1024},
+		{in: "16E", err: ErrInvalidSize},
+		{in: "18446744073709551615", size: 18446744073709551615},
+		{in: "18446744073709551616", err: ErrInvalidSize},
+		{in: "", err: ErrInvalidSize},
+		{in: "G", err: ErrInvalidSize},
+		{in: "-1G", err: ErrInvalidSize},
+		{in: ".5G", err: ErrInvalidSize},
+		{in: "1.2.3G", err: ErrInvalidSize},
+		{in: "1X", err: ErrInvalidSize},
+		{in: "1GiBB", err: ErrInvalidSize},
+		{in: "1e9", err: ErrInvalidSize},
+	}
+
+	for _, test := range tests {
+		size, err := ParseSize(test.in)
+		if err != test.err {
+			t.Fatalf("unexpected error: %v != %v [in: %q]", err, test.err, test.in)
+		}
+		if err != nil {
+			continue
+		}
+		if size != test.size {
+			t.Fatalf("unexpected size: %v != %v [in: %q]", size, test.size, test.in)
+		}
+	}
+}
+
+// TestFormatSize verifies that sizes are formatted using the largest unit
+// possible, and that sizes which are not rounded can be parsed again.
+func TestFormatSize(t *testing.T) {
+	var tests = []struct {
+		size    uint64
+		out     string
+		rounded bool
+	}{
+		{size: 0, out: "0B"},
+		{size: 512, out: "512B"},
+		{size: 1024, out: "1K"},
+		{size: 256 * MB, out: "256M"},
+		{size: 1536 * MB, out: "1.5G"},
+		{size: 100 * GB, out: "100G"},
+		{size: 1 * GB / 3, out: "341.33M", rounded: true},
+		{size: 2048 * GB, out: "2T"},
+	}
+
+	for _, test := range tests {
+		out := FormatSize(test.size)
+		if out != test.out {
+			t.Fatalf("unexpected output: %v != %v [size: %d]", out, test.out, test.size)
+		}
+
+		if test.rounded {
+			continue
+		}
+		if size, err := ParseSize(out); err != nil || size != test.size {
+			t.Fatalf("unexpected parsed size: %v != %v [out: %s] [err: %v]", size, test.size, out, err)
+		}
+	}
+}
+
+// TestRoundSize verifies that sizes are rounded up to a multiple of a block
+// size.
+func TestRoundSize(t *testing.T) {
+	var tests = []struct {
+		size      uint64
+		blockSize uint64
+		out       uint64
+	}{
+		{size: 1 * GB, blockSize: 8192, out: 1 * GB},
+		{size: 1*GB + 1, blockSize: 8192, out: 1*GB + 8192},
+		{size: 1000, blockSize: 512, out: 1024},
+		{size: 1, blockSize: 0, out: maxBlockSize},
+		{size: 18446744073709551615, blockSize: 512, out: 18446744073709551104},
+	}
+
+	for _, test := range tests {
+		if out := RoundSize(test.size, test.blockSize); out != test.out {
+			t.Fatalf("unexpected size: %v != %v [size: %d, block size: %d]", out, test.out, test.size, test.blockSize)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package storage
 
 import (
+	"errors"
+	"math"
 	"sort"
 	"strconv"
+	"strings"
 )
 
 // Common size constants for volume creation and resizing.
@@ -11,22 +14,102 @@ const (
 	GB = 1024 * MB
 )
 
-// storageSizeMap is a map of available slugs and int64 sizes
-var storageSizeMap = map[string]int64{
-	"256M": 256 * MB,
-	"512M": 512 * MB,
-	"1G":   1 * GB,
-	"2G":   2 * GB,
-	"4G":   4 * GB,
-	"8G":   8 * GB,
+var (
+	// ErrInvalidSlug is returned when a size slug is not valid.
+	ErrInvalidSlug = errors.New("invalid size slug")
+)
+
+// defaultSlugs are the size slugs in the default SlugCatalog.
+var defaultSlugs = []string{"256M", "512M", "1G", "2G", "4G", "8G"}
+
+// defaultCatalog is the SlugCatalog used by Slugs and SlugSize.
+var defaultCatalog = mustSlugCatalog(defaultSlugs)
+
+// slugSuffixes maps the byte suffixes of size slugs to precedence values, for
+// easy comparison.  Each precedence value is also the power of 1024 which the
+// suffix represents.
+var slugSuffixes = map[string]uint{
+	"B": 0,
+	"K": 1,
+	"M": 2,
+	"G": 3,
+	"T": 4,
+	"P": 5,
+	// It's fairly likely these won't be used, but why not?
+	"E": 6,
+	"Z": 7,
+	"Y": 8,
 }
 
-// Slugs returns a sorted list of all available size slugs which zstore
-// considers valid.
-func Slugs() []string {
+// A SlugCatalog is a set of size slugs, such as 256M or 1G, from which sizes
+// may be selected when volumes are created or resized.
+type SlugCatalog struct {
+	sizes map[string]int64
+}
+
+// NewSlugCatalog creates a SlugCatalog from a list of size slugs.  Each slug
+// must be a positive integer followed by a byte suffix, such as 16G or 100G.
+//
+// Slugs are ordered by their suffix, and then by their integer, so the order of
+// the slugs must also be the order of their sizes: for example, 2048M cannot be
+// used alongside 1G, since it would be ordered before 1G.  No two slugs may
+// have the same size.  ErrInvalidSlug is returned if any slug is invalid, or
+// if no slugs are specified.
+func NewSlugCatalog(slugs []string) (*SlugCatalog, error) {
+	if len(slugs) == 0 {
+		return nil, ErrInvalidSlug
+	}
+
+	c := &SlugCatalog{
+		sizes: make(map[string]int64, len(slugs)),
+	}
+
+	// Parse all slugs before sorting, since sorting panics on invalid slugs
+	for _, s := range slugs {
+		size, err := parseSlug(s)
+		if err != nil {
+			return nil, err
+		}
+
+		c.sizes[s] = size
+	}
+
+	// Ensure sorted slugs have strictly increasing sizes, which also rejects
+	// duplicate slugs and sizes
+	sorted := c.Slugs()
+	if len(sorted) != len(slugs) {
+		return nil, ErrInvalidSlug
+	}
+	for i := 1; i < len(sorted); i++ {
+		if c.sizes[sorted[i-1]] >= c.sizes[sorted[i]] {
+			return nil, ErrInvalidSlug
+		}
+	}
+
+	return c, nil
+}
+
+// mustSlugCatalog creates a SlugCatalog, and panics if any slug is invalid.
+func mustSlugCatalog(slugs []string) *SlugCatalog {
+	c, err := NewSlugCatalog(slugs)
+	if err != nil {
+		panic(err)
+	}
+
+	return c
+}
+
+// DefaultSlugCatalog returns the SlugCatalog of size slugs which zstore
+// considers valid when no other catalog is configured.
+func DefaultSlugCatalog() *SlugCatalog {
+	return defaultCatalog
+}
+
+// Slugs returns a sorted list of all size slugs in the catalog.
+func (c *SlugCatalog) Slugs() []string {
 	// Retrieve all slugs from map; order is currently undefined
-	var slugs []string
-	for k := range storageSizeMap {
+	slugs := make([]string, 0, len(c.sizes))
+	for k := range c.sizes {
 		slugs = append(slugs, k)
 	}
 
@@ -35,11 +118,60 @@ func Slugs() []string {
 	return slugs
 }
 
+// SlugSize checks if an input slug string is in the catalog, and returns the
+// size if possible.
+func (c *SlugCatalog) SlugSize(slug string) (int64, bool) {
+	size, ok := c.sizes[slug]
+	return size, ok
+}
+
+// Slugs returns a sorted list of all available size slugs which zstore
+// considers valid by default.
+func Slugs() []string {
+	return defaultCatalog.Slugs()
+}
+
 // SlugSize checks if an input slug string is a valid size constant for
-// zstore, and returns the size if possible.
+// zstore by default, and returns the size if possible.
 func SlugSize(slug string) (int64, bool) {
-	size, ok := storageSizeMap[slug]
-	return size, ok
+	return defaultCatalog.SlugSize(slug)
+}
+
+// parseSlug parses the size in bytes of a size slug, which is a positive
+// integer followed by one of the byte suffixes recognized by bySizeSlug.
+func parseSlug(slug string) (int64, error) {
+	if len(slug) < 2 {
+		return 0, ErrInvalidSlug
+	}
+
+	exp, ok := slugSuffixes[slug[len(slug)-1:]]
+	if !ok {
+		return 0, ErrInvalidSlug
+	}
+
+	// Only plain decimal digits are permitted, so that slugs are
+	// unambiguous
+	digits := slug[:len(slug)-1]
+	if strings.TrimLeft(digits, "0123456789") != "" || digits[0] == '0' {
+		return 0, ErrInvalidSlug
+	}
+
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidSlug
+	}
+
+	// Ensure size does not overflow an int64
+	size := n
+	for i := uint(0); i < exp; i++ {
+		if size > math.MaxInt64/1024 {
+			return 0, ErrInvalidSlug
+		}
+
+		size *= 1024
+	}
+
+	return size, nil
 }
 
 // bySizeSlug implements sort.Interface, for use in sorting size slugs which
@@ -58,29 +190,14 @@ func (s bySizeSlug) Swap(i int, j int) {
 
 // Less compares each size slug using both its integer value and its byte suffix.
 func (s bySizeSlug) Less(i int, j int) bool {
-	// Map known byte suffixes to precedence values, for easy
-	// comparison
-	suffixPrecedence := map[string]int{
-		"B": 0,
-		"K": 1,
-		"M": 2,
-		"G": 3,
-		"T": 4,
-		"P": 5,
-		// It's fairly likely these won't be used, but why not?
-		"E": 6,
-		"Z": 7,
-		"Y": 8,
-	}
-
 	// Capture the suffix character for the elements at indices
 	// i and j
 	iSuffix := s[i][len(s[i])-1:]
 	jSuffix := s[j][len(s[j])-1:]
 
 	// Ensure both suffix characters are present in map
-	iPrecedence, iOK := suffixPrecedence[iSuffix]
-	jPrecedence, jOK := suffixPrecedence[jSuffix]
+	iPrecedence, iOK := slugSuffixes[iSuffix]
+	jPrecedence, jOK := slugSuffixes[jSuffix]
 	if !iOK || !jOK {
 		panic("unknown size slug suffix")
 	}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestNewSlugCatalog verifies that size slug catalogs are validated using the
+// same ordering rules as bySizeSlug, and that slugs map to the correct sizes.
+func TestNewSlugCatalog(t *testing.T) {
+	var tests = []struct {
+		description string
+		slugs       []string
+		ok          bool
+	}{
+		{
+			description: "default slugs",
+			slugs:       defaultSlugs,
+			ok:          true,
+		},
+		{
+			description: "larger slugs, out of order",
+			slugs:       []string{"100G", "16G", "1T", "512M"},
+			ok:          true,
+		},
+		{
+			description: "no slugs",
+		},
+		{
+			description: "unknown suffix",
+			slugs:       []string{"1G", "16X"},
+		},
+		{
+			description: "no integer",
+			slugs:       []string{"G"},
+		},
+		{
+			description: "leading zero",
+			slugs:       []string{"01G"},
+		},
+		{
+			description: "non-decimal integer",
+			slugs:       []string{"1.5G"},
+		},
+		{
+			description: "duplicate slug",
+			slugs:       []string{"1G", "1G"},
+		},
+		{
+			description: "duplicate size",
+			slugs:       []string{"1024M", "1G"},
+		},
+		{
+			description: "misordered by suffix",
+			slugs:       []string{"2048M", "1G"},
+		},
+		{
+			description: "overflow",
+			slugs:       []string{"16E"},
+		},
+	}
+
+	for _, test := range tests {
+		_, err := NewSlugCatalog(test.slugs)
+		if ok := err == nil; ok != test.ok {
+			t.Fatalf("unexpected result: %v != %v [description: %s] [err: %v]", ok, test.ok, test.description, err)
+		}
+	}
+
+	c, err := NewSlugCatalog([]string{"100G", "16G", "1T", "512M"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := c.Slugs(); !reflect.DeepEqual(s, []string{"512M", "16G", "100G", "1T"}) {
+		t.Fatalf("unexpected slugs: %v", s)
+	}
+	if size, ok := c.SlugSize("100G"); !ok || size != 100*GB {
+		t.Fatalf("unexpected size: %v != %v", size, 100*GB)
+	}
+	if _, ok := c.SlugSize("1G"); ok {
+		t.Fatal("slug not in catalog was found")
+	}
+}
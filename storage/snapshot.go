@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"errors"
+	"regexp"
+	"time"
+)
+
+var (
+	// ErrSnapshotNotExists is returned when an invalid snapshot name is
+	// provided by a caller.
+	ErrSnapshotNotExists = errors.New("snapshot not found")
+
+	// ErrSnapshotExists is returned when a caller attempts to create a
+	// snapshot with the same name as an existing snapshot of a volume.
+	ErrSnapshotExists = errors.New("snapshot already exists")
+
+	// ErrNewerSnapshotsExist is returned when a caller attempts to roll back
+	// a volume to a snapshot which is not its most recent snapshot, without
+	// permitting the newer snapshots to be destroyed.
+	ErrNewerSnapshotsExist = errors.New("newer snapshots exist")
+
+	// ErrDependentClones is returned when a caller attempts to destroy a
+	// snapshot, or a volume with snapshots, which other volumes were cloned
+	// from.
+	ErrDependentClones = errors.New("snapshot has dependent clones")
+
+	// ErrInvalidSnapshotName is returned when a snapshot name contains
+	// characters which are not permitted by ZFS.
+	ErrInvalidSnapshotName = errors.New("invalid snapshot name")
+)
+
+// snapshotNameRegexp matches the characters which ZFS permits in the name
+// of a snapshot.
+var snapshotNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+
+// Snapshot is a read-only, point-in-time copy of a Volume.
+type Snapshot struct {
+	// Name is the name of the snapshot, without its volume name prefix.
+	Name string
+
+	// Created is the time at which the snapshot was taken.
+	Created time.Time
+
+	// Used is the number of bytes consumed by the snapshot.
+	Used uint64
+}
+
+// validSnapshotName determines if an input snapshot name is valid for use
+// with any Volume implementation.
+func validSnapshotName(name string) bool {
+	return len(name) <= 255 && snapshotNameRegexp.MatchString(name)
+}
+
+// bySnapshotCreated implements sort.Interface, for use in sorting snapshots
+// from oldest to newest.
+type bySnapshotCreated []*Snapshot
+
+// Len returns the length of the collection.
+func (s bySnapshotCreated) Len() int {
+	return len(s)
+}
+
+// Swap swaps two values by their index.
+func (s bySnapshotCreated) Swap(i int, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// Less compares each snapshot using its creation time.
+func (s bySnapshotCreated) Less(i int, j int) bool {
+	return s[i].Created.Before(s[j].Created)
+}
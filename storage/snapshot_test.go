@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"testing"
+)
+
+// testVolumeSnapshots verifies common snapshot behavior for any Volume
+// implementation, leaving a single snapshot named "two" in place.
+func testVolumeSnapshots(t *testing.T, volume Volume) {
+	// Invalid snapshot names are rejected
+	for _, name := range []string{"", "foo@bar", "foo/bar"} {
+		if _, err := volume.CreateSnapshot(name); err != ErrInvalidSnapshotName {
+			t.Fatalf("unexpected error for snapshot %q: %v != %v", name, err, ErrInvalidSnapshotName)
+		}
+	}
+
+	for _, name := range []string{"one", "two"} {
+		s, err := volume.CreateSnapshot(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Name != name {
+			t.Fatalf("unexpected snapshot name: %v != %v", s.Name, name)
+		}
+	}
+
+	if _, err := volume.CreateSnapshot("one"); err != ErrSnapshotExists {
+		t.Fatalf("unexpected error for duplicate snapshot: %v != %v", err, ErrSnapshotExists)
+	}
+
+	snapshots, err := volume.Snapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Name != "one" || snapshots[1].Name != "two" {
+		t.Fatalf("unexpected snapshots: %v", snapshots)
+	}
+
+	if err := volume.DestroySnapshot("one"); err != nil {
+		t.Fatal(err)
+	}
+	if err := volume.DestroySnapshot("one"); err != ErrSnapshotNotExists {
+		t.Fatalf("unexpected error for destroyed snapshot: %v != %v", err, ErrSnapshotNotExists)
+	}
+
+	snapshots, err = volume.Snapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 || snapshots[0].Name != "two" {
+		t.Fatalf("unexpected snapshots: %v", snapshots)
+	}
+}
+
+// testVolumeRollback verifies common rollback behavior for any Volume
+// implementation.
+func testVolumeRollback(t *testing.T, volume Volume) {
+	for _, name := range []string{"one", "two", "three"} {
+		if _, err := volume.CreateSnapshot(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := volume.Rollback("four", false); err != ErrSnapshotNotExists {
+		t.Fatalf("unexpected error for unknown snapshot: %v != %v", err, ErrSnapshotNotExists)
+	}
+	if err := volume.Rollback("one", false); err != ErrNewerSnapshotsExist {
+		t.Fatalf("unexpected error for rollback: %v != %v", err, ErrNewerSnapshotsExist)
+	}
+	if err := volume.Rollback("three", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := volume.Rollback("two", true); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots, err := volume.Snapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[1].Name != "two" {
+		t.Fatalf("unexpected snapshots: %v", snapshots)
+	}
+}
+
+// testPoolClone verifies common clone behavior for any Pool implementation,
+// using a pool named "zstore".
+func testPoolClone(t *testing.T, pool Pool) {
+	volume, err := pool.CreateVolume("zstore/foo/bar", 16*MB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := volume.CreateSnapshot("base"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Clones take the size of the volume at the time of the snapshot
+	if err := volume.Resize(32 * MB); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pool.CloneVolume("zstore/foo/baz", "zstore/foo/qux", "base"); err != ErrVolumeNotExists {
+		t.Fatalf("unexpected error for unknown volume: %v != %v", err, ErrVolumeNotExists)
+	}
+	if _, err := pool.CloneVolume("zstore/foo/baz", "zstore/foo/bar", "qux"); err != ErrSnapshotNotExists {
+		t.Fatalf("unexpected error for unknown snapshot: %v != %v", err, ErrSnapshotNotExists)
+	}
+	if _, err := pool.CloneVolume("zstore/foo/bar", "zstore/foo/bar", "base"); err != ErrVolumeExists {
+		t.Fatalf("unexpected error for existing volume: %v != %v", err, ErrVolumeExists)
+	}
+
+	clone, err := pool.CloneVolume("zstore/foo/baz", "zstore/foo/bar", "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if origin := clone.Origin(); origin != "zstore/foo/bar@base" {
+		t.Fatalf("unexpected clone origin: %v != %v", origin, "zstore/foo/bar@base")
+	}
+	if size := clone.Size(); size != 16*MB {
+		t.Fatalf("unexpected clone size: %v != %v", size, 16*MB)
+	}
+
+	// Origin and size are also reported when the clone is retrieved later
+	clone, err = pool.Volume("zstore/foo/baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if origin := clone.Origin(); origin != "zstore/foo/bar@base" {
+		t.Fatalf("unexpected clone origin: %v != %v", origin, "zstore/foo/bar@base")
+	}
+	if size := clone.Size(); size != 16*MB {
+		t.Fatalf("unexpected clone size: %v != %v", size, 16*MB)
+	}
+
+	// Origin cannot be destroyed until its clone is destroyed
+	if err := volume.DestroySnapshot("base"); err != ErrDependentClones {
+		t.Fatalf("unexpected error for snapshot with clones: %v != %v", err, ErrDependentClones)
+	}
+	if err := volume.Destroy(); err != ErrDependentClones {
+		t.Fatalf("unexpected error for volume with clones: %v != %v", err, ErrDependentClones)
+	}
+
+	if err := clone.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if err := volume.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,6 +2,12 @@ package storage
 
 import (
 	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mdlayher/zstore/storage/zfsutil"
 
 	"gopkg.in/mistifyio/go-zfs.v2"
 )
@@ -10,15 +16,50 @@ var (
 	// ErrVolumeNotExists is returned when an invalid volume name is provided
 	// by a caller.
 	ErrVolumeNotExists = errors.New("volume not found")
+
+	// ErrVolumeExists is returned when a caller attempts to create a volume
+	// with the same name as an existing volume.
+	ErrVolumeExists = errors.New("volume already exists")
 )
 
 // Volume is a block storage volume which is allocated from a Pool.  Typically,
 // this is a ZFS-based zvol.
+//
+// Stats returns the I/O statistics of a Volume since it was opened.  If a
+// Volume does not track I/O statistics, Stats returns nil.
 type Volume interface {
 	Name() string
 	Size() uint64
+	Origin() string
+	Metadata() (*VolumeMetadata, error)
+	Stats() (*zfsutil.IOStats, error)
 
 	Destroy() error
+	Resize(uint64) error
+
+	Labels() (map[string]string, error)
+	SetLabels(map[string]string) error
+
+	CreateSnapshot(string) (*Snapshot, error)
+	Snapshots() ([]*Snapshot, error)
+	DestroySnapshot(string) error
+	Rollback(string, bool) error
+}
+
+// VolumeMetadata is metadata about the space usage and lifetime of a Volume.
+// Used is the space consumed by a volume and its snapshots, and Referenced
+// is the space consumed by the data currently in the volume.  Device is the
+// path of the block device or file which provides access to the volume.
+// Class is the name of the volume's storage class, if it has one.  BlockSize
+// is the volume's block size in bytes, or 0 if it is not known.
+type VolumeMetadata struct {
+	Used          uint64
+	Referenced    uint64
+	CompressRatio float64
+	Created       time.Time
+	Device        string
+	Class         string
+	BlockSize     uint64
 }
 
 // Zvol is a ZFS-backed implementation of Volume.  It represents block storage
@@ -27,9 +68,98 @@ type Zvol struct {
 	zvol *zfs.Dataset
 }
 
-// Destroy completely destroys this volume.
+// Destroy completely destroys this volume, including all of its snapshots.
 func (z *Zvol) Destroy() error {
-	return z.zvol.Destroy(zfs.DestroyRecursive)
+	start := time.Now()
+	err := z.zvol.Destroy(zfs.DestroyRecursive)
+	observeZFS("destroy", start, err)
+	if err != nil {
+		// If snapshots have clones, return dependent clones
+		if zfsutil.IsDependentClones(err) {
+			return ErrDependentClones
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Resize sets the size of this zvol to the specified size in bytes.
+func (z *Zvol) Resize(size uint64) error {
+	start := time.Now()
+	err := z.zvol.SetProperty("volsize", strconv.FormatUint(size, 10))
+	observeZFS("set_property", start, err)
+	if err != nil {
+		// If pool is out of space, return out of space
+		if zfsutil.IsOutOfSpace(err) {
+			return ErrPoolOutOfSpace
+		}
+
+		return err
+	}
+
+	z.zvol.Volsize = size
+	return nil
+}
+
+// Labels returns the labels of this zvol, which are stored as ZFS user
+// properties.
+func (z *Zvol) Labels() (map[string]string, error) {
+	start := time.Now()
+	labels, err := zfsutil.UserProperties(z.zvol.Name, labelProperty)
+	observeZFS("get_properties", start, err)
+	if err != nil {
+		// If dataset does not exist, return not exists
+		if zfsutil.IsDatasetNotExists(err) {
+			return nil, ErrVolumeNotExists
+		}
+
+		return nil, err
+	}
+
+	return labels, nil
+}
+
+// SetLabels replaces the labels of this zvol, setting a ZFS user property for
+// each label, and removing the user properties of any labels not present.
+func (z *Zvol) SetLabels(labels map[string]string) error {
+	if err := ValidateLabels(labels); err != nil {
+		return err
+	}
+
+	current, err := z.Labels()
+	if err != nil {
+		return err
+	}
+
+	for k := range current {
+		if _, ok := labels[k]; ok {
+			continue
+		}
+
+		start := time.Now()
+		err := zfsutil.InheritProperty(z.zvol.Name, labelProperty+k)
+		observeZFS("inherit_property", start, err)
+		if err != nil {
+			return err
+		}
+	}
+
+	for k, v := range labels {
+		if cv, ok := current[k]; ok && cv == v {
+			continue
+		}
+
+		start := time.Now()
+		err := zfsutil.SetProperty(z.zvol.Name, labelProperty+k, v)
+		observeZFS("set_property", start, err)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // Name returns the name of a ZFS zvol.
@@ -41,3 +171,247 @@ func (z *Zvol) Name() string {
 func (z *Zvol) Size() uint64 {
 	return z.zvol.Volsize
 }
+
+// Origin returns the name of the ZFS snapshot from which a zvol was cloned,
+// or an empty string if it is not a clone.
+func (z *Zvol) Origin() string {
+	return z.zvol.Origin
+}
+
+// Metadata retrieves the current space usage and creation time of this zvol.
+func (z *Zvol) Metadata() (*VolumeMetadata, error) {
+	start := time.Now()
+	props, err := zfsutil.Properties(z.zvol.Name, "used", "referenced", "compressratio", "creation", "volblocksize", classProperty)
+	observeZFS("get_properties", start, err)
+	if err != nil {
+		// If dataset does not exist, return not exists
+		if zfsutil.IsDatasetNotExists(err) {
+			return nil, ErrVolumeNotExists
+		}
+
+		return nil, err
+	}
+
+	used, err := strconv.ParseUint(props["used"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	referenced, err := strconv.ParseUint(props["referenced"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compression ratio may be suffixed with 'x', depending on platform
+	ratio, err := strconv.ParseFloat(strings.TrimSuffix(props["compressratio"], "x"), 64)
+	if err != nil {
+		return nil, err
+	}
+
+	// Creation time is returned as a UNIX timestamp
+	creation, err := strconv.ParseInt(props["creation"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	blockSize, err := strconv.ParseUint(props["volblocksize"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unset user properties are reported as '-'
+	class := props[classProperty]
+	if class == "-" {
+		class = ""
+	}
+
+	return &VolumeMetadata{
+		Used:          used,
+		Referenced:    referenced,
+		CompressRatio: ratio,
+		Created:       time.Unix(creation, 0),
+		Device:        "/dev/zvol/" + z.zvol.Name,
+		Class:         class,
+		BlockSize:     blockSize,
+	}, nil
+}
+
+// Stats returns the I/O statistics of this zvol since it was opened, from the
+// kernel statistics exported by ZFS on Linux.  On other systems, and if the
+// loaded version of ZFS does not export statistics for the zvol, nil is
+// returned.
+func (z *Zvol) Stats() (*zfsutil.IOStats, error) {
+	// The objset ID identifies the zvol's kernel statistics
+	start := time.Now()
+	props, err := zfsutil.Properties(z.zvol.Name, "objsetid")
+	observeZFS("get_properties", start, err)
+	if err != nil {
+		// If dataset does not exist, return not exists
+		if zfsutil.IsDatasetNotExists(err) {
+			return nil, ErrVolumeNotExists
+		}
+
+		return nil, err
+	}
+
+	// Older versions of ZFS do not report the objset ID
+	id, err := strconv.ParseUint(props["objsetid"], 10, 64)
+	if err != nil {
+		return nil, nil
+	}
+
+	s, err := zfsutil.ReadDatasetIOStats(z.zvol.Name, id)
+	if err != nil {
+		if zfsutil.IsStatsUnavailable(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// CreateSnapshot creates a new ZFS snapshot of this zvol with the specified
+// name.
+func (z *Zvol) CreateSnapshot(name string) (*Snapshot, error) {
+	if !validSnapshotName(name) {
+		return nil, ErrInvalidSnapshotName
+	}
+
+	// Attempt to create snapshot by name
+	start := time.Now()
+	snap, err := z.zvol.Snapshot(name, false)
+	observeZFS("snapshot", start, err)
+	if err != nil {
+		// If snapshot already exists, return exists
+		if zfsutil.IsDatasetExists(err) {
+			return nil, ErrSnapshotExists
+		}
+
+		return nil, err
+	}
+
+	return zfsSnapshot(snap)
+}
+
+// Snapshots returns a list of all ZFS snapshots of this zvol, ordered from
+// oldest to newest.
+func (z *Zvol) Snapshots() ([]*Snapshot, error) {
+	// Fetch all snapshots of this zvol and their creation times at once
+	start := time.Now()
+	zsnaps, err := zfsutil.Snapshots(z.zvol.Name)
+	observeZFS("list_snapshots", start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]*Snapshot, 0, len(zsnaps))
+	for _, s := range zsnaps {
+		snapshots = append(snapshots, &Snapshot{
+			Name:    s.Name[strings.Index(s.Name, "@")+1:],
+			Created: s.Created,
+			Used:    s.Used,
+		})
+	}
+
+	sort.Stable(bySnapshotCreated(snapshots))
+	return snapshots, nil
+}
+
+// DestroySnapshot destroys a single ZFS snapshot of this zvol by name.
+func (z *Zvol) DestroySnapshot(name string) error {
+	snap, err := z.snapshot(name)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = snap.Destroy(zfs.DestroyDefault)
+	observeZFS("destroy", start, err)
+	if err != nil {
+		// If snapshot has clones, return dependent clones
+		if zfsutil.IsDependentClones(err) {
+			return ErrDependentClones
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Rollback reverts this zvol to the named ZFS snapshot.  If destroyNewer is
+// true, any snapshots newer than the named snapshot are destroyed; otherwise,
+// ErrNewerSnapshotsExist is returned if any exist.
+func (z *Zvol) Rollback(name string, destroyNewer bool) error {
+	snap, err := z.snapshot(name)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = snap.Rollback(destroyNewer)
+	observeZFS("rollback", start, err)
+	if err != nil {
+		// If newer snapshots block the rollback, return newer exist
+		if zfsutil.IsNewerSnapshotsExist(err) {
+			return ErrNewerSnapshotsExist
+		}
+
+		// If newer snapshots have clones, return dependent clones
+		if zfsutil.IsDependentClones(err) {
+			return ErrDependentClones
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// snapshot attempts to retrieve a ZFS snapshot of this zvol by its name.
+func (z *Zvol) snapshot(name string) (*zfs.Dataset, error) {
+	if !validSnapshotName(name) {
+		return nil, ErrInvalidSnapshotName
+	}
+
+	// Attempt to fetch snapshot by name
+	start := time.Now()
+	snap, err := zfs.GetDataset(z.zvol.Name + "@" + name)
+	observeZFS("get_dataset", start, err)
+	if err != nil {
+		// If dataset does not exist, return not exists
+		if zfsutil.IsDatasetNotExists(err) {
+			return nil, ErrSnapshotNotExists
+		}
+
+		// All other errors
+		return nil, err
+	}
+
+	return snap, nil
+}
+
+// zfsSnapshot wraps a go-zfs snapshot Dataset in a Snapshot, retrieving any
+// properties which go-zfs does not parse.
+func zfsSnapshot(d *zfs.Dataset) (*Snapshot, error) {
+	// Creation time is returned as a UNIX timestamp
+	start := time.Now()
+	creation, err := zfsutil.Property(d.Name, "creation")
+	observeZFS("get_properties", start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	unix, err := strconv.ParseInt(creation, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Snapshot{
+		Name:    d.Name[strings.Index(d.Name, "@")+1:],
+		Created: time.Unix(unix, 0),
+		Used:    d.Used,
+	}, nil
+}
@@ -0,0 +1,286 @@
+package zfsutil
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidKstat is returned when a ZFS kernel statistic cannot be
+	// parsed.
+	ErrInvalidKstat = errors.New("invalid kstat")
+)
+
+const (
+	// kstatRoot is the directory in which ZFS on Linux exports its kernel
+	// statistics
+	kstatRoot = "/proc/spl/kstat/zfs"
+
+	// objsetPrefix is the prefix of the names of the kernel statistics for
+	// each dataset in a pool
+	objsetPrefix = "objset-"
+)
+
+// ARCStats are statistics about the ZFS Adaptive Replacement Cache, which is
+// shared by all pools on a host.
+//
+// Hits and Misses are the number of reads which were and were not served by
+// the ARC since ZFS was loaded.  Size is the current size of the ARC in bytes,
+// TargetSize is the size in bytes the ARC is adjusting towards, and MaxSize is
+// the size in bytes the ARC may not grow beyond.
+type ARCStats struct {
+	Hits       uint64
+	Misses     uint64
+	Size       uint64
+	TargetSize uint64
+	MaxSize    uint64
+}
+
+// HitRatio returns the ratio of reads which were served by the ARC, from 0 to
+// 1.  If no reads have occurred, 0 is returned.
+func (s *ARCStats) HitRatio() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+
+	return float64(s.Hits) / float64(total)
+}
+
+// IOStats are the number of read and write operations, and the number of
+// bytes read and written, for a pool or a dataset since it was imported or
+// opened.
+type IOStats struct {
+	Reads      uint64
+	Writes     uint64
+	ReadBytes  uint64
+	WriteBytes uint64
+}
+
+// ParseARCStats parses ARCStats from the contents of the 'arcstats' kernel
+// statistic.
+func ParseARCStats(r io.Reader) (*ARCStats, error) {
+	kstat, err := parseNamedKstat(r)
+	if err != nil {
+		return nil, err
+	}
+
+	s := new(ARCStats)
+	err = kstatValues(kstat, map[string]*uint64{
+		"hits":   &s.Hits,
+		"misses": &s.Misses,
+		"size":   &s.Size,
+		"c":      &s.TargetSize,
+		"c_max":  &s.MaxSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// ParsePoolIOStats parses IOStats from the contents of a pool's 'io' kernel
+// statistic.  Newer versions of ZFS no longer export this statistic.
+func ParsePoolIOStats(r io.Reader) (*IOStats, error) {
+	s := bufio.NewScanner(r)
+
+	// The header is followed by a line of names and a line of values
+	var lines [][]string
+	for s.Scan() {
+		lines = append(lines, strings.Fields(s.Text()))
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) != 3 || len(lines[1]) != len(lines[2]) {
+		return nil, ErrInvalidKstat
+	}
+
+	kstat := make(map[string]string, len(lines[1]))
+	for i, name := range lines[1] {
+		kstat[name] = lines[2][i]
+	}
+
+	return ioStats(kstat, "nread", "nwritten")
+}
+
+// ParseObjsetStats parses IOStats from the contents of one of a pool's
+// 'objset-*' kernel statistics, and returns the name of the dataset they
+// belong to.
+func ParseObjsetStats(r io.Reader) (string, *IOStats, error) {
+	kstat, err := parseNamedKstat(r)
+	if err != nil {
+		return "", nil, err
+	}
+
+	name := kstat["dataset_name"]
+	if name == "" {
+		return "", nil, ErrInvalidKstat
+	}
+
+	s, err := ioStats(kstat, "nread", "nwritten")
+	if err != nil {
+		return "", nil, err
+	}
+
+	return name, s, nil
+}
+
+// IsStatsUnavailable determines if an error returned while reading kernel
+// statistics indicates that they are not exported on this system, either
+// because it is not Linux, or because ZFS is not loaded or is too old or new
+// to export them.
+func IsStatsUnavailable(err error) bool {
+	return err == ErrNotImplemented || os.IsNotExist(err)
+}
+
+// readKstat opens a kernel statistic file and parses it with fn.
+func readKstat(file string, fn func(io.Reader) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return fn(f)
+}
+
+// readObjsetStats reads the IOStats of each dataset with kernel statistics in
+// the specified directory, keyed by the name of the dataset.
+func readObjsetStats(dir string) (map[string]*IOStats, error) {
+	// A directory which does not exist is globbed without error
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, objsetPrefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]*IOStats, len(files))
+	for _, file := range files {
+		err := readKstat(file, func(r io.Reader) error {
+			name, s, err := ParseObjsetStats(r)
+			if err != nil {
+				return err
+			}
+
+			stats[name] = s
+			return nil
+		})
+		if err != nil {
+			// Datasets may be closed while their statistics are read
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, err
+		}
+	}
+
+	return stats, nil
+}
+
+// readDatasetIOStats reads the IOStats of a dataset from the kernel statistic
+// in the specified directory for the objset with the specified ID.
+// ErrInvalidKstat is returned if the statistic belongs to another dataset.
+func readDatasetIOStats(dir string, dataset string, objsetID uint64) (*IOStats, error) {
+	var s *IOStats
+	file := filepath.Join(dir, objsetPrefix+"0x"+strconv.FormatUint(objsetID, 16))
+	err := readKstat(file, func(r io.Reader) error {
+		name, stats, err := ParseObjsetStats(r)
+		if err != nil {
+			return err
+		}
+		if name != dataset {
+			return ErrInvalidKstat
+		}
+
+		s = stats
+		return nil
+	})
+
+	return s, err
+}
+
+// parseNamedKstat parses a kernel statistic which consists of a header, and a
+// table of names, types, and values.
+func parseNamedKstat(r io.Reader) (map[string]string, error) {
+	s := bufio.NewScanner(r)
+
+	// Skip the header, and verify the columns of the table
+	for i := 0; i < 2; i++ {
+		if !s.Scan() {
+			if err := s.Err(); err != nil {
+				return nil, err
+			}
+
+			return nil, ErrInvalidKstat
+		}
+	}
+	if f := strings.Fields(s.Text()); len(f) != 3 || f[0] != "name" || f[1] != "type" || f[2] != "data" {
+		return nil, ErrInvalidKstat
+	}
+
+	kstat := make(map[string]string)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, ErrInvalidKstat
+		}
+
+		kstat[fields[0]] = strings.Join(fields[2:], " ")
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return kstat, nil
+}
+
+// ioStats parses IOStats from a kernel statistic, using the specified names
+// for the number of bytes read and written.
+func ioStats(kstat map[string]string, read string, written string) (*IOStats, error) {
+	s := new(IOStats)
+	err := kstatValues(kstat, map[string]*uint64{
+		"reads":  &s.Reads,
+		"writes": &s.Writes,
+		read:     &s.ReadBytes,
+		written:  &s.WriteBytes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// kstatValues parses the named values of a kernel statistic into integers.
+// All of the values must be present.
+func kstatValues(kstat map[string]string, values map[string]*uint64) error {
+	for name, v := range values {
+		s, ok := kstat[name]
+		if !ok {
+			return ErrInvalidKstat
+		}
+
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return ErrInvalidKstat
+		}
+
+		*v = n
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+// +build linux
+
+package zfsutil
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// ReadARCStats reads the current ARCStats from the kernel statistics exported
+// by ZFS on Linux.
+func ReadARCStats() (*ARCStats, error) {
+	var s *ARCStats
+	err := readKstat(filepath.Join(kstatRoot, "arcstats"), func(r io.Reader) error {
+		var err error
+		s, err = ParseARCStats(r)
+		return err
+	})
+
+	return s, err
+}
+
+// ReadPoolIOStats reads the current IOStats of the specified zpool from the
+// kernel statistics exported by ZFS on Linux.
+func ReadPoolIOStats(pool string) (*IOStats, error) {
+	var s *IOStats
+	err := readKstat(filepath.Join(kstatRoot, pool, "io"), func(r io.Reader) error {
+		var err error
+		s, err = ParsePoolIOStats(r)
+		return err
+	})
+
+	return s, err
+}
+
+// ReadObjsetStats reads the current IOStats of each open dataset in the
+// specified zpool from the kernel statistics exported by ZFS on Linux, keyed
+// by the name of the dataset.
+func ReadObjsetStats(pool string) (map[string]*IOStats, error) {
+	return readObjsetStats(filepath.Join(kstatRoot, pool))
+}
+
+// ReadDatasetIOStats reads the current IOStats of an open dataset, whose
+// objset ID is reported by its 'objsetid' property, from the kernel statistics
+// exported by ZFS on Linux.
+func ReadDatasetIOStats(dataset string, objsetID uint64) (*IOStats, error) {
+	pool := strings.SplitN(dataset, "/", 2)[0]
+	return readDatasetIOStats(filepath.Join(kstatRoot, pool), dataset, objsetID)
+}
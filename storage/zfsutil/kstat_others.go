@@ -0,0 +1,23 @@
+// +build !linux
+
+package zfsutil
+
+// ReadARCStats always returns an error on non-Linux operating systems.
+func ReadARCStats() (*ARCStats, error) {
+	return nil, ErrNotImplemented
+}
+
+// ReadPoolIOStats always returns an error on non-Linux operating systems.
+func ReadPoolIOStats(pool string) (*IOStats, error) {
+	return nil, ErrNotImplemented
+}
+
+// ReadObjsetStats always returns an error on non-Linux operating systems.
+func ReadObjsetStats(pool string) (map[string]*IOStats, error) {
+	return nil, ErrNotImplemented
+}
+
+// ReadDatasetIOStats always returns an error on non-Linux operating systems.
+func ReadDatasetIOStats(dataset string, objsetID uint64) (*IOStats, error) {
+	return nil, ErrNotImplemented
+}
@@ -0,0 +1,215 @@
+package zfsutil
+
+import (
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParseARCStats verifies that ARCStats are properly parsed from the
+// arcstats kernel statistic.
+func TestParseARCStats(t *testing.T) {
+	var s *ARCStats
+	err := readKstat(filepath.Join("testdata", "kstat", "arcstats"), func(r io.Reader) error {
+		var err error
+		s, err = ParseARCStats(r)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := &ARCStats{
+		Hits:       1287548,
+		Misses:     143061,
+		Size:       1966308352,
+		TargetSize: 2147483648,
+		MaxSize:    4294967296,
+	}
+	if !reflect.DeepEqual(want, s) {
+		t.Fatalf("unexpected ARC stats:\n- want: %+v\n-  got: %+v", want, s)
+	}
+
+	if want, got := 0.9, s.HitRatio(); math.Abs(want-got) > 0.001 {
+		t.Fatalf("unexpected ARC hit ratio: %v != %v", want, got)
+	}
+	if want, got := 0.0, (&ARCStats{}).HitRatio(); want != got {
+		t.Fatalf("unexpected ARC hit ratio with no reads: %v != %v", want, got)
+	}
+}
+
+// TestParsePoolIOStats verifies that IOStats are properly parsed from a
+// pool's io kernel statistic.
+func TestParsePoolIOStats(t *testing.T) {
+	var s *IOStats
+	err := readKstat(filepath.Join("testdata", "kstat", "zstore", "io"), func(r io.Reader) error {
+		var err error
+		s, err = ParsePoolIOStats(r)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := &IOStats{
+		Reads:      43681,
+		Writes:     91207,
+		ReadBytes:  1363050496,
+		WriteBytes: 2873954304,
+	}
+	if !reflect.DeepEqual(want, s) {
+		t.Fatalf("unexpected pool I/O stats:\n- want: %+v\n-  got: %+v", want, s)
+	}
+}
+
+// TestReadObjsetStats verifies that the IOStats of each dataset in a pool are
+// properly read from its objset kernel statistics.
+func TestReadObjsetStats(t *testing.T) {
+	stats, err := readObjsetStats(filepath.Join("testdata", "kstat", "zstore"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := map[string]*IOStats{
+		"zstore": {},
+		"zstore/192.168.1.1/foo": {
+			Reads:      2215,
+			Writes:     9120,
+			ReadBytes:  145162240,
+			WriteBytes: 597688320,
+		},
+		"zstore/192.168.1.1/bar": {
+			Writes:     12,
+			WriteBytes: 49152,
+		},
+	}
+	if !reflect.DeepEqual(want, stats) {
+		t.Fatalf("unexpected objset stats:\n- want: %+v\n-  got: %+v", want, stats)
+	}
+
+	// Pools which do not export statistics are reported as unavailable
+	_, err = readObjsetStats(filepath.Join("testdata", "kstat", "notexist"))
+	if !IsStatsUnavailable(err) {
+		t.Fatalf("unexpected error for missing pool: %v", err)
+	}
+}
+
+// TestReadDatasetIOStats verifies that the IOStats of a single dataset are
+// properly read from the objset kernel statistic with its objset ID.
+func TestReadDatasetIOStats(t *testing.T) {
+	dir := filepath.Join("testdata", "kstat", "zstore")
+
+	s, err := readDatasetIOStats(dir, "zstore/192.168.1.1/foo", 0x85)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := &IOStats{
+		Reads:      2215,
+		Writes:     9120,
+		ReadBytes:  145162240,
+		WriteBytes: 597688320,
+	}
+	if !reflect.DeepEqual(want, s) {
+		t.Fatalf("unexpected dataset I/O stats:\n- want: %+v\n-  got: %+v", want, s)
+	}
+
+	// Statistics for another dataset are rejected
+	if _, err := readDatasetIOStats(dir, "zstore/192.168.1.1/bar", 0x85); err != ErrInvalidKstat {
+		t.Fatalf("unexpected error for another dataset: %v != %v", err, ErrInvalidKstat)
+	}
+
+	// Datasets which are not open have no statistics
+	if _, err := readDatasetIOStats(dir, "zstore/192.168.1.1/baz", 0x200); !IsStatsUnavailable(err) {
+		t.Fatalf("unexpected error for closed dataset: %v", err)
+	}
+}
+
+// TestParseKstatInvalid verifies that malformed kernel statistics return
+// ErrInvalidKstat.
+func TestParseKstatInvalid(t *testing.T) {
+	const header = "13 1 0x01 96 26112 7236523522 3412359624318\n"
+
+	var tests = []struct {
+		description string
+		text        string
+		parse       func(io.Reader) error
+	}{
+		{
+			description: "arcstats, empty",
+			parse:       parseARCStats,
+		},
+		{
+			description: "arcstats, unknown columns",
+			text:        header + "name value\nhits 4 1\n",
+			parse:       parseARCStats,
+		},
+		{
+			description: "arcstats, missing value",
+			text:        header + "name type data\nhits 4 1\n",
+			parse:       parseARCStats,
+		},
+		{
+			description: "arcstats, invalid value",
+			text:        header + "name type data\nhits 4 1\nmisses 4 1\nsize 4 foo\nc 4 1\nc_max 4 1\n",
+			parse:       parseARCStats,
+		},
+		{
+			description: "io, missing values",
+			text:        header + "nread nwritten reads writes\n1 2 3\n",
+			parse: func(r io.Reader) error {
+				_, err := ParsePoolIOStats(r)
+				return err
+			},
+		},
+		{
+			description: "objset, missing dataset name",
+			text:        header + "name type data\nwrites 4 0\nnwritten 4 0\nreads 4 0\nnread 4 0\n",
+			parse: func(r io.Reader) error {
+				_, _, err := ParseObjsetStats(r)
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		if err := tt.parse(strings.NewReader(tt.text)); err != ErrInvalidKstat {
+			t.Fatalf("unexpected error: %v != %v [description: %s]",
+				ErrInvalidKstat, err, tt.description)
+		}
+	}
+}
+
+// parseARCStats parses ARCStats, discarding them.
+func parseARCStats(r io.Reader) error {
+	_, err := ParseARCStats(r)
+	return err
+}
+
+// TestIsStatsUnavailable verifies that errors which indicate that kernel
+// statistics are not exported are properly detected.
+func TestIsStatsUnavailable(t *testing.T) {
+	_, notExist := os.Open(filepath.Join("testdata", "notexist"))
+
+	var tests = []struct {
+		description string
+		err         error
+		ok          bool
+	}{
+		{description: "not implemented", err: ErrNotImplemented, ok: true},
+		{description: "not exist", err: notExist, ok: true},
+		{description: "invalid kstat", err: ErrInvalidKstat},
+		{description: "nil"},
+	}
+
+	for _, tt := range tests {
+		if ok := IsStatsUnavailable(tt.err); ok != tt.ok {
+			t.Fatalf("unexpected result: %v != %v [description: %s]",
+				ok, tt.ok, tt.description)
+		}
+	}
+}
@@ -0,0 +1,489 @@
+package zfsutil
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidStatus is returned when the output of 'zpool status' cannot
+	// be parsed.
+	ErrInvalidStatus = errors.New("invalid zpool status output")
+)
+
+// States of a scrub or resilver reported by 'zpool status'.  ScanUnknown is
+// the state of a scan whose description is not recognized.
+const (
+	ScanInProgress = "in progress"
+	ScanPaused     = "paused"
+	ScanFinished   = "finished"
+	ScanCanceled   = "canceled"
+	ScanUnknown    = "unknown"
+)
+
+// scanTimeLayout is the layout of times in the scan section of 'zpool status'.
+const scanTimeLayout = "Mon Jan _2 15:04:05 2006"
+
+var (
+	// statusKeyRegexp matches the right-aligned keys which begin each section
+	// of 'zpool status' output.  Other lines are indented further.
+	statusKeyRegexp = regexp.MustCompile(`^ {0,5}(pool|state|status|action|see|scan|remove|config|errors):(?: (.*))?$`)
+
+	// Regular expressions for the first line of the scan section.
+	scanProgressRegexp = regexp.MustCompile(`^(scrub|resilver) in progress since (.+)$`)
+	scrubDoneRegexp    = regexp.MustCompile(`^scrub repaired (\S+) in (.+) with (\d+) errors on (.+)$`)
+	resilverDoneRegexp = regexp.MustCompile(`^resilvered (\S+) in (.+) with (\d+) errors on (.+)$`)
+	scanCanceledRegexp = regexp.MustCompile(`^(scrub|resilver) canceled on (.+)$`)
+	scanPausedRegexp   = regexp.MustCompile(`^(scrub|resilver) paused since (.+)$`)
+
+	// scanFunctionRegexp matches the function of a scan which is not
+	// otherwise recognized.
+	scanFunctionRegexp = regexp.MustCompile(`^(scrub|resilver)\b`)
+
+	// Regular expressions for the progress lines of the scan section, in
+	// both the older and newer formats.
+	scanScannedRegexp  = regexp.MustCompile(`(\S+) scanned(?: out of (\S+))?`)
+	scanIssuedRegexp   = regexp.MustCompile(`(\S+) issued`)
+	scanTotalRegexp    = regexp.MustCompile(`(\S+) total`)
+	scanRepairedRegexp = regexp.MustCompile(`(\S+) (?:repaired|resilvered), ([\d.]+)% done`)
+
+	// dataErrorsRegexp matches the number of data errors reported without -v.
+	dataErrorsRegexp = regexp.MustCompile(`^(\d+) data errors`)
+)
+
+// PoolStatus is the status of a zpool, as reported by 'zpool status'.
+//
+// State is the health of the pool, and Status, Action, and See describe any
+// problem with the pool and how to resolve it.  Scan is the most recent scrub
+// or resilver, or nil if none was requested.
+//
+// Vdevs are the top-level entries of the pool's configuration: the root vdev,
+// which is named after the pool, followed by any sections such as "logs",
+// "cache", and "spares", whose devices are their children.
+//
+// DataErrors is the number of data errors in the pool.  With 'zpool status
+// -v', Errors lists the files and objects which have permanent errors.
+type PoolStatus struct {
+	Name   string
+	State  string
+	Status string
+	Action string
+	See    string
+	Scan   *Scan
+	Vdevs  []*Vdev
+
+	DataErrors int
+	Errors     []string
+}
+
+// A Vdev is a virtual device in a zpool's configuration, such as a mirror or
+// a disk.  Message is any text which follows the error counts, such as
+// "(resilvering)" or "was /dev/sdb1".  Sections of the configuration, such as
+// "logs", have no State.
+type Vdev struct {
+	Name     string
+	State    string
+	Read     uint64
+	Write    uint64
+	Checksum uint64
+	Message  string
+	Children []*Vdev
+}
+
+// Scan is the progress or result of a scrub or resilver.  Function is "scrub"
+// or "resilver", and State is one of the Scan* states.  Time is when an
+// in-progress scan started, when a paused scan was paused, or when a finished
+// or canceled scan ended.  Text is the description of the scan reported by
+// 'zpool status', which is the only information about a scan whose State is
+// ScanUnknown, other than its Function, if it is recognized.
+//
+// Sizes are in bytes, and are only as precise as 'zpool status' reports
+// them.  Scanned, Issued, Total, and Percent are only reported while a scan is
+// in progress or paused, and Issued only by newer versions of ZFS.  Repaired
+// is the number of bytes repaired or resilvered, and Errors is the number of
+// errors found by a finished scan.
+type Scan struct {
+	Function string
+	State    string
+	Time     time.Time
+	Text     string
+
+	Scanned  uint64
+	Issued   uint64
+	Total    uint64
+	Repaired uint64
+	Percent  float64
+	Errors   uint64
+}
+
+// Status retrieves the status of a zpool, including the files and objects
+// which have permanent errors, and full paths to its devices.
+func Status(name string) (*PoolStatus, error) {
+	out, err := run("zpool", "status", "-v", "-P", name)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses, err := ParseStatus(strings.NewReader(out))
+	if err != nil {
+		return nil, err
+	}
+	if len(statuses) != 1 {
+		return nil, ErrInvalidStatus
+	}
+
+	return statuses[0], nil
+}
+
+// ParseStatus parses the output of 'zpool status', with or without -v and -P,
+// which may contain the status of several zpools.  ErrInvalidStatus is
+// returned if the output is malformed.
+func ParseStatus(r io.Reader) ([]*PoolStatus, error) {
+	var (
+		statuses []*PoolStatus
+		s        *PoolStatus
+		key      string
+
+		scan   []string
+		config *configParser
+	)
+
+	// finish parses the sections of the current pool which span several lines
+	finish := func() {
+		if s == nil {
+			return
+		}
+
+		s.Scan = parseScan(scan)
+		if config != nil {
+			s.Vdevs = config.vdevs
+		}
+
+		scan, config = nil, nil
+	}
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+
+		if m := statusKeyRegexp.FindStringSubmatch(line); m != nil {
+			key = m[1]
+			value := strings.TrimSpace(m[2])
+
+			if key == "pool" {
+				finish()
+
+				s = &PoolStatus{Name: value}
+				statuses = append(statuses, s)
+				continue
+			}
+
+			// All other sections belong to a pool
+			if s == nil {
+				return nil, ErrInvalidStatus
+			}
+
+			switch key {
+			case "state":
+				s.State = value
+			case "status":
+				s.Status = value
+			case "action":
+				s.Action = value
+			case "see":
+				s.See = value
+			case "scan":
+				scan = []string{value}
+			case "config":
+				config = new(configParser)
+			case "errors":
+				if err := parseErrors(s, value); err != nil {
+					return nil, err
+				}
+			}
+
+			continue
+		}
+
+		text := strings.TrimSpace(line)
+		if text == "" || s == nil {
+			continue
+		}
+
+		// Continue the current section
+		switch key {
+		case "status":
+			s.Status += " " + text
+		case "action":
+			s.Action += " " + text
+		case "scan":
+			scan = append(scan, text)
+		case "config":
+			if err := config.parse(line); err != nil {
+				return nil, err
+			}
+		case "errors":
+			s.Errors = append(s.Errors, text)
+			s.DataErrors = len(s.Errors)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	finish()
+
+	return statuses, nil
+}
+
+// parseErrors parses the first line of the errors section of a pool's status.
+// Files and objects with permanent errors are listed on the following lines.
+func parseErrors(s *PoolStatus, value string) error {
+	switch {
+	case value == "No known data errors":
+		return nil
+	case strings.HasPrefix(value, "Permanent errors have been detected"):
+		return nil
+	}
+
+	m := dataErrorsRegexp.FindStringSubmatch(value)
+	if m == nil {
+		return ErrInvalidStatus
+	}
+
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		return ErrInvalidStatus
+	}
+
+	s.DataErrors = n
+	return nil
+}
+
+// configParser parses the vdev tree in the config section of a pool's status.
+type configParser struct {
+	// indent is the indentation of the NAME column, and stack holds the
+	// most recent vdev at each depth
+	indent int
+	header bool
+	stack  []*Vdev
+	vdevs  []*Vdev
+}
+
+// parse parses a single line of the config section.  Each level of the vdev
+// tree is indented by two spaces.
+func (p *configParser) parse(line string) error {
+	fields := strings.Fields(line)
+	indent := len(line) - len(strings.TrimLeft(line, " \t"))
+
+	if !p.header {
+		if len(fields) == 0 || fields[0] != "NAME" {
+			return ErrInvalidStatus
+		}
+
+		p.header = true
+		p.indent = indent
+		return nil
+	}
+
+	depth := (indent - p.indent) / 2
+	if indent < p.indent || depth > len(p.stack) {
+		return ErrInvalidStatus
+	}
+
+	v := &Vdev{
+		Name: fields[0],
+	}
+	if len(fields) > 1 {
+		v.State = fields[1]
+	}
+
+	// Spares report only a state, and sections report no state at all
+	if len(fields) >= 5 {
+		for i, c := range []*uint64{&v.Read, &v.Write, &v.Checksum} {
+			n, err := parseNumber(fields[2+i])
+			if err != nil {
+				return err
+			}
+
+			*c = n
+		}
+
+		v.Message = strings.Join(fields[5:], " ")
+	}
+
+	p.stack = append(p.stack[:depth], v)
+	if depth == 0 {
+		p.vdevs = append(p.vdevs, v)
+		return nil
+	}
+
+	parent := p.stack[depth-1]
+	parent.Children = append(parent.Children, v)
+	return nil
+}
+
+// parseScan parses the lines of the scan section of a pool's status.  If no
+// scan was requested, nil is returned.  Descriptions of scans which are not
+// recognized, such as those added by newer versions of ZFS, produce a Scan
+// with the state ScanUnknown, so that the rest of the status can be parsed.
+func parseScan(lines []string) *Scan {
+	if len(lines) == 0 || lines[0] == "none requested" {
+		return nil
+	}
+
+	s, err := parseKnownScan(lines)
+	if err != nil {
+		s = &Scan{
+			State: ScanUnknown,
+		}
+		if m := scanFunctionRegexp.FindStringSubmatch(lines[0]); m != nil {
+			s.Function = m[1]
+		}
+	}
+
+	s.Text = strings.Join(lines, "\n")
+	return s
+}
+
+// parseKnownScan parses the lines of the scan section of a pool's status.
+// ErrInvalidStatus is returned if the scan is not recognized.
+func parseKnownScan(lines []string) (*Scan, error) {
+	s := new(Scan)
+	var (
+		when string
+		err  error
+	)
+
+	first := lines[0]
+	if m := scanProgressRegexp.FindStringSubmatch(first); m != nil {
+		s.Function = m[1]
+		s.State = ScanInProgress
+		when = m[2]
+
+		if err := parseScanProgress(s, strings.Join(lines[1:], " ")); err != nil {
+			return nil, err
+		}
+	} else if m := scanPausedRegexp.FindStringSubmatch(first); m != nil {
+		s.Function = m[1]
+		s.State = ScanPaused
+		when = m[2]
+
+		if err := parseScanProgress(s, strings.Join(lines[1:], " ")); err != nil {
+			return nil, err
+		}
+	} else if m := scrubDoneRegexp.FindStringSubmatch(first); m != nil {
+		s.Function = "scrub"
+		s.State = ScanFinished
+		when = m[4]
+
+		if s.Repaired, err = parseNumber(m[1]); err != nil {
+			return nil, err
+		}
+		if s.Errors, err = strconv.ParseUint(m[3], 10, 64); err != nil {
+			return nil, ErrInvalidStatus
+		}
+	} else if m := resilverDoneRegexp.FindStringSubmatch(first); m != nil {
+		s.Function = "resilver"
+		s.State = ScanFinished
+		when = m[4]
+
+		if s.Repaired, err = parseNumber(m[1]); err != nil {
+			return nil, err
+		}
+		if s.Errors, err = strconv.ParseUint(m[3], 10, 64); err != nil {
+			return nil, ErrInvalidStatus
+		}
+	} else if m := scanCanceledRegexp.FindStringSubmatch(first); m != nil {
+		s.Function = m[1]
+		s.State = ScanCanceled
+		when = m[2]
+	} else {
+		return nil, ErrInvalidStatus
+	}
+
+	// Times are reported in the local time zone
+	s.Time, err = time.ParseInLocation(scanTimeLayout, when, time.Local)
+	if err != nil {
+		return nil, ErrInvalidStatus
+	}
+
+	return s, nil
+}
+
+// parseScanProgress parses the progress lines of an in-progress or paused
+// scan.
+func parseScanProgress(s *Scan, text string) error {
+	var err error
+	if m := scanScannedRegexp.FindStringSubmatch(text); m != nil {
+		if s.Scanned, err = parseNumber(m[1]); err != nil {
+			return err
+		}
+
+		// Older versions report the total alongside the scanned bytes
+		if m[2] != "" {
+			if s.Total, err = parseNumber(m[2]); err != nil {
+				return err
+			}
+		}
+	}
+	if m := scanIssuedRegexp.FindStringSubmatch(text); m != nil {
+		if s.Issued, err = parseNumber(m[1]); err != nil {
+			return err
+		}
+	}
+	if m := scanTotalRegexp.FindStringSubmatch(text); m != nil {
+		if s.Total, err = parseNumber(m[1]); err != nil {
+			return err
+		}
+	}
+	if m := scanRepairedRegexp.FindStringSubmatch(text); m != nil {
+		if s.Repaired, err = parseNumber(m[1]); err != nil {
+			return err
+		}
+		if s.Percent, err = strconv.ParseFloat(m[2], 64); err != nil {
+			return ErrInvalidStatus
+		}
+	}
+
+	return nil
+}
+
+// numberUnits are the powers of 1024 represented by the unit suffixes which
+// ZFS uses to abbreviate large numbers, such as 1.50G or 1.2K.
+var numberUnits = map[byte]uint{
+	'K': 1,
+	'M': 2,
+	'G': 3,
+	'T': 4,
+	'P': 5,
+	'E': 6,
+}
+
+// parseNumber parses a number which may be abbreviated by ZFS, such as an
+// error count or a size in bytes.  Abbreviated numbers are only as precise as
+// their abbreviation.
+func parseNumber(s string) (uint64, error) {
+	s = strings.TrimSuffix(s, "B")
+	if s == "" {
+		return 0, ErrInvalidStatus
+	}
+
+	var exp uint
+	if e, ok := numberUnits[s[len(s)-1]]; ok {
+		exp = e
+		s = s[:len(s)-1]
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0, ErrInvalidStatus
+	}
+
+	return uint64(f * float64(uint64(1)<<(10*exp))), nil
+}
@@ -0,0 +1,337 @@
+package zfsutil
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseStatus verifies that the output of 'zpool status', captured from
+// pools in various states, is properly parsed.
+func TestParseStatus(t *testing.T) {
+	var tests = []struct {
+		description string
+		file        string
+		statuses    []*PoolStatus
+	}{
+		{
+			description: "online mirror, finished scrub",
+			file:        "status_online.txt",
+			statuses: []*PoolStatus{{
+				Name:  "zstore",
+				State: "ONLINE",
+				Scan: &Scan{
+					Function: "scrub",
+					State:    ScanFinished,
+					Time:     time.Date(2016, time.January, 10, 0, 24, 1, 0, time.Local),
+					Text:     "scrub repaired 0B in 0h2m with 0 errors on Sun Jan 10 00:24:01 2016",
+				},
+				Vdevs: []*Vdev{{
+					Name:  "zstore",
+					State: "ONLINE",
+					Children: []*Vdev{{
+						Name:  "mirror-0",
+						State: "ONLINE",
+						Children: []*Vdev{
+							{Name: "sda", State: "ONLINE"},
+							{Name: "sdb", State: "ONLINE"},
+						},
+					}},
+				}},
+			}},
+		},
+		{
+			description: "degraded raidz with full paths, resilver in progress, permanent errors",
+			file:        "status_degraded.txt",
+			statuses: []*PoolStatus{{
+				Name:   "zstore",
+				State:  "DEGRADED",
+				Status: "One or more devices is currently being resilvered.  The pool will continue to function, possibly in a degraded state.",
+				Action: "Wait for the resilver to complete.",
+				Scan: &Scan{
+					Function: "resilver",
+					State:    ScanInProgress,
+					Time:     time.Date(2021, time.July, 25, 16, 7, 49, 0, time.Local),
+					Text: "resilver in progress since Sun Jul 25 16:07:49 2021\n" +
+						"2.13T scanned at 1.38G/s, 1.02T issued at 677M/s, 5.48T total\n" +
+						"256G resilvered, 18.59% done, 01:55:47 to go",
+					Scanned:  abbreviated(2.13, 1<<40),
+					Issued:   abbreviated(1.02, 1<<40),
+					Total:    abbreviated(5.48, 1<<40),
+					Repaired: 256 << 30,
+					Percent:  18.59,
+				},
+				Vdevs: []*Vdev{
+					{
+						Name:  "zstore",
+						State: "DEGRADED",
+						Children: []*Vdev{{
+							Name:  "raidz1-0",
+							State: "DEGRADED",
+							Children: []*Vdev{
+								{Name: "/dev/disk/by-id/ata-a", State: "ONLINE"},
+								{Name: "/dev/disk/by-id/ata-b", State: "ONLINE", Checksum: 3},
+								{
+									Name:  "replacing-2",
+									State: "DEGRADED",
+									Children: []*Vdev{
+										{
+											Name:    "12345678901234567890",
+											State:   "UNAVAIL",
+											Message: "was /dev/disk/by-id/ata-c",
+										},
+										{
+											Name:    "/dev/disk/by-id/ata-d",
+											State:   "ONLINE",
+											Message: "(resilvering)",
+										},
+									},
+								},
+							},
+						}},
+					},
+					{
+						Name: "logs",
+						Children: []*Vdev{
+							{Name: "/dev/disk/by-id/nvme-e", State: "ONLINE"},
+						},
+					},
+					{
+						Name: "cache",
+						Children: []*Vdev{{
+							Name:    "/dev/disk/by-id/nvme-f",
+							State:   "FAULTED",
+							Read:    abbreviated(1.2, 1<<10),
+							Write:   24,
+							Message: "too many errors",
+						}},
+					},
+					{
+						Name: "spares",
+						Children: []*Vdev{
+							{Name: "/dev/disk/by-id/ata-g", State: "AVAIL"},
+						},
+					},
+				},
+				DataErrors: 3,
+				Errors: []string{
+					"zstore/192.168.1.1/foo:<0x1>",
+					"<metadata>:<0x3c>",
+					"/zstore/data/file.bin",
+				},
+			}},
+		},
+		{
+			description: "multiple pools, older scrub in progress, no scan requested",
+			file:        "status_multiple.txt",
+			statuses: []*PoolStatus{
+				{
+					Name:  "backup",
+					State: "ONLINE",
+					Scan: &Scan{
+						Function: "scrub",
+						State:    ScanInProgress,
+						Time:     time.Date(2016, time.February, 1, 2, 0, 0, 0, time.Local),
+						Text: "scrub in progress since Mon Feb  1 02:00:00 2016\n" +
+							"1.23G scanned out of 10.0G at 100M/s, 0h1m to go\n" +
+							"0B repaired, 12.30% done",
+						Scanned:  abbreviated(1.23, 1<<30),
+						Total:    10 << 30,
+						Percent:  12.3,
+					},
+					Vdevs: []*Vdev{{
+						Name:  "backup",
+						State: "ONLINE",
+						Children: []*Vdev{
+							{Name: "sdc", State: "ONLINE"},
+						},
+					}},
+					DataErrors: 2,
+				},
+				{
+					Name:  "scratch",
+					State: "ONLINE",
+					Vdevs: []*Vdev{{
+						Name:  "scratch",
+						State: "ONLINE",
+						Children: []*Vdev{
+							{Name: "sdd", State: "ONLINE"},
+						},
+					}},
+				},
+			},
+		},
+		{
+			description: "paused scrub",
+			file:        "status_paused.txt",
+			statuses: []*PoolStatus{{
+				Name:  "zstore",
+				State: "ONLINE",
+				Scan: &Scan{
+					Function: "scrub",
+					State:    ScanPaused,
+					Time:     time.Date(2023, time.June, 12, 10, 0, 0, 0, time.Local),
+					Text: "scrub paused since Mon Jun 12 10:00:00 2023\n" +
+						"scrub started on Mon Jun 12 09:00:00 2023\n" +
+						"1.23G scanned, 512M issued, 10.0G total\n" +
+						"0B repaired, 5.00% done",
+					Scanned: abbreviated(1.23, 1<<30),
+					Issued:  512 << 20,
+					Total:   10 << 30,
+					Percent: 5,
+				},
+				Vdevs: []*Vdev{{
+					Name:  "zstore",
+					State: "ONLINE",
+					Children: []*Vdev{
+						{Name: "sda", State: "ONLINE"},
+					},
+				}},
+			}},
+		},
+		{
+			description: "unrecognized scan, vdev tree still parsed",
+			file:        "status_unknown_scan.txt",
+			statuses: []*PoolStatus{{
+				Name:   "zstore",
+				State:  "DEGRADED",
+				Status: "One or more devices is currently being resilvered.  The pool will continue to function, possibly in a degraded state.",
+				Action: "Wait for the resilver to complete.",
+				Scan: &Scan{
+					Function: "resilver",
+					State:    ScanUnknown,
+					Text: "resilver (draid1:1d:4c:0s-0) in progress since Tue Mar  5 08:15:02 2024\n" +
+						"12.4G scanned at 1.03G/s, 12.4G issued 1.03G/s, 48.0G total\n" +
+						"3.10G resilvered, 25.83% done, 00:00:34 to go",
+				},
+				Vdevs: []*Vdev{{
+					Name:  "zstore",
+					State: "DEGRADED",
+					Children: []*Vdev{{
+						Name:  "draid1:1d:4c:0s-0",
+						State: "DEGRADED",
+						Children: []*Vdev{
+							{Name: "sda", State: "ONLINE"},
+							{Name: "sdb", State: "ONLINE"},
+							{Name: "sdc", State: "UNAVAIL"},
+							{Name: "sdd", State: "ONLINE"},
+						},
+					}},
+				}},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		f, err := os.Open(filepath.Join("testdata", tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		statuses, err := ParseStatus(f)
+		_ = f.Close()
+		if err != nil {
+			t.Fatalf("unexpected error: %v [description: %s]", err, tt.description)
+		}
+
+		if want, got := len(tt.statuses), len(statuses); want != got {
+			t.Fatalf("unexpected number of pools: %v != %v [description: %s]",
+				want, got, tt.description)
+		}
+
+		for i := range statuses {
+			if want, got := tt.statuses[i], statuses[i]; !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected pool status:\n- want: %+v\n-  got: %+v [description: %s]",
+					want, got, tt.description)
+			}
+		}
+	}
+}
+
+// TestParseScanUnknown verifies that scans which are not recognized are
+// reported with an unknown state, rather than failing to parse.
+func TestParseScanUnknown(t *testing.T) {
+	var tests = []struct {
+		description string
+		text        string
+		function    string
+	}{
+		{
+			description: "unknown function",
+			text:        "error scrub in progress since Tue Mar  5 08:15:02 2024",
+		},
+		{
+			description: "invalid time",
+			text:        "scrub canceled on yesterday",
+			function:    "scrub",
+		},
+		{
+			description: "invalid progress",
+			text:        "resilver in progress since Tue Mar  5 08:15:02 2024\n\tmany scanned",
+			function:    "resilver",
+		},
+	}
+
+	for _, tt := range tests {
+		statuses, err := ParseStatus(strings.NewReader("  pool: zstore\n  scan: " + tt.text + "\n"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v [description: %s]", err, tt.description)
+		}
+
+		want := &Scan{
+			Function: tt.function,
+			State:    ScanUnknown,
+			Text:     strings.Replace(tt.text, "\t", "", -1),
+		}
+		if got := statuses[0].Scan; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected scan:\n- want: %+v\n-  got: %+v [description: %s]",
+				want, got, tt.description)
+		}
+	}
+}
+
+// TestParseStatusInvalid verifies that malformed 'zpool status' output
+// returns ErrInvalidStatus.
+func TestParseStatusInvalid(t *testing.T) {
+	var tests = []struct {
+		description string
+		text        string
+	}{
+		{
+			description: "section before pool",
+			text:        " state: ONLINE\n",
+		},
+		{
+			description: "missing config header",
+			text:        "  pool: zstore\nconfig:\n\n\tzstore ONLINE 0 0 0\n",
+		},
+		{
+			description: "vdev indented too far",
+			text:        "  pool: zstore\nconfig:\n\n\tNAME STATE READ WRITE CKSUM\n\t    sda ONLINE 0 0 0\n",
+		},
+		{
+			description: "invalid error count",
+			text:        "  pool: zstore\nconfig:\n\n\tNAME STATE READ WRITE CKSUM\n\tzstore ONLINE 0 foo 0\n",
+		},
+		{
+			description: "unknown errors",
+			text:        "  pool: zstore\nerrors: many\n",
+		},
+	}
+
+	for _, tt := range tests {
+		if _, err := ParseStatus(strings.NewReader(tt.text)); err != ErrInvalidStatus {
+			t.Fatalf("unexpected error: %v != %v [description: %s]",
+				ErrInvalidStatus, err, tt.description)
+		}
+	}
+}
+
+// abbreviated returns the number represented by a ZFS abbreviation of value
+// in the specified unit, such as 1.5 in units of 1<<30 for 1.5G.
+func abbreviated(value float64, unit uint64) uint64 {
+	return uint64(value * float64(unit))
+}
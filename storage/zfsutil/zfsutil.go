@@ -2,9 +2,13 @@
 package zfsutil
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"os/exec"
+	"strconv"
 	"strings"
+	"time"
 
 	"gopkg.in/mistifyio/go-zfs.v2"
 )
@@ -65,6 +69,49 @@ func IsDatasetNotExists(err error) bool {
 	return strings.Contains(zErr.Stderr, "dataset does not exist\n")
 }
 
+// IsDatasetExists determines if an input error is caused by an attempt to
+// create a ZFS dataset which already exists.
+func IsDatasetExists(err error) bool {
+	// Check for ZFS error
+	zErr, ok := err.(*zfs.Error)
+	if !ok {
+		// Not a ZFS error at all
+		return false
+	}
+
+	// Check for tail end of error string
+	return strings.Contains(zErr.Stderr, "dataset already exists\n")
+}
+
+// IsNewerSnapshotsExist determines if an input error is caused by an attempt
+// to roll back a ZFS dataset to a snapshot which is not its most recent.
+func IsNewerSnapshotsExist(err error) bool {
+	// Check for ZFS error
+	zErr, ok := err.(*zfs.Error)
+	if !ok {
+		// Not a ZFS error at all
+		return false
+	}
+
+	// Check for middle of error string, which may also mention bookmarks
+	return strings.Contains(zErr.Stderr, "more recent snapshots")
+}
+
+// IsDependentClones determines if an input error is caused by an attempt to
+// destroy a ZFS snapshot which other datasets were cloned from.
+func IsDependentClones(err error) bool {
+	// Check for ZFS error
+	zErr, ok := err.(*zfs.Error)
+	if !ok {
+		// Not a ZFS error at all
+		return false
+	}
+
+	// Check for middle of error string, which may refer to a snapshot or
+	// its parent dataset
+	return strings.Contains(zErr.Stderr, "has dependent clones")
+}
+
 // IsOutOfSpace determines if an input error is caused by the zpool being too
 // full to process a volume creation request.
 func IsOutOfSpace(err error) bool {
@@ -79,7 +126,202 @@ func IsOutOfSpace(err error) bool {
 	return strings.Contains(zErr.Stderr, "out of space\n")
 }
 
+// Classes of ZFS errors returned by ErrorClass.
+const (
+	ErrorClassPermissionDenied    = "permission_denied"
+	ErrorClassZpoolNotExists      = "zpool_not_exists"
+	ErrorClassDatasetNotExists    = "dataset_not_exists"
+	ErrorClassDatasetExists       = "dataset_exists"
+	ErrorClassNewerSnapshotsExist = "newer_snapshots_exist"
+	ErrorClassDependentClones     = "dependent_clones"
+	ErrorClassOutOfSpace          = "out_of_space"
+	ErrorClassOther               = "other"
+)
+
+// ErrorClass classifies an error returned by a ZFS command, using the error
+// checking functions in this package, such as for use as a metrics label.
+// If err is nil, an empty string is returned.  If err matches none of the
+// error checking functions, ErrorClassOther is returned.
+func ErrorClass(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case IsZFSPermissionDenied(err):
+		return ErrorClassPermissionDenied
+	case IsZpoolNotExists(err):
+		return ErrorClassZpoolNotExists
+	case IsDatasetNotExists(err):
+		return ErrorClassDatasetNotExists
+	case IsDatasetExists(err):
+		return ErrorClassDatasetExists
+	case IsNewerSnapshotsExist(err):
+		return ErrorClassNewerSnapshotsExist
+	case IsDependentClones(err):
+		return ErrorClassDependentClones
+	case IsOutOfSpace(err):
+		return ErrorClassOutOfSpace
+	default:
+		return ErrorClassOther
+	}
+}
+
 // Zpool returns the designated zpool for zstored operations.
 func Zpool() (*zfs.Zpool, error) {
 	return zfs.GetZpool(ZpoolName)
 }
+
+// Property retrieves the parseable value of a single property from a ZFS
+// dataset.  go-zfs only parses a fixed set of dataset properties, so this can
+// be used to retrieve any others.
+func Property(name string, property string) (string, error) {
+	out, err := run("zfs", "get", "-Hp", "-o", "value", property, name)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(out), nil
+}
+
+// UserProperties retrieves all ZFS user properties which are set locally on
+// a dataset and begin with the specified prefix.  The prefix is removed from
+// property names in the returned map.
+func UserProperties(name string, prefix string) (map[string]string, error) {
+	out, err := run("zfs", "get", "-Hp", "-s", "local", "-o", "property,value", "all", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseProperties(out, prefix), nil
+}
+
+// Properties retrieves several ZFS properties of a dataset at once, in their
+// exact, parseable form.
+func Properties(name string, properties ...string) (map[string]string, error) {
+	out, err := run("zfs", "get", "-Hp", "-o", "property,value", strings.Join(properties, ","), name)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseProperties(out, ""), nil
+}
+
+// PoolProperties retrieves several properties of a zpool at once, in their
+// exact, parseable form.
+func PoolProperties(name string, properties ...string) (map[string]string, error) {
+	out, err := run("zpool", "get", "-Hp", "-o", "property,value", strings.Join(properties, ","), name)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseProperties(out, ""), nil
+}
+
+// SetProperty sets a ZFS property on a dataset.
+func SetProperty(name string, property string, value string) error {
+	_, err := run("zfs", "set", property+"="+value, name)
+	return err
+}
+
+// InheritProperty clears a ZFS property which is set locally on a dataset, so
+// that its value is inherited.  For user properties, this removes the property.
+func InheritProperty(name string, property string) error {
+	_, err := run("zfs", "inherit", property, name)
+	return err
+}
+
+// A Snapshot is a ZFS snapshot of a dataset, as listed by Snapshots.
+type Snapshot struct {
+	// Name is the full name of the snapshot, including its dataset name.
+	Name string
+
+	// Created is the time at which the snapshot was taken.
+	Created time.Time
+
+	// Used is the number of bytes consumed by the snapshot.
+	Used uint64
+}
+
+// Snapshots retrieves all ZFS snapshots which belong directly to a dataset,
+// along with their creation times and used space, using a single command.
+// go-zfs does not parse creation times, so this avoids retrieving them
+// separately for each snapshot.
+func Snapshots(name string) ([]*Snapshot, error) {
+	out, err := run("zfs", "list", "-Hp", "-t", "snapshot", "-o", "name,creation,used", "-d", "1", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseSnapshots(out)
+}
+
+// parseSnapshots parses the output of 'zfs list -Hp -o name,creation,used'.
+func parseSnapshots(out string) ([]*Snapshot, error) {
+	var snapshots []*Snapshot
+	for _, line := range strings.Split(out, "\n") {
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected snapshot fields: %q", line)
+		}
+
+		// Creation time is returned as a UNIX timestamp
+		unix, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		used, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		snapshots = append(snapshots, &Snapshot{
+			Name:    fields[0],
+			Created: time.Unix(unix, 0),
+			Used:    used,
+		})
+	}
+
+	return snapshots, nil
+}
+
+// parseProperties parses the output of 'zfs get -H -o property,value',
+// returning properties which begin with the specified prefix.
+func parseProperties(out string, prefix string) map[string]string {
+	props := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		// Values may contain whitespace, so only split on the first tab
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 || !strings.HasPrefix(fields[0], prefix) {
+			continue
+		}
+
+		props[strings.TrimPrefix(fields[0], prefix)] = fields[1]
+	}
+
+	return props
+}
+
+// run executes a ZFS or zpool command with the specified arguments, and returns its
+// output.  Errors are returned as *zfs.Error, so they can be checked using the
+// same functions as errors from go-zfs.
+func run(command string, args ...string) (string, error) {
+	cmd := exec.Command(command, args...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", &zfs.Error{
+			Err:    err,
+			Debug:  strings.Join(cmd.Args, " "),
+			Stderr: stderr.String(),
+		}
+	}
+
+	return stdout.String(), nil
+}
@@ -3,7 +3,9 @@ package zfsutil
 import (
 	"errors"
 	"fmt"
+	"reflect"
 	"testing"
+	"time"
 
 	"gopkg.in/mistifyio/go-zfs.v2"
 )
@@ -75,6 +77,66 @@ func TestIsDatasetNotExists(t *testing.T) {
 	}
 }
 
+// TestIsDatasetExists verifies that ZFS dataset already exists errors are
+// properly detected.
+func TestIsDatasetExists(t *testing.T) {
+	// Try all common failure tests, add one successful test
+	tests := append(errTests(), &errorTest{
+		text: "ZFS error, dataset already exists",
+		err: &zfs.Error{
+			Stderr: "cannot create snapshot 'zstore/foo/bar@baz': dataset already exists\n",
+		},
+		ok: true,
+	})
+
+	// Run all tests to check output
+	for _, test := range tests {
+		if ok := IsDatasetExists(test.err); ok != test.ok {
+			t.Fatalf("unexpected result: %v != %v [text: %s]", ok, test.ok, test.text)
+		}
+	}
+}
+
+// TestIsNewerSnapshotsExist verifies that ZFS rollback errors caused by more
+// recent snapshots are properly detected.
+func TestIsNewerSnapshotsExist(t *testing.T) {
+	// Try all common failure tests, add one successful test
+	tests := append(errTests(), &errorTest{
+		text: "ZFS error, more recent snapshots exist",
+		err: &zfs.Error{
+			Stderr: "cannot rollback to 'zstore/foo/bar@baz': more recent snapshots or bookmarks exist\nuse '-r' to force deletion of the following snapshots and bookmarks:\nzstore/foo/bar@qux\n",
+		},
+		ok: true,
+	})
+
+	// Run all tests to check output
+	for _, test := range tests {
+		if ok := IsNewerSnapshotsExist(test.err); ok != test.ok {
+			t.Fatalf("unexpected result: %v != %v [text: %s]", ok, test.ok, test.text)
+		}
+	}
+}
+
+// TestIsDependentClones verifies that ZFS destroy errors caused by dependent
+// clones are properly detected.
+func TestIsDependentClones(t *testing.T) {
+	// Try all common failure tests, add one successful test
+	tests := append(errTests(), &errorTest{
+		text: "ZFS error, snapshot has dependent clones",
+		err: &zfs.Error{
+			Stderr: "cannot destroy 'zstore/foo/bar@baz': snapshot has dependent clones\nuse '-R' to destroy the following datasets:\nzstore/foo/qux\n",
+		},
+		ok: true,
+	})
+
+	// Run all tests to check output
+	for _, test := range tests {
+		if ok := IsDependentClones(test.err); ok != test.ok {
+			t.Fatalf("unexpected result: %v != %v [text: %s]", ok, test.ok, test.text)
+		}
+	}
+}
+
 // TestIsOutOfSpace verifies that ZFS zstore zpool out of space errors are
 // properly detected.
 func TestIsOutOfSpace(t *testing.T) {
@@ -95,6 +157,140 @@ func TestIsOutOfSpace(t *testing.T) {
 	}
 }
 
+// TestErrorClass verifies that ZFS errors are classified using the error
+// checking functions.
+func TestErrorClass(t *testing.T) {
+	var tests = []struct {
+		text  string
+		err   error
+		class string
+	}{
+		{
+			text: "no error",
+		},
+		{
+			text:  "string error",
+			err:   errors.New("foo"),
+			class: ErrorClassOther,
+		},
+		{
+			text: "ZFS error, permission denied",
+			err: &zfs.Error{
+				Stderr: fmt.Sprintf("Unable to open %s: Permission denied.\n", devZFS),
+			},
+			class: ErrorClassPermissionDenied,
+		},
+		{
+			text: "ZFS error, dataset does not exist",
+			err: &zfs.Error{
+				Stderr: "cannot open 'zstore/foo': dataset does not exist\n",
+			},
+			class: ErrorClassDatasetNotExists,
+		},
+		{
+			text: "ZFS error, zpool out of space",
+			err: &zfs.Error{
+				Stderr: "out of space\n",
+			},
+			class: ErrorClassOutOfSpace,
+		},
+		{
+			text: "some ZFS error",
+			err: &zfs.Error{
+				Stderr: "some error",
+			},
+			class: ErrorClassOther,
+		},
+	}
+
+	for _, test := range tests {
+		if class := ErrorClass(test.err); class != test.class {
+			t.Fatalf("unexpected class: %v != %v [text: %s]", class, test.class, test.text)
+		}
+	}
+}
+
+// TestParseProperties verifies that properties with a prefix are parsed from
+// 'zfs get' output.
+func TestParseProperties(t *testing.T) {
+	out := "volsize\t1073741824\n" +
+		"zstore:label:env\tprod\n" +
+		"zstore:label:team\tstorage team\n" +
+		"other:label:env\tdev\n"
+
+	props := parseProperties(out, "zstore:label:")
+	if len(props) != 2 {
+		t.Fatalf("unexpected number of properties: %v != %v", len(props), 2)
+	}
+	if v := props["env"]; v != "prod" {
+		t.Fatalf("unexpected value: %q != %q", v, "prod")
+	}
+	if v := props["team"]; v != "storage team" {
+		t.Fatalf("unexpected value: %q != %q", v, "storage team")
+	}
+
+	// Without a prefix, all properties are returned
+	if props := parseProperties(out, ""); len(props) != 4 || props["volsize"] != "1073741824" {
+		t.Fatalf("unexpected properties: %v", props)
+	}
+}
+
+// TestParseSnapshots verifies that snapshots are parsed from 'zfs list'
+// output, and that malformed output is rejected.
+func TestParseSnapshots(t *testing.T) {
+	var tests = []struct {
+		description string
+		out         string
+		snapshots   []*Snapshot
+		ok          bool
+	}{
+		{
+			description: "no snapshots",
+			ok:          true,
+		},
+		{
+			description: "two snapshots",
+			out: "zstore/foo/bar@one\t1500000000\t0\n" +
+				"zstore/foo/bar@two\t1500000060\t8192\n",
+			snapshots: []*Snapshot{
+				{
+					Name:    "zstore/foo/bar@one",
+					Created: time.Unix(1500000000, 0),
+				},
+				{
+					Name:    "zstore/foo/bar@two",
+					Created: time.Unix(1500000060, 0),
+					Used:    8192,
+				},
+			},
+			ok: true,
+		},
+		{
+			description: "missing field",
+			out:         "zstore/foo/bar@one\t1500000000\n",
+		},
+		{
+			description: "invalid creation",
+			out:         "zstore/foo/bar@one\tyesterday\t0\n",
+		},
+		{
+			description: "invalid used",
+			out:         "zstore/foo/bar@one\t1500000000\t8K\n",
+		},
+	}
+
+	for _, test := range tests {
+		snapshots, err := parseSnapshots(test.out)
+		if ok := err == nil; ok != test.ok {
+			t.Fatalf("unexpected error: %v [description: %s]", err, test.description)
+		}
+
+		if !reflect.DeepEqual(snapshots, test.snapshots) {
+			t.Fatalf("unexpected snapshots: %v != %v [description: %s]", snapshots, test.snapshots, test.description)
+		}
+	}
+}
+
 // errTests returns some common errorTest values which should not register
 // as a specific type of ZFS error.
 func errTests() []*errorTest {
@@ -0,0 +1,339 @@
+// Package zstoreclient provides a client for the zstored storage API.
+package zstoreclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/mdlayher/zstore/storage"
+	"github.com/mdlayher/zstore/zstored/zstoredhttp"
+)
+
+var (
+	// ErrInvalidVolumeName is returned when a volume name cannot be used with
+	// the storage API.  Volume names are relative to the client's bucket,
+	// so they must not be empty or contain '/'.
+	ErrInvalidVolumeName = errors.New("invalid volume name")
+)
+
+const (
+	// storageAPI is the path prefix for the storage provisioning API
+	storageAPI = "/v1/storage/"
+
+	// sizesAPI is the path of the size slug catalog API
+	sizesAPI = "/v1/sizes"
+
+	// discoveryAPI is the path of the discovery API
+	discoveryAPI = "/v1/discovery"
+
+	// poolAPI is the path of the pool status API
+	poolAPI = "/v1/pool"
+)
+
+// Client is a client for the zstored storage API.  Volumes are always named
+// relative to the bucket which zstored assigns to the client, so volume names
+// passed to a Client are plain names, such as "foo".
+type Client struct {
+	// Token, if set, is sent as a bearer token to identify the client's
+	// tenant.
+	Token string
+
+	c *http.Client
+	u *url.URL
+}
+
+// NewClient creates a new Client for the zstored server at the specified
+// endpoint, such as "http://localhost:5000".  If c is nil,
+// http.DefaultClient is used.  A http.Client with a TLS client certificate
+// may be used to identify the client's tenant by certificate.
+func NewClient(endpoint string, c *http.Client) (*Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q: scheme and host are required", endpoint)
+	}
+
+	if c == nil {
+		c = http.DefaultClient
+	}
+
+	return &Client{
+		c: c,
+		u: u,
+	}, nil
+}
+
+// CreateOptions are optional parameters for CreateVolume.  Class is the name
+// of a storage class configured on the server, and Options are volume creation
+// options; see storage.ParseVolumeOptions for the options which are permitted.
+type CreateOptions struct {
+	Class   string
+	Labels  map[string]string
+	Options map[string]string
+}
+
+// CreateVolume creates a new volume with the specified name and size slug.  If
+// opts is not nil, it sets the storage class, labels, and options of the
+// volume.
+func (c *Client) CreateVolume(name string, size string, opts *CreateOptions) (*zstoredhttp.Volume, error) {
+	if opts == nil {
+		opts = &CreateOptions{}
+	}
+
+	return c.volumeRequest("POST", name, &zstoredhttp.StorageRequest{
+		Size:    size,
+		Class:   opts.Class,
+		Labels:  opts.Labels,
+		Options: opts.Options,
+	})
+}
+
+// CloneVolume creates a new volume with the specified name, cloned from the
+// named snapshot of an existing volume.
+func (c *Client) CloneVolume(name string, volume string, snapshot string) (*zstoredhttp.Volume, error) {
+	if !validName(volume) {
+		return nil, ErrInvalidVolumeName
+	}
+
+	return c.volumeRequest("POST", name, &zstoredhttp.StorageRequest{
+		Source: &zstoredhttp.StorageSource{
+			Volume:   volume,
+			Snapshot: snapshot,
+		},
+	})
+}
+
+// ResizeVolume changes the size of a volume to the specified size slug.  If
+// force is false, a volume cannot be shrunk.
+func (c *Client) ResizeVolume(name string, size string, force bool) (*zstoredhttp.Volume, error) {
+	return c.volumeRequest("PUT", name, &zstoredhttp.StorageRequest{
+		Size:  size,
+		Force: force,
+	})
+}
+
+// SetLabels replaces all labels of a volume.
+func (c *Client) SetLabels(name string, labels map[string]string) (*zstoredhttp.Volume, error) {
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+
+	return c.volumeRequest("PUT", name, &zstoredhttp.StorageRequest{
+		Labels: labels,
+	})
+}
+
+// UpdateLabels merges labels into the existing labels of a volume.  Labels
+// with empty values are removed.
+func (c *Client) UpdateLabels(name string, labels map[string]string) (*zstoredhttp.Volume, error) {
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+
+	return c.volumeRequest("PATCH", name, &zstoredhttp.StorageRequest{
+		Labels: labels,
+	})
+}
+
+// Volume retrieves metadata for a single volume by name.
+func (c *Client) Volume(name string) (*zstoredhttp.Volume, error) {
+	return c.volumeRequest("GET", name, nil)
+}
+
+// ListVolumes retrieves metadata for all volumes which belong to the client.
+// If selector is not empty, only volumes with labels matching the selector
+// are returned; see storage.ParseLabelSelector for its format.
+func (c *Client) ListVolumes(selector string) ([]*zstoredhttp.Volume, error) {
+	p := storageAPI
+	if selector != "" {
+		p += "?" + url.Values{"selector": []string{selector}}.Encode()
+	}
+
+	res := new(zstoredhttp.StorageResponse)
+	if err := c.do("GET", p, nil, res); err != nil {
+		return nil, err
+	}
+
+	return res.Volumes, nil
+}
+
+// DestroyVolume destroys a volume by name, including all of its snapshots.
+func (c *Client) DestroyVolume(name string) error {
+	if !validName(name) {
+		return ErrInvalidVolumeName
+	}
+
+	return c.do("DELETE", path.Join(storageAPI, name), nil, nil)
+}
+
+// Sizes retrieves the catalog of size slugs which the server permits for
+// creating and resizing volumes, ordered from smallest to largest.
+func (c *Client) Sizes() ([]*zstoredhttp.Size, error) {
+	res := new(zstoredhttp.SizesResponse)
+	if err := c.do("GET", sizesAPI, nil, res); err != nil {
+		return nil, err
+	}
+
+	return res.Sizes, nil
+}
+
+// Discover retrieves the API version, enabled features, and size slug catalog
+// of the server.
+func (c *Client) Discover() (*zstoredhttp.DiscoveryResponse, error) {
+	res := new(zstoredhttp.DiscoveryResponse)
+	if err := c.do("GET", discoveryAPI, nil, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// Pool retrieves the live status of the server's pool.  Only administrators
+// may retrieve the pool's status.
+func (c *Client) Pool() (*zstoredhttp.Pool, error) {
+	res := new(zstoredhttp.PoolResponse)
+	if err := c.do("GET", poolAPI, nil, res); err != nil {
+		return nil, err
+	}
+
+	return res.Pool, nil
+}
+
+// volumeRequest performs a request against a single volume, and returns the
+// volume from the response.
+func (c *Client) volumeRequest(method string, name string, sr *zstoredhttp.StorageRequest) (*zstoredhttp.Volume, error) {
+	if !validName(name) {
+		return nil, ErrInvalidVolumeName
+	}
+
+	res := new(zstoredhttp.StorageResponse)
+	if err := c.do(method, path.Join(storageAPI, name), sr, res); err != nil {
+		return nil, err
+	}
+
+	if len(res.Volumes) != 1 {
+		return nil, fmt.Errorf("unexpected number of volumes in response: %d", len(res.Volumes))
+	}
+
+	return res.Volumes[0], nil
+}
+
+// do performs a HTTP request with an optional JSON body, and decodes a JSON
+// response body into out, if out is not nil.
+func (c *Client) do(method string, p string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	// Path may contain a query string
+	u := *c.u
+	p, query := splitQuery(p)
+	u.Path = strings.TrimSuffix(u.Path, "/") + p
+	u.RawQuery = query
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	res, err := c.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return responseError(res.StatusCode, b)
+	}
+
+	if out == nil || len(b) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(b, out)
+}
+
+// errorCodes maps storage API error codes to storage package errors.
+var errorCodes = map[zstoredhttp.ErrorCode]error{
+	zstoredhttp.ErrorCodeVolumeNotFound:       storage.ErrVolumeNotExists,
+	zstoredhttp.ErrorCodeVolumeExists:         storage.ErrVolumeExists,
+	zstoredhttp.ErrorCodePoolOutOfSpace:       storage.ErrPoolOutOfSpace,
+	zstoredhttp.ErrorCodeDependentClones:      storage.ErrDependentClones,
+	zstoredhttp.ErrorCodeSnapshotNotFound:     storage.ErrSnapshotNotExists,
+	zstoredhttp.ErrorCodeSnapshotExists:       storage.ErrSnapshotExists,
+	zstoredhttp.ErrorCodeInvalidSnapshotName:  storage.ErrInvalidSnapshotName,
+	zstoredhttp.ErrorCodeNewerSnapshotsExist:  storage.ErrNewerSnapshotsExist,
+	zstoredhttp.ErrorCodeInvalidLabel:         storage.ErrInvalidLabel,
+	zstoredhttp.ErrorCodeInvalidLabelSelector: storage.ErrInvalidLabelSelector,
+	zstoredhttp.ErrorCodeInvalidVolumeOption:  storage.ErrInvalidVolumeOption,
+	zstoredhttp.ErrorCodeInvalidClass:         storage.ErrInvalidClass,
+}
+
+// statusCodes maps HTTP status codes to storage package errors, for responses
+// which do not contain a JSON error body.
+var statusCodes = map[int]error{
+	http.StatusNotFound:           storage.ErrVolumeNotExists,
+	http.StatusConflict:           storage.ErrVolumeExists,
+	http.StatusServiceUnavailable: storage.ErrPoolOutOfSpace,
+}
+
+// responseError returns an error for a non-successful HTTP response.  Errors
+// which correspond to storage package errors are mapped to those errors;
+// other errors are returned as *zstoredhttp.Error.
+func responseError(code int, body []byte) error {
+	e := new(zstoredhttp.Error)
+	if err := json.Unmarshal(body, e); err != nil || e.Code == "" {
+		if err, ok := statusCodes[code]; ok {
+			return err
+		}
+
+		return fmt.Errorf("unexpected HTTP status: %d %s", code, http.StatusText(code))
+	}
+
+	if err, ok := errorCodes[e.Code]; ok {
+		return err
+	}
+
+	return e
+}
+
+// splitQuery splits a path into its path and query string components.
+func splitQuery(p string) (string, string) {
+	i := strings.Index(p, "?")
+	if i == -1 {
+		return p, ""
+	}
+
+	return p[:i], p[i+1:]
+}
+
+// validName determines if a volume name can be used with the storage API.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
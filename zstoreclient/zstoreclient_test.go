@@ -0,0 +1,157 @@
+package zstoreclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mdlayher/zstore/storage"
+	"github.com/mdlayher/zstore/zstored/zstoredhttp"
+)
+
+// TestClientVolumes verifies that a Client can create, retrieve, list, resize,
+// and destroy volumes using a zstored server.
+func TestClientVolumes(t *testing.T) {
+	c, done := testClient(t, storage.NewMemPool("zstore", 2*storage.GB))
+	defer done()
+
+	// No volumes exist yet
+	volumes, err := c.ListVolumes("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 0 {
+		t.Fatalf("unexpected number of volumes: %v != %v", len(volumes), 0)
+	}
+	if _, err := c.Volume("foo"); err != storage.ErrVolumeNotExists {
+		t.Fatalf("unexpected error for unknown volume: %v != %v", err, storage.ErrVolumeNotExists)
+	}
+
+	sizes, err := c.Sizes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sizes) != len(storage.Slugs()) || sizes[0].Slug != "256M" || sizes[0].Size != 256*storage.MB {
+		t.Fatalf("unexpected sizes: %v", sizes)
+	}
+
+	d, err := c.Discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Version != zstoredhttp.APIVersion || len(d.Sizes) != len(sizes) {
+		t.Fatalf("unexpected discovery response: %v", d)
+	}
+
+	// Only administrators may retrieve the pool's status
+	_, err = c.Pool()
+	if e, ok := err.(*zstoredhttp.Error); !ok || e.Code != zstoredhttp.ErrorCodeForbidden {
+		t.Fatalf("unexpected error for pool status: %v", err)
+	}
+
+	v, err := c.CreateVolume("foo", "1G", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "foo" || v.Size != 1*storage.GB {
+		t.Fatalf("unexpected volume: %v", v)
+	}
+
+	if _, err := c.CreateVolume("foo", "1G", nil); err != storage.ErrVolumeExists {
+		t.Fatalf("unexpected error for duplicate volume: %v != %v", err, storage.ErrVolumeExists)
+	}
+	if _, err := c.CreateVolume("bar", "2G", nil); err != storage.ErrPoolOutOfSpace {
+		t.Fatalf("unexpected error for full pool: %v != %v", err, storage.ErrPoolOutOfSpace)
+	}
+
+	// Errors without a storage package equivalent are returned as API errors
+	_, err = c.CreateVolume("bar", "3G", nil)
+	if e, ok := err.(*zstoredhttp.Error); !ok || e.Code != zstoredhttp.ErrorCodeInvalidSize {
+		t.Fatalf("unexpected error for invalid size: %v", err)
+	}
+
+	if _, err := c.ResizeVolume("foo", "512M", false); err == nil {
+		t.Fatal("expected error for shrinking volume without force")
+	}
+	if v, err = c.ResizeVolume("foo", "512M", true); err != nil {
+		t.Fatal(err)
+	}
+	if v.Size != 512*storage.MB {
+		t.Fatalf("unexpected volume size: %v != %v", v.Size, 512*storage.MB)
+	}
+
+	volumes, err = c.ListVolumes("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 1 || volumes[0].Name != "foo" {
+		t.Fatalf("unexpected volumes: %v", volumes)
+	}
+
+	// Labels can be set, updated, and used to filter volumes
+	if _, err := c.CreateVolume("bar", "256M", &CreateOptions{
+		Labels: map[string]string{"env": "dev"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.SetLabels("foo", map[string]string{"env": "prod", "tier": "gold"}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err = c.UpdateLabels("foo", map[string]string{"tier": ""}); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Labels) != 1 || v.Labels["env"] != "prod" {
+		t.Fatalf("unexpected labels: %v", v.Labels)
+	}
+	if _, err := c.UpdateLabels("foo", map[string]string{"Env": "prod"}); err != storage.ErrInvalidLabel {
+		t.Fatalf("unexpected error for invalid label: %v != %v", err, storage.ErrInvalidLabel)
+	}
+
+	volumes, err = c.ListVolumes("env=prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 1 || volumes[0].Name != "foo" {
+		t.Fatalf("unexpected volumes: %v", volumes)
+	}
+	if _, err := c.ListVolumes("Env"); err != storage.ErrInvalidLabelSelector {
+		t.Fatalf("unexpected error for invalid selector: %v != %v", err, storage.ErrInvalidLabelSelector)
+	}
+
+	if err := c.DestroyVolume("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DestroyVolume("foo"); err != storage.ErrVolumeNotExists {
+		t.Fatalf("unexpected error for destroyed volume: %v != %v", err, storage.ErrVolumeNotExists)
+	}
+}
+
+// TestClientInvalidVolumeName verifies that a Client rejects volume names
+// which do not belong to its bucket.
+func TestClientInvalidVolumeName(t *testing.T) {
+	c, done := testClient(t, storage.NewMemPool("zstore", 0))
+	defer done()
+
+	for _, name := range []string{"", ".", "..", "foo/bar", "../foo"} {
+		if _, err := c.CreateVolume(name, "1G", nil); err != ErrInvalidVolumeName {
+			t.Fatalf("unexpected error for volume %q: %v != %v", name, err, ErrInvalidVolumeName)
+		}
+		if err := c.DestroyVolume(name); err != ErrInvalidVolumeName {
+			t.Fatalf("unexpected error for volume %q: %v != %v", name, err, ErrInvalidVolumeName)
+		}
+	}
+}
+
+// testClient starts a zstored HTTP server backed by the input Pool, and returns
+// a Client for the server and a function which stops the server when called.
+func testClient(t *testing.T, pool storage.Pool) (*Client, func()) {
+	s := httptest.NewServer(zstoredhttp.NewServeMux(pool, nil))
+
+	c, err := NewClient(s.URL, &http.Client{})
+	if err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+
+	return c, s.Close
+}
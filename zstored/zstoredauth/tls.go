@@ -0,0 +1,95 @@
+package zstoredauth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"sync"
+)
+
+var (
+	// errNoCACertificates is returned when a client CA bundle contains no
+	// PEM-encoded certificates.
+	errNoCACertificates = errors.New("no certificates found in client CA bundle")
+)
+
+// CertificateReloader provides a TLS configuration which requires clients to
+// present a certificate signed by a trusted CA.  The server certificate and
+// client CA bundle are read from files, and may be reloaded while a server
+// is running; new connections use the reloaded files, while existing
+// connections are unaffected.
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	cas  *x509.CertPool
+}
+
+// NewCertificateReloader creates a new CertificateReloader, which loads a
+// server certificate and key, and a bundle of client CA certificates, from
+// the specified files.
+func NewCertificateReloader(certFile string, keyFile string, caFile string) (*CertificateReloader, error) {
+	r := &CertificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+	}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Reload reads the server certificate and client CA bundle from their files.
+// If any file cannot be loaded, the previously loaded certificates remain
+// in use.
+func (r *CertificateReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	b, err := ioutil.ReadFile(r.caFile)
+	if err != nil {
+		return err
+	}
+
+	cas := x509.NewCertPool()
+	if !cas.AppendCertsFromPEM(b) {
+		return errNoCACertificates
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cert = &cert
+	r.cas = cas
+	return nil
+}
+
+// TLSConfig returns a TLS configuration which uses the most recently loaded
+// server certificate, and requires and verifies client certificates using
+// the most recently loaded client CA bundle.
+func (r *CertificateReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				Certificates: []tls.Certificate{*r.cert},
+				ClientCAs:    r.cas,
+			}, nil
+		},
+	}
+}
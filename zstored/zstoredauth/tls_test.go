@@ -0,0 +1,190 @@
+package zstoredauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestCertificateReloader verifies that CertificateReloader requires client
+// certificates, and that reloading its files replaces the trusted client CAs.
+func TestCertificateReloader(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "zstoredauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server.key")
+	caFile := filepath.Join(dir, "ca.pem")
+
+	// Issue server and client certificates from the first CA
+	ca1, ca1Key := testCertificate(t, "ca1", nil, nil)
+	server, serverKey := testCertificate(t, "localhost", ca1, ca1Key)
+	client1, client1Key := testCertificate(t, "acme", ca1, ca1Key)
+	testWritePEM(t, certFile, server, serverKey, keyFile)
+	testWritePEM(t, caFile, ca1, nil, "")
+
+	// Missing CA bundle cannot be loaded
+	if _, err := NewCertificateReloader(certFile, keyFile, filepath.Join(dir, "foo")); err == nil {
+		t.Fatal("expected error for missing client CA bundle")
+	}
+
+	r, err := NewCertificateReloader(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := r.TLSConfig()
+
+	if err := testHandshake(config, ca1, nil, nil); err == nil {
+		t.Fatal("expected error for client without certificate")
+	}
+	if err := testHandshake(config, ca1, client1, client1Key); err != nil {
+		t.Fatal(err)
+	}
+
+	// Trust only a second CA, and reload
+	ca2, ca2Key := testCertificate(t, "ca2", nil, nil)
+	client2, client2Key := testCertificate(t, "acme", ca2, ca2Key)
+	testWritePEM(t, caFile, ca2, nil, "")
+
+	// Invalid bundles leave the previous CAs in place
+	if err := ioutil.WriteFile(caFile+".bad", []byte("foo"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	bad := &CertificateReloader{certFile: certFile, keyFile: keyFile, caFile: caFile + ".bad"}
+	if err := bad.Reload(); err != errNoCACertificates {
+		t.Fatalf("unexpected error for invalid bundle: %v != %v", err, errNoCACertificates)
+	}
+
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := testHandshake(config, ca1, client1, client1Key); err == nil {
+		t.Fatal("expected error for client from untrusted CA")
+	}
+	if err := testHandshake(config, ca1, client2, client2Key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testHandshake performs a TLS handshake between a server using config, and
+// a client which trusts ca and presents the specified certificate, if any.
+func testHandshake(config *tls.Config, ca *x509.Certificate, cert *x509.Certificate, key *ecdsa.PrivateKey) error {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	cc := &tls.Config{
+		RootCAs:    roots,
+		ServerName: "localhost",
+	}
+	if cert != nil {
+		cc.Certificates = []tls.Certificate{{
+			Certificate: [][]byte{cert.Raw},
+			PrivateKey:  key,
+		}}
+	}
+
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+
+	errC := make(chan error, 1)
+	go func() {
+		// Read forces the client to process the server's verdict on its
+		// certificate, which arrives after the client's handshake completes
+		tc := tls.Client(c, cc)
+		if err := tc.Handshake(); err != nil {
+			errC <- err
+			return
+		}
+		_, err := tc.Read(make([]byte, 1))
+		errC <- err
+	}()
+
+	ts := tls.Server(s, config)
+	if err := ts.Handshake(); err != nil {
+		s.Close()
+		<-errC
+		return err
+	}
+	if _, err := ts.Write([]byte{0}); err != nil {
+		return err
+	}
+
+	return <-errC
+}
+
+// testCertificate creates a certificate with the specified common name, signed
+// by parent.  If parent is nil, a self-signed CA certificate is created.
+func testCertificate(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(1 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+// testWritePEM writes a PEM-encoded certificate to certFile, and if key is
+// not nil, its PEM-encoded private key to keyFile.
+func testWritePEM(t *testing.T, certFile string, cert *x509.Certificate, key *ecdsa.PrivateKey, keyFile string) {
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if err := ioutil.WriteFile(certFile, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil {
+		return
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err := ioutil.WriteFile(keyFile, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
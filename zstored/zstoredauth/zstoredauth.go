@@ -0,0 +1,276 @@
+// Package zstoredauth provides API token authentication for zstored.
+package zstoredauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidToken is returned when a token is not known to a TokenStore.
+	ErrInvalidToken = errors.New("invalid token")
+
+	// ErrTokenRevoked is returned when a token is known to a TokenStore, but
+	// has been revoked by an administrator.
+	ErrTokenRevoked = errors.New("token revoked")
+
+	// ErrTokenNotExists is returned when an invalid token ID is provided
+	// by a caller.
+	ErrTokenNotExists = errors.New("token not found")
+
+	// ErrInvalidTenant is returned when a tenant ID cannot be used as the
+	// name of a storage bucket.
+	ErrInvalidTenant = errors.New("invalid tenant ID")
+)
+
+// tenantRegexp matches valid tenant IDs, which are also used as the names
+// of storage buckets.
+var tenantRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Token is metadata about an API token, which identifies a tenant.  The token
+// itself is never stored; only its SHA-256 hash is.
+type Token struct {
+	ID      string    `json:"id"`
+	Hash    string    `json:"hash"`
+	Tenant  string    `json:"tenant"`
+	Created time.Time `json:"created"`
+	Revoked bool      `json:"revoked,omitempty"`
+}
+
+// TokenStore is a file-backed store of hashed API tokens.  The file is
+// reloaded whenever it is modified, so tokens minted or revoked by another
+// process take effect without restarting zstored.
+type TokenStore struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	tokens  []*Token
+}
+
+// NewTokenStore creates a new TokenStore which stores tokens in the file at
+// the specified path.  The file is created when the first token is minted.
+func NewTokenStore(path string) *TokenStore {
+	return &TokenStore{
+		path: path,
+	}
+}
+
+// Tenant returns the tenant ID identified by an API token.
+func (s *TokenStore) Tenant(token string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return "", err
+	}
+
+	// Compare hashes in constant time, so the hash of a valid token cannot
+	// be discovered through timing
+	hash := hashToken(token)
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(t.Hash)) != 1 {
+			continue
+		}
+
+		if t.Revoked {
+			return "", ErrTokenRevoked
+		}
+
+		return t.Tenant, nil
+	}
+
+	return "", ErrInvalidToken
+}
+
+// Mint creates a new API token for the specified tenant ID.  The token is
+// returned only once, and cannot be recovered later.
+func (s *TokenStore) Mint(tenant string) (string, *Token, error) {
+	if !ValidTenant(tenant) {
+		return "", nil, ErrInvalidTenant
+	}
+
+	token, err := randomHex(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return "", nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return "", nil, err
+	}
+
+	t := &Token{
+		ID:      id,
+		Hash:    hashToken(token),
+		Tenant:  tenant,
+		Created: time.Now().UTC(),
+	}
+	s.tokens = append(s.tokens, t)
+
+	if err := s.save(); err != nil {
+		return "", nil, err
+	}
+
+	return token, t, nil
+}
+
+// Revoke revokes the API token with the specified ID.  Revoked tokens remain
+// in the store, so that clients using them are forbidden rather than
+// unauthorized.
+func (s *TokenStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	for _, t := range s.tokens {
+		if t.ID != id {
+			continue
+		}
+
+		t.Revoked = true
+		return s.save()
+	}
+
+	return ErrTokenNotExists
+}
+
+// Tokens returns metadata about all API tokens in the store.
+func (s *TokenStore) Tokens() ([]*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	tokens := make([]*Token, len(s.tokens))
+	for i := range tokens {
+		t := *s.tokens[i]
+		tokens[i] = &t
+	}
+
+	return tokens, nil
+}
+
+// load reads tokens from the store's file, if it has been modified since
+// it was last read.  The caller must hold s.mu.
+func (s *TokenStore) load() error {
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		// No tokens have been minted yet
+		if os.IsNotExist(err) {
+			s.tokens = nil
+			s.modTime = time.Time{}
+			s.size = 0
+			return nil
+		}
+
+		return err
+	}
+
+	// Skip reload if file is unchanged; size is also checked in case
+	// modification times are not precise
+	if fi.ModTime().Equal(s.modTime) && fi.Size() == s.size {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	var tokens []*Token
+	if err := json.Unmarshal(b, &tokens); err != nil {
+		return err
+	}
+
+	s.tokens = tokens
+	s.modTime = fi.ModTime()
+	s.size = fi.Size()
+	return nil
+}
+
+// save atomically writes tokens to the store's file.  The caller must
+// hold s.mu.
+func (s *TokenStore) save() error {
+	b, err := json.MarshalIndent(s.tokens, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it, so that a concurrent load
+	// never observes a partially written file
+	f, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path))
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := os.Rename(f.Name(), s.path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+
+	s.modTime = fi.ModTime()
+	s.size = fi.Size()
+	return nil
+}
+
+// ValidTenant determines if a tenant ID can be used as the name of a
+// storage bucket.
+func ValidTenant(tenant string) bool {
+	return tenantRegexp.MatchString(tenant)
+}
+
+// hashToken returns the hex-encoded SHA-256 hash of an API token.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomHex returns n cryptographically random bytes, encoded as hex.
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,107 @@
+package zstoredauth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestTokenStoreMint verifies that minted tokens identify their tenant, and
+// that only token hashes are stored.
+func TestTokenStoreMint(t *testing.T) {
+	s, done := testTokenStore(t)
+	defer done()
+
+	// Unknown tokens are invalid, even before the file exists
+	if _, err := s.Tenant("foo"); err != ErrInvalidToken {
+		t.Fatalf("unexpected error for unknown token: %v != %v", err, ErrInvalidToken)
+	}
+
+	// Tenant IDs must be usable as bucket names
+	for _, tenant := range []string{"", "Foo", "foo/bar", "-foo", "foo@bar"} {
+		if _, _, err := s.Mint(tenant); err != ErrInvalidTenant {
+			t.Fatalf("unexpected error for tenant %q: %v != %v", tenant, err, ErrInvalidTenant)
+		}
+	}
+
+	token, tok, err := s.Mint("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.Tenant != "acme" {
+		t.Fatalf("unexpected tenant: %v != %v", tok.Tenant, "acme")
+	}
+
+	// Token itself must not be written to disk
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), token) {
+		t.Fatal("token file contains plaintext token")
+	}
+
+	tenant, err := s.Tenant(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tenant != "acme" {
+		t.Fatalf("unexpected tenant: %v != %v", tenant, "acme")
+	}
+}
+
+// TestTokenStoreRevoke verifies that revoked tokens are rejected, including
+// by other TokenStores using the same file.
+func TestTokenStoreRevoke(t *testing.T) {
+	s, done := testTokenStore(t)
+	defer done()
+
+	token, tok, err := s.Mint("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Load tokens into a second store before revocation
+	other := NewTokenStore(s.path)
+	if _, err := other.Tenant(token); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Revoke("foo"); err != ErrTokenNotExists {
+		t.Fatalf("unexpected error for unknown token ID: %v != %v", err, ErrTokenNotExists)
+	}
+	if err := s.Revoke(tok.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, store := range []*TokenStore{s, other} {
+		if _, err := store.Tenant(token); err != ErrTokenRevoked {
+			t.Fatalf("unexpected error for revoked token: %v != %v", err, ErrTokenRevoked)
+		}
+	}
+
+	tokens, err := s.Tokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || !tokens[0].Revoked {
+		t.Fatalf("unexpected tokens: %v", tokens)
+	}
+}
+
+// testTokenStore creates a TokenStore in a temporary directory, and returns
+// a function which cleans up the directory when called.
+func testTokenStore(t *testing.T) (*TokenStore, func()) {
+	dir, err := ioutil.TempDir(os.TempDir(), "zstoredauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewTokenStore(filepath.Join(dir, "tokens.json")), func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
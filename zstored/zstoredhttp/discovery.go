@@ -0,0 +1,115 @@
+package zstoredhttp
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/mdlayher/zstore/storage"
+)
+
+const (
+	// discoveryAPI is the path of the discovery API
+	discoveryAPI = "/v1/discovery"
+
+	// APIVersion is the version of the zstored HTTP API served by this
+	// package.
+	APIVersion = "v1"
+)
+
+// Features of the zstored HTTP API, which are reported by the discovery API.
+// Features which depend on server configuration are only reported when they
+// are enabled.
+const (
+	// FeatureLabels indicates that volumes may carry labels, and may be
+	// filtered with label selectors.
+	FeatureLabels = "labels"
+
+	// FeatureSnapshots indicates that volumes may be snapshotted, rolled
+	// back, and cloned.
+	FeatureSnapshots = "snapshots"
+
+	// FeatureVolumeOptions indicates that volume creation options may be
+	// set when a volume is created.
+	FeatureVolumeOptions = "volume_options"
+
+	// FeatureExactSizes indicates that volumes may be created and resized
+	// with exact sizes, rather than only with size slugs.
+	FeatureExactSizes = "exact_sizes"
+
+	// FeatureClasses indicates that storage classes may be selected when
+	// a volume is created.
+	FeatureClasses = "classes"
+)
+
+// DiscoveryResponse is a struct which represents a response from the
+// discovery API, which describes the API version, features, and sizes which
+// a server supports, so that clients need not discover them through errors.
+//
+// Sizes are ordered from smallest to largest, and Features are sorted.  If
+// exact sizes are enabled, ExactSizes reports their limits.
+type DiscoveryResponse struct {
+	Version    string      `json:"version"`
+	Features   []string    `json:"features"`
+	Sizes      []*Size     `json:"sizes"`
+	ExactSizes *SizeLimits `json:"exact_sizes,omitempty"`
+}
+
+// discoveryHandler is a http.Handler which serves the discovery API.
+type discoveryHandler struct {
+	pool       storage.Pool
+	catalog    *storage.SlugCatalog
+	exactSizes *SizeLimits
+	features   []string
+}
+
+// ServeHTTP describes the server to clients.  The description is not specific
+// to any tenant, so clients need not be identified.
+func (h *discoveryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, nil)
+		return
+	}
+
+	sizes, err := catalogSizes(h.pool, h.catalog)
+	if err != nil {
+		log.Println(err)
+		writeError(w, http.StatusInternalServerError, ErrorCodeInternal, nil)
+		return
+	}
+
+	body, err := json.Marshal(&DiscoveryResponse{
+		Version:    APIVersion,
+		Features:   h.features,
+		Sizes:      sizes,
+		ExactSizes: h.exactSizes,
+	})
+	if err != nil {
+		log.Println(err)
+		writeError(w, http.StatusInternalServerError, ErrorCodeInternal, nil)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, body)
+}
+
+// features returns the sorted features of the API which are enabled by a
+// configuration.
+func features(config *Config) []string {
+	fs := []string{
+		FeatureLabels,
+		FeatureSnapshots,
+		FeatureVolumeOptions,
+	}
+
+	if config.ExactSizes != nil {
+		fs = append(fs, FeatureExactSizes)
+	}
+	if len(config.Classes) > 0 {
+		fs = append(fs, FeatureClasses)
+	}
+
+	sort.Strings(fs)
+	return fs
+}
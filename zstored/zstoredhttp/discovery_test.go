@@ -0,0 +1,82 @@
+package zstoredhttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/mdlayher/zstore/storage"
+)
+
+// TestDiscovery verifies that the discovery API reports the API version,
+// the features enabled by the configuration, and the size slug catalog.
+func TestDiscovery(t *testing.T) {
+	pool := storage.NewMemPool("zstore", 1*storage.GB)
+
+	var tests = []struct {
+		description string
+		config      *Config
+		features    []string
+		exactSizes  *SizeLimits
+	}{
+		{
+			description: "default configuration",
+			features:    []string{FeatureLabels, FeatureSnapshots, FeatureVolumeOptions},
+		},
+		{
+			description: "exact sizes and storage classes",
+			config: &Config{
+				ExactSizes: &SizeLimits{Min: 1 * storage.GB, Max: 10 * storage.GB},
+				Classes:    []*storage.Class{{Name: "fast"}},
+			},
+			features: []string{
+				FeatureClasses,
+				FeatureExactSizes,
+				FeatureLabels,
+				FeatureSnapshots,
+				FeatureVolumeOptions,
+			},
+			exactSizes: &SizeLimits{Min: 1 * storage.GB, Max: 10 * storage.GB},
+		},
+	}
+
+	for _, test := range tests {
+		w := testConfigRequest(t, pool, test.config, "GET", "/v1/discovery", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected code: %v != %v [description: %s]", w.Code, http.StatusOK, test.description)
+		}
+
+		res := new(DiscoveryResponse)
+		if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+			t.Fatal(err)
+		}
+
+		if res.Version != APIVersion {
+			t.Fatalf("unexpected version: %v != %v [description: %s]", res.Version, APIVersion, test.description)
+		}
+		if !reflect.DeepEqual(res.Features, test.features) {
+			t.Fatalf("unexpected features: %v != %v [description: %s]", res.Features, test.features, test.description)
+		}
+		if !reflect.DeepEqual(res.ExactSizes, test.exactSizes) {
+			t.Fatalf("unexpected exact sizes: %v != %v [description: %s]", res.ExactSizes, test.exactSizes, test.description)
+		}
+
+		// Default catalog is 256M through 8G, and only 256M through 1G
+		// fit in the pool
+		if l := len(res.Sizes); l != len(storage.Slugs()) {
+			t.Fatalf("unexpected number of sizes: %v != %v [description: %s]", l, len(storage.Slugs()), test.description)
+		}
+		for _, s := range res.Sizes {
+			if fits := s.Size <= 1*storage.GB; s.Fits != fits {
+				t.Fatalf("unexpected fits for %s: %v != %v [description: %s]", s.Slug, s.Fits, fits, test.description)
+			}
+		}
+	}
+
+	// Discovery is read-only
+	w := testStorageRequest(t, pool, "POST", "/v1/discovery", "")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,185 @@
+package zstoredhttp
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// ErrorCode is a stable, machine-readable code which identifies the cause
+// of an error returned by the zstored HTTP API.  Clients should branch on
+// these codes, rather than on HTTP status codes or error messages.
+type ErrorCode string
+
+// Error codes returned by the zstored HTTP API.
+const (
+	// ErrorCodeInternal is returned when an unexpected server error occurs.
+	ErrorCodeInternal ErrorCode = "internal_error"
+
+	// ErrorCodeNotFound is returned when a request path does not identify
+	// any API resource.
+	ErrorCodeNotFound ErrorCode = "not_found"
+
+	// ErrorCodeMethodNotAllowed is returned when a HTTP method is not
+	// supported by an API resource.
+	ErrorCodeMethodNotAllowed ErrorCode = "method_not_allowed"
+
+	// ErrorCodeUnauthorized is returned when a client's tenant cannot be
+	// identified.
+	ErrorCodeUnauthorized ErrorCode = "unauthorized"
+
+	// ErrorCodeForbidden is returned when a client's credentials have been
+	// revoked, or when a client which is not an administrator requests an
+	// administrative API.
+	ErrorCodeForbidden ErrorCode = "forbidden"
+
+	// ErrorCodeInvalidRequest is returned when a request body cannot be
+	// decoded, or contains conflicting parameters.
+	ErrorCodeInvalidRequest ErrorCode = "invalid_request"
+
+	// ErrorCodeVolumeNotFound is returned when a volume does not exist.
+	ErrorCodeVolumeNotFound ErrorCode = "volume_not_found"
+
+	// ErrorCodeVolumeExists is returned when creating a volume which
+	// already exists.
+	ErrorCodeVolumeExists ErrorCode = "volume_exists"
+
+	// ErrorCodeInvalidSize is returned when a size is not valid.  Details
+	// contain the list of valid size slugs as "sizes", and if exact sizes
+	// are permitted, their limits in bytes as "min" and "max".
+	ErrorCodeInvalidSize ErrorCode = "invalid_size"
+
+	// ErrorCodeShrinkNotForced is returned when shrinking a volume without
+	// setting force in the request.
+	ErrorCodeShrinkNotForced ErrorCode = "shrink_not_forced"
+
+	// ErrorCodePoolOutOfSpace is returned when the storage pool cannot
+	// allocate the requested space.  Details contain the requested size in
+	// bytes as "size", when known, and the number of bytes which are free
+	// in the pool as "free".
+	ErrorCodePoolOutOfSpace ErrorCode = "pool_out_of_space"
+
+	// ErrorCodeInvalidSource is returned when the source volume or snapshot
+	// for a clone is invalid or does not exist.
+	ErrorCodeInvalidSource ErrorCode = "invalid_source"
+
+	// ErrorCodeDependentClones is returned when destroying a volume or
+	// snapshot from which other volumes were cloned.
+	ErrorCodeDependentClones ErrorCode = "dependent_clones"
+
+	// ErrorCodeSnapshotNotFound is returned when a snapshot does not exist.
+	ErrorCodeSnapshotNotFound ErrorCode = "snapshot_not_found"
+
+	// ErrorCodeSnapshotExists is returned when creating a snapshot which
+	// already exists.
+	ErrorCodeSnapshotExists ErrorCode = "snapshot_exists"
+
+	// ErrorCodeInvalidSnapshotName is returned when a snapshot name is
+	// not valid.
+	ErrorCodeInvalidSnapshotName ErrorCode = "invalid_snapshot_name"
+
+	// ErrorCodeInvalidLabel is returned when a label key or value is
+	// not valid.
+	ErrorCodeInvalidLabel ErrorCode = "invalid_label"
+
+	// ErrorCodeInvalidLabelSelector is returned when a label selector
+	// cannot be parsed.
+	ErrorCodeInvalidLabelSelector ErrorCode = "invalid_label_selector"
+
+	// ErrorCodeInvalidVolumeOption is returned when a volume creation option
+	// is unknown or has an invalid value.  Details contain the list of
+	// permitted option names as "options".
+	ErrorCodeInvalidVolumeOption ErrorCode = "invalid_volume_option"
+
+	// ErrorCodeInvalidClass is returned when an unknown storage class is
+	// selected.  Details contain the list of configured storage class names
+	// as "classes".
+	ErrorCodeInvalidClass ErrorCode = "invalid_storage_class"
+
+	// ErrorCodeNewerSnapshotsExist is returned when rolling back to a
+	// snapshot while newer snapshots exist, without destroy_newer set.
+	ErrorCodeNewerSnapshotsExist ErrorCode = "newer_snapshots_exist"
+
+	// ErrorCodeNotReady is returned by the readiness API when the server
+	// cannot serve storage requests.  The reason is included in details as
+	// "reason".
+	ErrorCodeNotReady ErrorCode = "not_ready"
+
+	// ErrorCodePoolDegraded is returned when a request would modify a volume
+	// while the pool is not online, and the server is in read-only degraded
+	// mode.  The reason is included in details as "reason".
+	ErrorCodePoolDegraded ErrorCode = "pool_degraded"
+)
+
+// errorMessages maps error codes to human-readable messages.
+var errorMessages = map[ErrorCode]string{
+	ErrorCodeInternal:             "internal server error",
+	ErrorCodeNotFound:             "not found",
+	ErrorCodeMethodNotAllowed:     "method not allowed",
+	ErrorCodeUnauthorized:         "unauthorized",
+	ErrorCodeForbidden:            "forbidden",
+	ErrorCodeInvalidRequest:       "invalid request",
+	ErrorCodeVolumeNotFound:       "volume not found",
+	ErrorCodeVolumeExists:         "volume already exists",
+	ErrorCodeInvalidSize:          "invalid size",
+	ErrorCodeShrinkNotForced:      "shrinking a volume requires force",
+	ErrorCodePoolOutOfSpace:       "pool out of space",
+	ErrorCodeInvalidSource:        "invalid clone source",
+	ErrorCodeDependentClones:      "other volumes are cloned from snapshots",
+	ErrorCodeSnapshotNotFound:     "snapshot not found",
+	ErrorCodeSnapshotExists:       "snapshot already exists",
+	ErrorCodeInvalidSnapshotName:  "invalid snapshot name",
+	ErrorCodeNewerSnapshotsExist:  "newer snapshots exist",
+	ErrorCodeInvalidLabel:         "invalid label",
+	ErrorCodeInvalidLabelSelector: "invalid label selector",
+	ErrorCodeInvalidVolumeOption:  "invalid volume option",
+	ErrorCodeInvalidClass:         "invalid storage class",
+	ErrorCodeNotReady:             "not ready",
+	ErrorCodePoolDegraded:         "pool degraded, volumes are read-only",
+}
+
+// Error is the JSON representation of an error returned by the zstored HTTP
+// API.  Details may contain additional information about some errors, as
+// documented for each ErrorCode.
+type Error struct {
+	Code    ErrorCode              `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
+
+// errorResponse returns a HTTP status code and JSON error body from a
+// StorageHandlerFunc.
+func errorResponse(code int, ec ErrorCode, details map[string]interface{}) (int, []byte, error) {
+	body, err := json.Marshal(&Error{
+		Code:    ec,
+		Message: errorMessages[ec],
+		Details: details,
+	})
+	return code, body, err
+}
+
+// writeError writes a HTTP status code and JSON error body to a
+// http.ResponseWriter.
+func writeError(w http.ResponseWriter, code int, ec ErrorCode, details map[string]interface{}) {
+	_, body, err := errorResponse(code, ec, details)
+	if err != nil {
+		log.Println(err)
+	}
+
+	writeJSON(w, code, body)
+}
+
+// writeJSON writes a HTTP status code and JSON body to a http.ResponseWriter.
+func writeJSON(w http.ResponseWriter, code int, body []byte) {
+	if len(body) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+	}
+
+	w.WriteHeader(code)
+	w.Write(body)
+}
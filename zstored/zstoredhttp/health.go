@@ -0,0 +1,94 @@
+package zstoredhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/mdlayher/zstore/storage"
+)
+
+const (
+	// healthAPI is the path of the liveness check
+	healthAPI = "/healthz"
+
+	// readyAPI is the path of the readiness check
+	readyAPI = "/readyz"
+)
+
+// A ReadinessCheck determines if zstored is ready to serve storage requests,
+// returning an error which describes the reason if it is not.
+type ReadinessCheck func() error
+
+// HealthResponse is a struct which represents a successful response from the
+// liveness and readiness checks.
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+// healthHandler reports that the process is alive and serving HTTP requests.
+// Load balancers call it without credentials, so clients need not be
+// identified.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, nil)
+		return
+	}
+
+	writeHealth(w)
+}
+
+// readyHandler returns a http.Handler which reports whether zstored is ready
+// to serve storage requests, according to the input ReadinessCheck.  If it is
+// not, HTTP 503 is returned with the reason, so that load balancers can drain
+// the node.
+func readyHandler(ready ReadinessCheck) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, nil)
+			return
+		}
+
+		if err := ready(); err != nil {
+			writeError(w, http.StatusServiceUnavailable, ErrorCodeNotReady, map[string]interface{}{
+				"reason": err.Error(),
+			})
+			return
+		}
+
+		writeHealth(w)
+	})
+}
+
+// writeHealth writes a successful HealthResponse for a liveness or readiness
+// check.
+func writeHealth(w http.ResponseWriter) {
+	body, err := json.Marshal(&HealthResponse{
+		Status: "ok",
+	})
+	if err != nil {
+		log.Println(err)
+		writeError(w, http.StatusInternalServerError, ErrorCodeInternal, nil)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, body)
+}
+
+// poolReady returns a ReadinessCheck which is ready while the status of a
+// pool can be retrieved, and the pool is online.
+func poolReady(pool storage.Pool) ReadinessCheck {
+	return func() error {
+		s, err := pool.Status()
+		if err != nil {
+			return err
+		}
+
+		if !s.Online() {
+			return fmt.Errorf("pool %q is not online, status: %q", s.Name, s.Health)
+		}
+
+		return nil
+	}
+}
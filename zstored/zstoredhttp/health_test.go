@@ -0,0 +1,119 @@
+package zstoredhttp
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/mdlayher/zstore/storage"
+)
+
+// TestHealth verifies that the liveness check always succeeds, and that the
+// readiness check reflects the health of the pool or a configured check.
+func TestHealth(t *testing.T) {
+	var tests = []struct {
+		description string
+		pool        storage.Pool
+		ready       ReadinessCheck
+		method      string
+		path        string
+		code        int
+		reason      string
+	}{
+		{
+			description: "alive",
+			pool:        &statusPool{health: "FAULTED"},
+			method:      "GET",
+			path:        "/healthz",
+			code:        http.StatusOK,
+		},
+		{
+			description: "liveness check is read-only",
+			pool:        storage.NewMemPool("zstore", 0),
+			method:      "POST",
+			path:        "/healthz",
+			code:        http.StatusMethodNotAllowed,
+		},
+		{
+			description: "pool online",
+			pool:        storage.NewMemPool("zstore", 0),
+			method:      "GET",
+			path:        "/readyz",
+			code:        http.StatusOK,
+		},
+		{
+			description: "pool degraded",
+			pool:        &statusPool{health: "DEGRADED"},
+			method:      "GET",
+			path:        "/readyz",
+			code:        http.StatusServiceUnavailable,
+			reason:      `pool "zstore" is not online, status: "DEGRADED"`,
+		},
+		{
+			description: "pool unreachable",
+			pool:        &statusPool{err: errors.New("no such pool")},
+			method:      "HEAD",
+			path:        "/readyz",
+			code:        http.StatusServiceUnavailable,
+			reason:      "no such pool",
+		},
+		{
+			description: "configured readiness check fails",
+			pool:        storage.NewMemPool("zstore", 0),
+			ready: func() error {
+				return errors.New("ZFS kernel module not loaded")
+			},
+			method: "GET",
+			path:   "/readyz",
+			code:   http.StatusServiceUnavailable,
+			reason: "ZFS kernel module not loaded",
+		},
+		{
+			description: "readiness check is read-only",
+			pool:        storage.NewMemPool("zstore", 0),
+			method:      "DELETE",
+			path:        "/readyz",
+			code:        http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, test := range tests {
+		config := &Config{
+			Ready: test.ready,
+		}
+
+		w := testConfigRequest(t, test.pool, config, test.method, test.path, "")
+		if w.Code != test.code {
+			t.Fatalf("unexpected code: %v != %v [description: %s]", w.Code, test.code, test.description)
+		}
+
+		if test.reason == "" {
+			continue
+		}
+
+		e := testError(t, w)
+		if e.Code != ErrorCodeNotReady || e.Details["reason"] != test.reason {
+			t.Fatalf("unexpected error: %v %v [description: %s]", e.Code, e.Details, test.description)
+		}
+	}
+}
+
+// statusPool is a storage.Pool which reports a fixed health, or an error,
+// from Status.
+type statusPool struct {
+	*storage.MemPool
+	health string
+	err    error
+}
+
+// Status returns the fixed health or error of a statusPool.
+func (p *statusPool) Status() (*storage.PoolStatus, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	return &storage.PoolStatus{
+		Name:   "zstore",
+		Health: p.health,
+	}, nil
+}
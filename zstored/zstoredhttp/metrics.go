@@ -0,0 +1,316 @@
+package zstoredhttp
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/mdlayher/zstore/storage"
+	"github.com/mdlayher/zstore/storage/zfsutil"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// metricsAPI is the path of the Prometheus metrics endpoint
+	metricsAPI = "/metrics"
+
+	// namespace is the namespace of all Prometheus metrics
+	namespace = "zstore"
+)
+
+// ZFS command metrics are shared by all servers, since ZFS commands are
+// observed by a single storage.ZFSObserver.
+var (
+	zfsCommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "zfs",
+		Name:      "command_duration_seconds",
+		Help:      "Duration of ZFS commands, by operation.",
+	}, []string{"op"})
+
+	zfsCommandErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "zfs",
+		Name:      "command_errors_total",
+		Help:      "Number of failed ZFS commands, by operation and error class.",
+	}, []string{"op", "class"})
+)
+
+// ObserveZFS is a storage.ZFSObserver which records the duration and error
+// class of ZFS commands in the metrics served by the zstored HTTP server.
+// Errors are classified using zfsutil.ErrorClass.
+func ObserveZFS(op string, d time.Duration, err error) {
+	zfsCommandDuration.WithLabelValues(op).Observe(d.Seconds())
+
+	if err != nil {
+		zfsCommandErrors.WithLabelValues(op, zfsutil.ErrorClass(err)).Inc()
+	}
+}
+
+// metricsMethods are the HTTP methods which are used as metrics labels.  All
+// other methods are recorded as "other", so that clients cannot create an
+// unbounded number of labels.
+var metricsMethods = map[string]struct{}{
+	"DELETE": {},
+	"GET":    {},
+	"PATCH":  {},
+	"POST":   {},
+	"PUT":    {},
+}
+
+// metrics are the Prometheus metrics for a single zstored HTTP server.
+type metrics struct {
+	registry *prometheus.Registry
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// newMetrics creates metrics for a server which serves volumes from the
+// specified pool, including the shared ZFS command metrics.
+func newMetrics(pool storage.Pool) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Number of storage API requests, by method and status code.",
+		}, []string{"method", "code"}),
+
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Duration of storage API requests, by method.",
+		}, []string{"method"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.duration,
+		zfsCommandDuration,
+		zfsCommandErrors,
+		&poolCollector{pool: pool},
+		&statsCollector{pool: pool},
+	)
+
+	return m
+}
+
+// handler returns a http.Handler which serves the metrics.
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// observeRequest records a storage API request with the specified method,
+// status code, and duration.
+func (m *metrics) observeRequest(method string, code int, d time.Duration) {
+	if _, ok := metricsMethods[method]; !ok {
+		method = "other"
+	}
+
+	m.requests.WithLabelValues(method, strconv.Itoa(code)).Inc()
+	m.duration.WithLabelValues(method).Observe(d.Seconds())
+}
+
+// statusWriter is a http.ResponseWriter which records the status code of a
+// response.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+// WriteHeader records the status code of a response before writing it.
+func (w *statusWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+var _ prometheus.Collector = &poolCollector{}
+
+// poolCollector is a prometheus.Collector which collects the live status of
+// a pool each time metrics are gathered.
+type poolCollector struct {
+	pool storage.Pool
+}
+
+var (
+	poolAllocatedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "pool", "allocated_bytes"),
+		"Number of bytes allocated in the pool.",
+		[]string{"pool"}, nil,
+	)
+
+	poolFreeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "pool", "free_bytes"),
+		"Number of free bytes in the pool, or 0 if its capacity is unlimited.",
+		[]string{"pool"}, nil,
+	)
+
+	poolSizeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "pool", "size_bytes"),
+		"Size of the pool in bytes, or 0 if its capacity is unlimited.",
+		[]string{"pool"}, nil,
+	)
+
+	poolHealthDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "pool", "health"),
+		"Health of the pool, which is always 1 for the current health.",
+		[]string{"pool", "health"}, nil,
+	)
+
+	poolVolumesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "pool", "volumes"),
+		"Number of volumes in each bucket of the pool.",
+		[]string{"pool", "bucket"}, nil,
+	)
+)
+
+// Describe implements prometheus.Collector.
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolAllocatedDesc
+	ch <- poolFreeDesc
+	ch <- poolSizeDesc
+	ch <- poolHealthDesc
+	ch <- poolVolumesDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s, err := c.pool.Status()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(poolHealthDesc, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(poolAllocatedDesc, prometheus.GaugeValue, float64(s.Allocated), s.Name)
+	ch <- prometheus.MustNewConstMetric(poolFreeDesc, prometheus.GaugeValue, float64(s.Free), s.Name)
+	ch <- prometheus.MustNewConstMetric(poolSizeDesc, prometheus.GaugeValue, float64(s.Size), s.Name)
+	ch <- prometheus.MustNewConstMetric(poolHealthDesc, prometheus.GaugeValue, 1, s.Name, s.Health)
+
+	for bucket, n := range s.Buckets {
+		ch <- prometheus.MustNewConstMetric(poolVolumesDesc, prometheus.GaugeValue, float64(n), s.Name, bucket)
+	}
+}
+
+var _ prometheus.Collector = &statsCollector{}
+
+// statsCollector is a prometheus.Collector which collects the I/O statistics
+// of a pool, its volumes, and the ARC each time metrics are gathered.  Only
+// the statistics reported by the pool are collected.
+type statsCollector struct {
+	pool storage.Pool
+}
+
+var (
+	arcHitsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "arc", "hits_total"),
+		"Number of reads served by the ARC.",
+		nil, nil,
+	)
+
+	arcMissesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "arc", "misses_total"),
+		"Number of reads not served by the ARC.",
+		nil, nil,
+	)
+
+	arcHitRatioDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "arc", "hit_ratio"),
+		"Ratio of reads served by the ARC since ZFS was loaded.",
+		nil, nil,
+	)
+
+	arcSizeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "arc", "size_bytes"),
+		"Current size of the ARC in bytes.",
+		nil, nil,
+	)
+
+	poolIODescs = newIODescs("pool", "the pool", []string{"pool"})
+
+	volumeIODescs = newIODescs("volume", "each volume", []string{"pool", "bucket", "volume"})
+)
+
+// ioDescs are the descriptors of the metrics for storage.PoolStats I/O
+// statistics.
+type ioDescs struct {
+	reads, writes, readBytes, writeBytes *prometheus.Desc
+}
+
+// newIODescs creates ioDescs in the specified subsystem, whose help text
+// describes the subject of the statistics.
+func newIODescs(subsystem string, subject string, labels []string) *ioDescs {
+	desc := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, name),
+			help+" by "+subject+".",
+			labels, nil,
+		)
+	}
+
+	return &ioDescs{
+		reads:      desc("reads_total", "Number of read operations performed"),
+		writes:     desc("writes_total", "Number of write operations performed"),
+		readBytes:  desc("read_bytes_total", "Number of bytes read"),
+		writeBytes: desc("written_bytes_total", "Number of bytes written"),
+	}
+}
+
+// describe sends the descriptors to ch.
+func (d *ioDescs) describe(ch chan<- *prometheus.Desc) {
+	ch <- d.reads
+	ch <- d.writes
+	ch <- d.readBytes
+	ch <- d.writeBytes
+}
+
+// collect sends metrics for I/O statistics with the specified label values
+// to ch.
+func (d *ioDescs) collect(ch chan<- prometheus.Metric, s *zfsutil.IOStats, labels ...string) {
+	ch <- prometheus.MustNewConstMetric(d.reads, prometheus.CounterValue, float64(s.Reads), labels...)
+	ch <- prometheus.MustNewConstMetric(d.writes, prometheus.CounterValue, float64(s.Writes), labels...)
+	ch <- prometheus.MustNewConstMetric(d.readBytes, prometheus.CounterValue, float64(s.ReadBytes), labels...)
+	ch <- prometheus.MustNewConstMetric(d.writeBytes, prometheus.CounterValue, float64(s.WriteBytes), labels...)
+}
+
+// Describe implements prometheus.Collector.
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- arcHitsDesc
+	ch <- arcMissesDesc
+	ch <- arcHitRatioDesc
+	ch <- arcSizeDesc
+
+	poolIODescs.describe(ch)
+	volumeIODescs.describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	s, err := c.pool.Stats()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(poolIODescs.reads, err)
+		return
+	}
+
+	if arc := s.ARC; arc != nil {
+		ch <- prometheus.MustNewConstMetric(arcHitsDesc, prometheus.CounterValue, float64(arc.Hits))
+		ch <- prometheus.MustNewConstMetric(arcMissesDesc, prometheus.CounterValue, float64(arc.Misses))
+		ch <- prometheus.MustNewConstMetric(arcHitRatioDesc, prometheus.GaugeValue, arc.HitRatio())
+		ch <- prometheus.MustNewConstMetric(arcSizeDesc, prometheus.GaugeValue, float64(arc.Size))
+	}
+
+	name := c.pool.Name()
+	if s.IO != nil {
+		poolIODescs.collect(ch, s.IO, name)
+	}
+
+	for volume, vs := range s.Volumes {
+		volumeIODescs.collect(ch, vs, name, path.Dir(volume), path.Base(volume))
+	}
+}
@@ -0,0 +1,182 @@
+package zstoredhttp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/zstore/storage"
+	"github.com/mdlayher/zstore/storage/zfsutil"
+
+	"gopkg.in/mistifyio/go-zfs.v2"
+)
+
+// TestMetrics verifies that storage API requests, ZFS commands, and the
+// status of the pool are reported as Prometheus metrics.
+func TestMetrics(t *testing.T) {
+	pool := storage.NewMemPool("zstore", 4*storage.GB)
+	mux := NewServeMux(pool, nil)
+
+	for _, r := range []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{method: "POST", path: "/v1/storage/foo", body: `{"size":"1G"}`},
+		{method: "POST", path: "/v1/storage/foo", body: `{"size":"1G"}`},
+		{method: "GET", path: "/v1/storage/foo"},
+		{method: "PROPFIND", path: "/v1/storage/foo"},
+	} {
+		req, err := http.NewRequest(r.method, r.path, strings.NewReader(r.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = "192.168.1.1:12345"
+
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// ZFS commands are observed by a storage.ZFSObserver
+	ObserveZFS("create_volume", 10*time.Millisecond, &zfs.Error{
+		Stderr: "out of space\n",
+	})
+
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusOK)
+	}
+
+	body, err := ioutil.ReadAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`zstore_http_requests_total{code="201",method="POST"} 1`,
+		`zstore_http_requests_total{code="409",method="POST"} 1`,
+		`zstore_http_requests_total{code="200",method="GET"} 1`,
+		`zstore_http_requests_total{code="405",method="other"} 1`,
+		`zstore_http_request_duration_seconds_count{method="POST"} 2`,
+		`zstore_zfs_command_duration_seconds_count{op="create_volume"}`,
+		`zstore_zfs_command_errors_total{class="out_of_space",op="create_volume"}`,
+		`zstore_pool_allocated_bytes{pool="zstore"} 1.073741824e+09`,
+		`zstore_pool_free_bytes{pool="zstore"} 3.221225472e+09`,
+		`zstore_pool_size_bytes{pool="zstore"} 4.294967296e+09`,
+		`zstore_pool_health{health="ONLINE",pool="zstore"} 1`,
+		`zstore_pool_volumes{bucket="zstore/` + testBucket + `",pool="zstore"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Fatalf("metric not found: %s\n%s", want, string(body))
+		}
+	}
+}
+
+// TestMetricsStats verifies that the I/O statistics of the pool, its
+// volumes, and the ARC are reported as Prometheus metrics.
+func TestMetricsStats(t *testing.T) {
+	pool := &statsPool{
+		MemPool: storage.NewMemPool("zstore", 0),
+		stats: &storage.PoolStats{
+			ARC: &zfsutil.ARCStats{
+				Hits:   900,
+				Misses: 100,
+				Size:   2 * storage.GB,
+			},
+			IO: &zfsutil.IOStats{
+				Reads:     43681,
+				ReadBytes: 1363050496,
+			},
+			Volumes: map[string]*zfsutil.IOStats{
+				"zstore/" + testBucket + "/foo": {
+					Writes:     9120,
+					WriteBytes: 597688320,
+				},
+			},
+		},
+	}
+
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	NewServeMux(pool, nil).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusOK)
+	}
+
+	body, err := ioutil.ReadAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`zstore_arc_hits_total 900`,
+		`zstore_arc_misses_total 100`,
+		`zstore_arc_hit_ratio 0.9`,
+		`zstore_arc_size_bytes 2.147483648e+09`,
+		`zstore_pool_reads_total{pool="zstore"} 43681`,
+		`zstore_pool_read_bytes_total{pool="zstore"} 1.363050496e+09`,
+		`zstore_volume_writes_total{bucket="zstore/` + testBucket + `",pool="zstore",volume="foo"} 9120`,
+		`zstore_volume_written_bytes_total{bucket="zstore/` + testBucket + `",pool="zstore",volume="foo"} 5.9768832e+08`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Fatalf("metric not found: %s\n%s", want, string(body))
+		}
+	}
+}
+
+// statsPool is a storage.Pool which reports fixed I/O statistics from Stats,
+// and from the Stats method of its volumes, or an error from its volumes.
+type statsPool struct {
+	*storage.MemPool
+	stats *storage.PoolStats
+	err   error
+}
+
+// Stats returns the fixed I/O statistics of a statsPool.
+func (p *statsPool) Stats() (*storage.PoolStats, error) {
+	return p.stats, nil
+}
+
+// Volume returns a volume from a statsPool, which reports its fixed I/O
+// statistics, or the pool's error.
+func (p *statsPool) Volume(name string) (storage.Volume, error) {
+	v, err := p.MemPool.Volume(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &statsVolume{
+		Volume: v,
+		stats:  p.stats.Volumes[name],
+		err:    p.err,
+	}, nil
+}
+
+// statsVolume is a storage.Volume which reports fixed I/O statistics, or an
+// error, from Stats.
+type statsVolume struct {
+	storage.Volume
+	stats *zfsutil.IOStats
+	err   error
+}
+
+// Stats returns the fixed I/O statistics or error of a statsVolume.
+func (v *statsVolume) Stats() (*zfsutil.IOStats, error) {
+	if v.err != nil {
+		return nil, v.err
+	}
+
+	return v.stats, nil
+}
@@ -0,0 +1,179 @@
+package zstoredhttp
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/zstore/storage"
+)
+
+const (
+	// maxTransitions is the number of recent transitions retained by a
+	// PoolMonitor
+	maxTransitions = 16
+
+	// maxStatusFailures is the number of consecutive failures to retrieve
+	// the pool's status after which a PoolMonitor considers the pool
+	// unavailable
+	maxStatusFailures = 3
+
+	// healthUnavailable is the health recorded by a PoolMonitor for a pool
+	// whose status cannot be retrieved
+	healthUnavailable = "UNAVAILABLE"
+)
+
+// Modes of the zstored HTTP API, which are reported by the pool status API.
+const (
+	// ModeNormal indicates that volumes may be created, modified, and
+	// destroyed.
+	ModeNormal = "normal"
+
+	// ModeDegraded indicates that the pool is not online, so volumes may
+	// only be retrieved.
+	ModeDegraded = "degraded"
+)
+
+// A Transition is a change in the health of a pool observed by a
+// PoolMonitor, and the resulting mode of the API.  A pool whose status cannot
+// be retrieved has the health UNAVAILABLE.
+type Transition struct {
+	Time   time.Time `json:"time"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Mode   string    `json:"mode"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// A PoolMonitor periodically checks the health of a pool.  While the pool is
+// not online, such as when it is DEGRADED or FAULTED, the zstored HTTP server
+// is placed in a read-only degraded mode: volumes and snapshots may be
+// retrieved, but all other requests are rejected with HTTP 503, until the
+// pool recovers.
+type PoolMonitor struct {
+	pool storage.Pool
+
+	mu          sync.RWMutex
+	health      string
+	reason      string
+	failures    int
+	transitions []*Transition
+}
+
+// NewPoolMonitor creates a new PoolMonitor for a pool.  The pool is assumed to
+// be online until it is first checked.
+func NewPoolMonitor(pool storage.Pool) *PoolMonitor {
+	return &PoolMonitor{
+		pool: pool,
+	}
+}
+
+// Run checks the health of the pool at the specified interval, until done is
+// closed.
+func (m *PoolMonitor) Run(interval time.Duration, done <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			m.Check()
+		case <-done:
+			return
+		}
+	}
+}
+
+// Check checks the health of the pool once, and logs and records a transition
+// if its health has changed.  If the pool's status cannot be retrieved, the
+// error is logged and the current mode is retained, unless the status has not
+// been retrieved several times in a row, in which case the pool is considered
+// unavailable, such as when it is suspended.
+func (m *PoolMonitor) Check() {
+	s, err := m.pool.Status()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var name, health, reason string
+	if err == nil {
+		m.failures = 0
+
+		name, health = s.Name, s.Health
+		if !s.Online() {
+			reason = fmt.Sprintf("pool %q is %s", name, s.Health)
+		}
+	} else {
+		m.failures++
+		log.Printf("pool monitor: failed to retrieve pool status (%d of %d): %v", m.failures, maxStatusFailures, err)
+
+		if m.failures < maxStatusFailures {
+			return
+		}
+
+		name, health = m.pool.Name(), healthUnavailable
+		reason = fmt.Sprintf("status of pool %q cannot be retrieved: %v", name, err)
+	}
+
+	// The initial health is not a transition unless the pool is not online
+	from := m.health
+	if from == "" {
+		from = health
+		if reason != "" {
+			from = "ONLINE"
+		}
+	}
+	m.health = health
+
+	if from == health {
+		return
+	}
+
+	tr := &Transition{
+		Time:   time.Now(),
+		From:   from,
+		To:     health,
+		Mode:   ModeNormal,
+		Reason: reason,
+	}
+	if reason != "" {
+		tr.Mode = ModeDegraded
+	}
+
+	m.reason = reason
+	m.transitions = append(m.transitions, tr)
+	if len(m.transitions) > maxTransitions {
+		m.transitions = m.transitions[len(m.transitions)-maxTransitions:]
+	}
+
+	log.Printf("pool monitor: pool %q health changed from %s to %s, API mode: %s", name, tr.From, tr.To, tr.Mode)
+}
+
+// Degraded determines if the API is in degraded mode, and if so, returns the
+// reason.  A nil PoolMonitor is never degraded.
+func (m *PoolMonitor) Degraded() (string, bool) {
+	if m == nil {
+		return "", false
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.reason, m.reason != ""
+}
+
+// Transitions returns the most recent transitions observed by the monitor,
+// from oldest to newest.  A nil PoolMonitor has no transitions.
+func (m *PoolMonitor) Transitions() []*Transition {
+	if m == nil {
+		return nil
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	transitions := make([]*Transition, len(m.transitions))
+	copy(transitions, m.transitions)
+	return transitions
+}
@@ -0,0 +1,203 @@
+package zstoredhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/mdlayher/zstore/storage"
+)
+
+// TestPoolMonitor verifies that the API enters a read-only degraded mode while
+// the pool is not online, and that transitions are reported by the pool
+// status API.
+func TestPoolMonitor(t *testing.T) {
+	pool := &statusPool{
+		MemPool: storage.NewMemPool("zstore", 0),
+		health:  "ONLINE",
+	}
+	monitor := NewPoolMonitor(pool)
+	config := &Config{
+		Admins:  []string{testBucket},
+		Monitor: monitor,
+	}
+
+	var tests = []struct {
+		description string
+		health      string
+		err         error
+		method      string
+		path        string
+		body        string
+		code        int
+	}{
+		{
+			description: "online, create volume",
+			health:      "ONLINE",
+			method:      "POST",
+			path:        "/v1/storage/foo",
+			body:        `{"size":"1G"}`,
+			code:        http.StatusCreated,
+		},
+		{
+			description: "degraded, get volume",
+			health:      "DEGRADED",
+			method:      "GET",
+			path:        "/v1/storage/foo",
+			code:        http.StatusOK,
+		},
+		{
+			description: "degraded, create volume",
+			health:      "DEGRADED",
+			method:      "POST",
+			path:        "/v1/storage/bar",
+			body:        `{"size":"1G"}`,
+			code:        http.StatusServiceUnavailable,
+		},
+		{
+			description: "status unavailable, mode retained",
+			err:         errors.New("no such pool"),
+			method:      "DELETE",
+			path:        "/v1/storage/foo",
+			code:        http.StatusServiceUnavailable,
+		},
+		{
+			description: "faulted, create snapshot",
+			health:      "FAULTED",
+			method:      "POST",
+			path:        "/v1/storage/foo/snapshots/snap",
+			code:        http.StatusServiceUnavailable,
+		},
+		{
+			description: "recovered, destroy volume",
+			health:      "ONLINE",
+			method:      "DELETE",
+			path:        "/v1/storage/foo",
+			code:        http.StatusNoContent,
+		},
+	}
+
+	for _, test := range tests {
+		pool.health = test.health
+		pool.err = test.err
+		monitor.Check()
+
+		w := testConfigRequest(t, pool, config, test.method, test.path, test.body)
+		if w.Code != test.code {
+			t.Fatalf("unexpected code: %v != %v [description: %s]", w.Code, test.code, test.description)
+		}
+
+		if test.code != http.StatusServiceUnavailable {
+			continue
+		}
+
+		e := testError(t, w)
+		if e.Code != ErrorCodePoolDegraded || e.Details["reason"] == "" {
+			t.Fatalf("unexpected error: %v %v [description: %s]", e.Code, e.Details, test.description)
+		}
+	}
+
+	// Transitions are reported by the pool status API
+	pool.health = "DEGRADED"
+	monitor.Check()
+
+	w := testConfigRequest(t, pool, config, "GET", "/v1/pool", "")
+	res := new(PoolResponse)
+	if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := res.Pool; p.Mode != ModeDegraded || p.Reason != `pool "zstore" is DEGRADED` {
+		t.Fatalf("unexpected mode: %v [reason: %s]", p.Mode, p.Reason)
+	}
+
+	want := []Transition{
+		{From: "ONLINE", To: "DEGRADED", Mode: ModeDegraded},
+		{From: "DEGRADED", To: "FAULTED", Mode: ModeDegraded},
+		{From: "FAULTED", To: "ONLINE", Mode: ModeNormal},
+		{From: "ONLINE", To: "DEGRADED", Mode: ModeDegraded},
+	}
+	if l := len(res.Pool.Transitions); l != len(want) {
+		t.Fatalf("unexpected number of transitions: %v != %v", l, len(want))
+	}
+	for i, tr := range res.Pool.Transitions {
+		if tr.From != want[i].From || tr.To != want[i].To || tr.Mode != want[i].Mode || tr.Time.IsZero() {
+			t.Fatalf("unexpected transition: %v != %v", *tr, want[i])
+		}
+	}
+}
+
+// TestPoolMonitorInitial verifies that a pool which is not online when it is
+// first checked places the API in degraded mode.
+func TestPoolMonitorInitial(t *testing.T) {
+	for _, health := range []string{"ONLINE", "FAULTED"} {
+		monitor := NewPoolMonitor(&statusPool{health: health})
+		monitor.Check()
+
+		_, degraded := monitor.Degraded()
+		if want := health != "ONLINE"; degraded != want {
+			t.Fatalf("unexpected degraded mode: %v != %v [health: %s]", degraded, want, health)
+		}
+		// Only a pool which is not online records a transition
+		want := 0
+		if degraded {
+			want = 1
+		}
+		if l := len(monitor.Transitions()); l != want {
+			t.Fatalf("unexpected number of transitions: %v != %v [health: %s]", l, want, health)
+		}
+	}
+}
+
+// TestPoolMonitorStatusFailures verifies that a pool whose status repeatedly
+// cannot be retrieved places the API in degraded mode until it recovers.
+func TestPoolMonitorStatusFailures(t *testing.T) {
+	pool := &statusPool{
+		MemPool: storage.NewMemPool("zstore", 0),
+		health:  "ONLINE",
+	}
+	monitor := NewPoolMonitor(pool)
+	monitor.Check()
+
+	// Isolated failures retain the current mode
+	pool.err = errors.New("pool I/O is currently suspended")
+	for i := 1; i < maxStatusFailures; i++ {
+		monitor.Check()
+
+		if _, degraded := monitor.Degraded(); degraded {
+			t.Fatalf("unexpected degraded mode after %d failures", i)
+		}
+	}
+
+	monitor.Check()
+
+	reason, degraded := monitor.Degraded()
+	if want := `status of pool "zstore" cannot be retrieved: pool I/O is currently suspended`; !degraded || reason != want {
+		t.Fatalf("unexpected degraded mode: %v [reason: %s]", degraded, reason)
+	}
+
+	// Further failures are not transitions, and a retrieved status recovers
+	// the pool
+	monitor.Check()
+	pool.err = nil
+	monitor.Check()
+
+	if _, degraded := monitor.Degraded(); degraded {
+		t.Fatal("unexpected degraded mode after recovery")
+	}
+
+	want := []Transition{
+		{From: "ONLINE", To: healthUnavailable, Mode: ModeDegraded},
+		{From: healthUnavailable, To: "ONLINE", Mode: ModeNormal},
+	}
+	trs := monitor.Transitions()
+	if l := len(trs); l != len(want) {
+		t.Fatalf("unexpected number of transitions: %v != %v", l, len(want))
+	}
+	for i, tr := range trs {
+		if tr.From != want[i].From || tr.To != want[i].To || tr.Mode != want[i].Mode {
+			t.Fatalf("unexpected transition: %v != %v", *tr, want[i])
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package zstoredhttp
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/mdlayher/zstore/storage"
+)
+
+const (
+	// poolAPI is the path of the pool status API
+	poolAPI = "/v1/pool"
+)
+
+// PoolResponse is a struct which represents a response from the pool status
+// API.
+type PoolResponse struct {
+	Pool *Pool `json:"pool"`
+}
+
+// Pool is the JSON representation of the current health and capacity of the
+// pool from which volumes are created.  Sizes are in bytes, and if the pool
+// has no limit on its capacity, Size and Free are 0.  Fragmentation is a
+// percentage, and Volumes is the number of volumes for all tenants.  If the
+// pool's status cannot be retrieved, Health is UNAVAILABLE and all of these
+// fields are empty.
+//
+// Mode is the mode of the API, which is degraded while the pool is not
+// online, and Reason describes why.  Transitions are the most recent changes
+// in the pool's health, from oldest to newest.
+type Pool struct {
+	Name          string  `json:"name"`
+	Health        string  `json:"health"`
+	Allocated     uint64  `json:"allocated"`
+	Free          uint64  `json:"free"`
+	Size          uint64  `json:"size"`
+	Fragmentation uint64  `json:"fragmentation"`
+	DedupRatio    float64 `json:"dedupratio"`
+	Volumes       int     `json:"volumes"`
+
+	Mode        string        `json:"mode"`
+	Reason      string        `json:"reason,omitempty"`
+	Transitions []*Transition `json:"transitions,omitempty"`
+}
+
+// poolHandler is a http.Handler which serves the pool status API.
+type poolHandler struct {
+	pool    storage.Pool
+	tenants TenantIdentifier
+	admins  map[string]struct{}
+	monitor *PoolMonitor
+}
+
+// ServeHTTP returns the live status of the pool to clients.  The pool is
+// shared by all tenants, so only administrators may retrieve its status.  If
+// the status cannot be retrieved but the pool is monitored, the mode of the
+// API is still returned, without the pool's capacity.
+func (h *poolHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tenant, err := h.tenants.Tenant(r)
+	if err != nil {
+		writeTenantError(w, err)
+		return
+	}
+
+	// Only administrators may view the pool, 403
+	if _, ok := h.admins[tenant]; !ok {
+		writeError(w, http.StatusForbidden, ErrorCodeForbidden, nil)
+		return
+	}
+
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, nil)
+		return
+	}
+
+	mode := ModeNormal
+	reason, degraded := h.monitor.Degraded()
+	if degraded {
+		mode = ModeDegraded
+	}
+
+	pool := &Pool{
+		Mode:        mode,
+		Reason:      reason,
+		Transitions: h.monitor.Transitions(),
+	}
+
+	s, err := h.pool.Status()
+	switch {
+	case err == nil:
+		pool.Name = s.Name
+		pool.Health = s.Health
+		pool.Allocated = s.Allocated
+		pool.Free = s.Free
+		pool.Size = s.Size
+		pool.Fragmentation = s.Fragmentation
+		pool.DedupRatio = s.DedupRatio
+		pool.Volumes = s.Volumes
+	case h.monitor != nil:
+		// The monitor still knows why the API may be degraded, so report
+		// its findings without the pool's capacity
+		log.Println(err)
+		pool.Name = h.pool.Name()
+		pool.Health = healthUnavailable
+	default:
+		log.Println(err)
+		writeError(w, http.StatusInternalServerError, ErrorCodeInternal, nil)
+		return
+	}
+
+	body, err := json.Marshal(&PoolResponse{
+		Pool: pool,
+	})
+	if err != nil {
+		log.Println(err)
+		writeError(w, http.StatusInternalServerError, ErrorCodeInternal, nil)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, body)
+}
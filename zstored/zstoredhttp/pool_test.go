@@ -0,0 +1,136 @@
+package zstoredhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/mdlayher/zstore/storage"
+)
+
+// TestPool verifies that the pool status API reports the live status of the
+// pool, and may only be used by administrators.
+func TestPool(t *testing.T) {
+	pool := storage.NewMemPool("zstore", 4*storage.GB)
+
+	var tests = []struct {
+		description string
+		method      string
+		admins      []string
+		code        int
+	}{
+		{
+			description: "no administrators",
+			method:      "GET",
+			code:        http.StatusForbidden,
+		},
+		{
+			description: "tenant is not an administrator",
+			method:      "GET",
+			admins:      []string{ipTenant("192.168.1.2")},
+			code:        http.StatusForbidden,
+		},
+		{
+			description: "pool is read-only",
+			method:      "POST",
+			admins:      []string{testBucket},
+			code:        http.StatusMethodNotAllowed,
+		},
+		{
+			description: "tenant is an administrator",
+			method:      "GET",
+			admins:      []string{testBucket},
+			code:        http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		config := &Config{
+			Admins: test.admins,
+		}
+
+		w := testConfigRequest(t, pool, config, test.method, "/v1/pool", "")
+		if w.Code != test.code {
+			t.Fatalf("unexpected code: %v != %v [description: %s]", w.Code, test.code, test.description)
+		}
+	}
+
+	// Status is live, so volumes created after the server starts are counted
+	config := &Config{
+		Admins: []string{testBucket},
+	}
+	if w := testConfigRequest(t, pool, config, "POST", "/v1/storage/foo", `{"size":"1G"}`); w.Code != http.StatusCreated {
+		t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusCreated)
+	}
+
+	w := testConfigRequest(t, pool, config, "GET", "/v1/pool", "")
+	res := new(PoolResponse)
+	if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+
+	want := Pool{
+		Name:       "zstore",
+		Health:     "ONLINE",
+		Allocated:  1 * storage.GB,
+		Free:       3 * storage.GB,
+		Size:       4 * storage.GB,
+		DedupRatio: 1,
+		Volumes:    1,
+		Mode:       ModeNormal,
+	}
+	if !reflect.DeepEqual(*res.Pool, want) {
+		t.Fatalf("unexpected pool: %v != %v", *res.Pool, want)
+	}
+}
+
+// TestPoolStatusUnavailable verifies that the pool status API reports the
+// mode of a monitored API when the pool's status cannot be retrieved.
+func TestPoolStatusUnavailable(t *testing.T) {
+	pool := &statusPool{
+		MemPool: storage.NewMemPool("zstore", 4*storage.GB),
+		err:     errors.New("pool I/O is currently suspended"),
+	}
+
+	// Without a monitor, nothing is known about the pool
+	config := &Config{
+		Admins: []string{testBucket},
+	}
+	if w := testConfigRequest(t, pool, config, "GET", "/v1/pool", ""); w.Code != http.StatusInternalServerError {
+		t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusInternalServerError)
+	}
+
+	config.Monitor = NewPoolMonitor(pool)
+	for i := 0; i < maxStatusFailures; i++ {
+		config.Monitor.Check()
+	}
+
+	w := testConfigRequest(t, pool, config, "GET", "/v1/pool", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusOK)
+	}
+
+	res := new(PoolResponse)
+	if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+
+	// Transition times lose precision in JSON, so check them separately
+	trs := res.Pool.Transitions
+	if len(trs) != 1 || trs[0].To != healthUnavailable {
+		t.Fatalf("unexpected transitions: %v", trs)
+	}
+	res.Pool.Transitions = nil
+
+	want := Pool{
+		Name:   "zstore",
+		Health: healthUnavailable,
+		Mode:   ModeDegraded,
+		Reason: `status of pool "zstore" cannot be retrieved: pool I/O is currently suspended`,
+	}
+	if !reflect.DeepEqual(*res.Pool, want) {
+		t.Fatalf("unexpected pool: %v != %v", *res.Pool, want)
+	}
+}
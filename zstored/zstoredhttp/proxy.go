@@ -0,0 +1,179 @@
+package zstoredhttp
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// errInvalidProxyHeader is returned when a connection from a trusted
+	// proxy does not begin with a valid PROXY protocol header.
+	errInvalidProxyHeader = errors.New("invalid PROXY protocol header")
+)
+
+const (
+	// proxyHeaderMax is the maximum length of a PROXY protocol version 1
+	// header, including its trailing CRLF
+	proxyHeaderMax = 107
+
+	// proxyHeaderTimeout is the amount of time a trusted proxy has to send
+	// its PROXY protocol header
+	proxyHeaderTimeout = 10 * time.Second
+)
+
+// NewProxyListener wraps a net.Listener so that connections from proxies in
+// the trusted networks must begin with a PROXY protocol version 1 header, as
+// sent by HAProxy with 'send-proxy'.  The RemoteAddr of such connections is
+// the client address from the header, so the RemoteIP TenantIdentifier sees
+// the original client; if the header is 'PROXY UNKNOWN', no tenant is
+// identified.  Connections from other addresses are unchanged.
+func NewProxyListener(l net.Listener, trusted []*net.IPNet) net.Listener {
+	return &proxyListener{
+		Listener: l,
+		trusted:  trusted,
+	}
+}
+
+// proxyListener is a net.Listener which wraps connections from trusted proxies
+// in proxyConns.
+type proxyListener struct {
+	net.Listener
+	trusted []*net.IPNet
+}
+
+// Accept waits for and returns the next connection to the listener.  The
+// PROXY protocol header is not read until the connection is first used, so
+// a slow proxy cannot block other connections from being accepted.
+func (l *proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	host, _, err := net.SplitHostPort(c.RemoteAddr().String())
+	if err != nil || !trustedIP(l.trusted, net.ParseIP(host)) {
+		return c, err
+	}
+
+	return &proxyConn{
+		Conn: c,
+		r:    bufio.NewReaderSize(c, proxyHeaderMax),
+	}, nil
+}
+
+// proxyConn is a net.Conn which reads a PROXY protocol header before any
+// other data, and reports the client address from the header.
+type proxyConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+// Read reads data from the connection, following the PROXY protocol header.
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the client address from the PROXY protocol header, or
+// unknownClient if the header did not specify a client.  The proxy's address
+// is only returned if the header could not be read, and the connection is
+// closed.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote == nil {
+		return c.Conn.RemoteAddr()
+	}
+
+	return c.remote
+}
+
+// unknownClient is the net.Addr of a connection whose trusted proxy did not
+// specify a client with 'PROXY UNKNOWN'.  Proxies do not have tenants of their
+// own, so such connections are never identified as the proxy.
+var unknownClient net.Addr = proxyAddr("unknown")
+
+// proxyAddr is a net.Addr which is not a network address.
+type proxyAddr string
+
+// Network returns the name of the network.
+func (proxyAddr) Network() string { return "proxy" }
+
+// String returns the string form of the address.
+func (a proxyAddr) String() string { return string(a) }
+
+// readHeader reads and parses the PROXY protocol header from the connection.
+// If the header is invalid, the connection is closed.
+func (c *proxyConn) readHeader() {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout)); err != nil {
+		c.err = err
+		return
+	}
+
+	c.remote, c.err = parseProxyHeader(c.r)
+	if c.err != nil {
+		c.Conn.Close()
+		return
+	}
+
+	c.err = c.Conn.SetReadDeadline(time.Time{})
+}
+
+// parseProxyHeader parses a PROXY protocol version 1 header, returning the
+// client address it specifies.  For 'PROXY UNKNOWN', unknownClient is
+// returned.
+func parseProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	// Header must fit in the reader's buffer, so a missing CRLF will
+	// produce an error rather than consuming the connection
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return nil, errInvalidProxyHeader
+		}
+
+		return nil, err
+	}
+
+	if !strings.HasSuffix(string(line), "\r\n") {
+		return nil, errInvalidProxyHeader
+	}
+
+	// PROXY TCP4|TCP6 srcip dstip srcport dstport, or PROXY UNKNOWN ...
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, errInvalidProxyHeader
+	}
+	if fields[1] == "UNKNOWN" {
+		return unknownClient, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errInvalidProxyHeader
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, errInvalidProxyHeader
+	}
+
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, errInvalidProxyHeader
+	}
+
+	return &net.TCPAddr{
+		IP:   ip,
+		Port: int(port),
+	}, nil
+}
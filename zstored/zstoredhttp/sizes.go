@@ -0,0 +1,84 @@
+package zstoredhttp
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/mdlayher/zstore/storage"
+)
+
+const (
+	// sizesAPI is the path of the size slug catalog API
+	sizesAPI = "/v1/sizes"
+)
+
+// SizesResponse is a struct which represents a response from the size slug
+// catalog API.  Sizes are ordered from smallest to largest.
+type SizesResponse struct {
+	Sizes []*Size `json:"sizes"`
+}
+
+// Size is the JSON representation of a size slug, which may be used to create
+// or resize a volume, and its size in bytes.  Fits reports whether the pool
+// currently has enough free space to create a volume of this size.
+type Size struct {
+	Slug string `json:"slug"`
+	Size uint64 `json:"size"`
+	Fits bool   `json:"fits"`
+}
+
+// sizesHandler is a http.Handler which serves the size slug catalog.
+type sizesHandler struct {
+	pool    storage.Pool
+	catalog *storage.SlugCatalog
+}
+
+// ServeHTTP returns the size slug catalog to clients.  The catalog is not
+// specific to any tenant, so clients need not be identified.
+func (h *sizesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, nil)
+		return
+	}
+
+	sizes, err := catalogSizes(h.pool, h.catalog)
+	if err != nil {
+		log.Println(err)
+		writeError(w, http.StatusInternalServerError, ErrorCodeInternal, nil)
+		return
+	}
+
+	body, err := json.Marshal(&SizesResponse{
+		Sizes: sizes,
+	})
+	if err != nil {
+		log.Println(err)
+		writeError(w, http.StatusInternalServerError, ErrorCodeInternal, nil)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, body)
+}
+
+// catalogSizes returns the sizes of all size slugs in a catalog, and whether
+// each of them fits in the free space of a pool.
+func catalogSizes(pool storage.Pool, catalog *storage.SlugCatalog) ([]*Size, error) {
+	free, err := pool.Free()
+	if err != nil {
+		return nil, err
+	}
+
+	slugs := catalog.Slugs()
+	sizes := make([]*Size, 0, len(slugs))
+	for _, s := range slugs {
+		size, _ := catalog.SlugSize(s)
+		sizes = append(sizes, &Size{
+			Slug: s,
+			Size: uint64(size),
+			Fits: uint64(size) <= free,
+		})
+	}
+
+	return sizes, nil
+}
@@ -0,0 +1,212 @@
+package zstoredhttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/mdlayher/zstore/storage"
+)
+
+// TestSizes verifies that the size slug catalog can be retrieved, and that a
+// configured catalog is used when creating volumes.
+func TestSizes(t *testing.T) {
+	catalog, err := storage.NewSlugCatalog([]string{"16G", "1G"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := storage.NewMemPool("zstore", 0)
+	config := &Config{
+		Sizes: catalog,
+	}
+
+	w := testConfigRequest(t, pool, config, "GET", "/v1/sizes", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusOK)
+	}
+
+	res := new(SizesResponse)
+	if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Size{
+		{Slug: "1G", Size: 1 * storage.GB, Fits: true},
+		{Slug: "16G", Size: 16 * storage.GB, Fits: true},
+	}
+	if len(res.Sizes) != len(want) {
+		t.Fatalf("unexpected number of sizes: %v != %v", len(res.Sizes), len(want))
+	}
+	for i := range want {
+		if *res.Sizes[i] != want[i] {
+			t.Fatalf("unexpected size: %v != %v", *res.Sizes[i], want[i])
+		}
+	}
+
+	// Sizes which do not fit in the pool's free space are reported
+	small := storage.NewMemPool("zstore", 8*storage.GB)
+	w = testConfigRequest(t, small, config, "GET", "/v1/sizes", "")
+
+	res = new(SizesResponse)
+	if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+	if !res.Sizes[0].Fits || res.Sizes[1].Fits {
+		t.Fatalf("unexpected fits: %v, %v", res.Sizes[0].Fits, res.Sizes[1].Fits)
+	}
+
+	// Catalog is read-only
+	w = testConfigRequest(t, pool, config, "POST", "/v1/sizes", `{"slug":"32G"}`)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusMethodNotAllowed)
+	}
+
+	// Only slugs in the configured catalog may be used
+	var tests = []struct {
+		size string
+		code int
+	}{
+		{size: "256M", code: http.StatusBadRequest},
+		{size: "16G", code: http.StatusCreated},
+	}
+
+	for _, test := range tests {
+		w := testConfigRequest(t, pool, config, "POST", "/v1/storage/foo", `{"size":"`+test.size+`"}`)
+		if w.Code != test.code {
+			t.Fatalf("unexpected code: %v != %v [size: %s]", w.Code, test.code, test.size)
+		}
+	}
+}
+
+// TestExactSizes verifies that exact sizes may be used when permitted, and
+// that they are rounded and bounded by the configured limits.
+func TestExactSizes(t *testing.T) {
+	pool := storage.NewMemPool("zstore", 0)
+	config := &Config{
+		ExactSizes: &SizeLimits{
+			Min: 1 * storage.GB,
+			Max: 10 * storage.GB,
+		},
+		Classes: []*storage.Class{
+			{Name: "archive", Sizes: []string{"8G"}},
+			{Name: "db", Properties: map[string]string{"volblocksize": "16384"}},
+		},
+	}
+
+	var tests = []struct {
+		description string
+		method      string
+		path        string
+		body        string
+		code        int
+		size        uint64
+	}{
+		{
+			description: "exact size",
+			method:      "POST",
+			path:        "/v1/storage/foo",
+			body:        `{"size":"1.5G"}`,
+			code:        http.StatusCreated,
+			size:        1536 * storage.MB,
+		},
+		{
+			description: "slug below minimum exact size",
+			method:      "POST",
+			path:        "/v1/storage/bar",
+			body:        `{"size":"256M"}`,
+			code:        http.StatusCreated,
+			size:        256 * storage.MB,
+		},
+		{
+			description: "exact size rounded to default block size",
+			method:      "POST",
+			path:        "/v1/storage/baz",
+			body:        `{"size":"1073741825"}`,
+			code:        http.StatusCreated,
+			size:        1*storage.GB + 128*1024,
+		},
+		{
+			description: "exact size rounded to block size option",
+			method:      "POST",
+			path:        "/v1/storage/qux",
+			body:        `{"size":"1073741825","options":{"volblocksize":"4096"}}`,
+			code:        http.StatusCreated,
+			size:        1*storage.GB + 4096,
+		},
+		{
+			description: "exact size rounded to storage class block size",
+			method:      "POST",
+			path:        "/v1/storage/db",
+			body:        `{"size":"1073741825","class":"db"}`,
+			code:        http.StatusCreated,
+			size:        1*storage.GB + 16384,
+		},
+		{
+			description: "resize rounded to volume block size",
+			method:      "PUT",
+			path:        "/v1/storage/qux",
+			body:        `{"size":"2147483649"}`,
+			code:        http.StatusOK,
+			size:        2*storage.GB + 4096,
+		},
+		{
+			description: "exact size below minimum",
+			method:      "POST",
+			path:        "/v1/storage/small",
+			body:        `{"size":"768M"}`,
+			code:        http.StatusBadRequest,
+		},
+		{
+			description: "exact size above maximum",
+			method:      "POST",
+			path:        "/v1/storage/large",
+			body:        `{"size":"10.5G"}`,
+			code:        http.StatusBadRequest,
+		},
+		{
+			description: "unparseable size",
+			method:      "POST",
+			path:        "/v1/storage/large",
+			body:        `{"size":"lots"}`,
+			code:        http.StatusBadRequest,
+		},
+		{
+			description: "exact size with storage class which limits sizes",
+			method:      "POST",
+			path:        "/v1/storage/archive",
+			body:        `{"size":"1.5G","class":"archive"}`,
+			code:        http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		w := testConfigRequest(t, pool, config, test.method, test.path, test.body)
+		if w.Code != test.code {
+			t.Fatalf("unexpected code: %v != %v [description: %s]", w.Code, test.code, test.description)
+		}
+
+		if test.code != http.StatusBadRequest {
+			res := new(StorageResponse)
+			if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+				t.Fatal(err)
+			}
+			if v := res.Volumes[0]; v.Size != test.size {
+				t.Fatalf("unexpected size: %v != %v [description: %s]", v.Size, test.size, test.description)
+			}
+
+			continue
+		}
+
+		e := testError(t, w)
+		if e.Code != ErrorCodeInvalidSize {
+			t.Fatalf("unexpected error code: %v != %v [description: %s]", e.Code, ErrorCodeInvalidSize, test.description)
+		}
+	}
+
+	// Limits of exact sizes are reported with invalid sizes
+	w := testConfigRequest(t, pool, config, "POST", "/v1/storage/small", `{"size":"1K"}`)
+	if e := testError(t, w); e.Details["min"] != float64(1*storage.GB) || e.Details["max"] != float64(10*storage.GB) {
+		t.Fatalf("unexpected details: %v", e.Details)
+	}
+}
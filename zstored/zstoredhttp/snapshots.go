@@ -0,0 +1,261 @@
+package zstoredhttp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mdlayher/zstore/storage"
+)
+
+const (
+	// snapshotsPath is the path component which follows a volume name to
+	// access the volume's snapshots
+	snapshotsPath = "snapshots"
+
+	// rollbackPath is the path component which follows a snapshot name to
+	// roll back a volume to that snapshot
+	rollbackPath = "rollback"
+)
+
+// RollbackRequest is a struct which represents a valid request to roll back
+// a volume to a snapshot.  If DestroyNewer is true, any snapshots newer than
+// the target snapshot are destroyed; otherwise, the rollback fails if any
+// newer snapshots exist.
+type RollbackRequest struct {
+	DestroyNewer bool `json:"destroy_newer"`
+}
+
+// SnapshotResponse is a struct which represents a response from the
+// snapshot API.
+type SnapshotResponse struct {
+	Snapshots []*Snapshot `json:"snapshots"`
+}
+
+// Snapshot is the JSON representation of a point-in-time snapshot of a block
+// storage volume.
+type Snapshot struct {
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	Used    uint64    `json:"used"`
+}
+
+// destroySnapshot is a StorageHandlerFunc which destroys a single snapshot of
+// a volume via the HTTP server.
+func (c *StorageContext) destroySnapshot(name string, r *http.Request) (int, []byte, error) {
+	// Ensure request name is bucketed to pool, unique hash, and volume name,
+	// and that a snapshot name is present
+	snapshot := snapshotName(r)
+	if len(strings.Split(name, "/")) != 3 || snapshot == "" {
+		return errorResponse(http.StatusNotFound, ErrorCodeNotFound, nil)
+	}
+
+	// Check for a volume with the specified name
+	volume, err := c.pool.Volume(name)
+	if err != nil {
+		// If volume does not exist, 404
+		if err == storage.ErrVolumeNotExists {
+			return errorResponse(http.StatusNotFound, ErrorCodeVolumeNotFound, nil)
+		}
+
+		// Any other errors
+		return http.StatusInternalServerError, nil, err
+	}
+
+	// Destroy the snapshot
+	if err := volume.DestroySnapshot(snapshot); err != nil {
+		switch err {
+		// If snapshot name is invalid, 400
+		case storage.ErrInvalidSnapshotName:
+			return errorResponse(http.StatusBadRequest, ErrorCodeInvalidSnapshotName, nil)
+		// If snapshot does not exist, 404
+		case storage.ErrSnapshotNotExists:
+			return errorResponse(http.StatusNotFound, ErrorCodeSnapshotNotFound, nil)
+		// If other volumes were cloned from snapshot, 409
+		case storage.ErrDependentClones:
+			return errorResponse(http.StatusConflict, ErrorCodeDependentClones, nil)
+		}
+
+		// Any other errors
+		return http.StatusInternalServerError, nil, err
+	}
+
+	// Return HTTP 204 on success
+	return http.StatusNoContent, nil, nil
+}
+
+// getSnapshotHandler is a StorageHandlerFunc which returns metadata for all
+// snapshots of a volume, or a single snapshot if one is named, from the HTTP
+// server.
+func (c *StorageContext) getSnapshotHandler(name string, r *http.Request) (int, []byte, error) {
+	// Ensure request name is bucketed to pool, unique hash, and volume name
+	if len(strings.Split(name, "/")) != 3 {
+		return errorResponse(http.StatusNotFound, ErrorCodeNotFound, nil)
+	}
+
+	// Check for a volume with the specified name
+	volume, err := c.pool.Volume(name)
+	if err != nil {
+		// If volume does not exist, 404
+		if err == storage.ErrVolumeNotExists {
+			return errorResponse(http.StatusNotFound, ErrorCodeVolumeNotFound, nil)
+		}
+
+		// Any other errors
+		return http.StatusInternalServerError, nil, err
+	}
+
+	// Retrieve all snapshots for this volume
+	snapshots, err := volume.Snapshots()
+	if err != nil {
+		return http.StatusInternalServerError, nil, err
+	}
+
+	// Wrap all snapshots in output format, filtering to a single snapshot
+	// if one is named
+	snapshot := snapshotName(r)
+	out := make([]*Snapshot, 0, len(snapshots))
+	for _, s := range snapshots {
+		if snapshot != "" && s.Name != snapshot {
+			continue
+		}
+
+		out = append(out, &Snapshot{
+			Name:    s.Name,
+			Created: s.Created,
+			Used:    s.Used,
+		})
+	}
+
+	// If a single snapshot was named but not found, 404
+	if snapshot != "" && len(out) == 0 {
+		return errorResponse(http.StatusNotFound, ErrorCodeSnapshotNotFound, nil)
+	}
+
+	// Return JSON representation of snapshots
+	body, err := json.Marshal(&SnapshotResponse{
+		Snapshots: out,
+	})
+	return http.StatusOK, body, err
+}
+
+// createSnapshot is a StorageHandlerFunc which creates a new snapshot of a
+// volume via the HTTP server.
+func (c *StorageContext) createSnapshot(name string, r *http.Request) (int, []byte, error) {
+	// Ensure request name is bucketed to pool, unique hash, and volume name,
+	// and that a snapshot name is present
+	snapshot := snapshotName(r)
+	if len(strings.Split(name, "/")) != 3 || snapshot == "" {
+		return errorResponse(http.StatusNotFound, ErrorCodeNotFound, nil)
+	}
+
+	// Check for a volume with the specified name
+	volume, err := c.pool.Volume(name)
+	if err != nil {
+		// If volume does not exist, 404
+		if err == storage.ErrVolumeNotExists {
+			return errorResponse(http.StatusNotFound, ErrorCodeVolumeNotFound, nil)
+		}
+
+		// Any other errors
+		return http.StatusInternalServerError, nil, err
+	}
+
+	// Create the snapshot with the specified name
+	s, err := volume.CreateSnapshot(snapshot)
+	if err != nil {
+		switch err {
+		// If snapshot name is invalid, 400
+		case storage.ErrInvalidSnapshotName:
+			return errorResponse(http.StatusBadRequest, ErrorCodeInvalidSnapshotName, nil)
+		// If snapshot already exists, 409
+		case storage.ErrSnapshotExists:
+			return errorResponse(http.StatusConflict, ErrorCodeSnapshotExists, nil)
+		// If pool cannot store the snapshot, 503
+		case storage.ErrPoolOutOfSpace:
+			return c.outOfSpaceResponse(0)
+		}
+
+		// Any other errors
+		return http.StatusInternalServerError, nil, err
+	}
+
+	// Return JSON representation of snapshot
+	body, err := json.Marshal(&SnapshotResponse{
+		Snapshots: []*Snapshot{
+			&Snapshot{
+				Name:    s.Name,
+				Created: s.Created,
+				Used:    s.Used,
+			},
+		},
+	})
+	return http.StatusCreated, body, err
+}
+
+// rollbackSnapshot is a StorageHandlerFunc which rolls back a volume to one
+// of its snapshots via the HTTP server.
+func (c *StorageContext) rollbackSnapshot(name string, r *http.Request) (int, []byte, error) {
+	// Ensure request name is bucketed to pool, unique hash, and volume name,
+	// and that a snapshot name is present
+	snapshot := snapshotName(r)
+	if len(strings.Split(name, "/")) != 3 || snapshot == "" {
+		return errorResponse(http.StatusNotFound, ErrorCodeNotFound, nil)
+	}
+
+	// Decode HTTP request body into RollbackRequest; the body is optional,
+	// and newer snapshots are preserved by default
+	rr := new(RollbackRequest)
+	if err := json.NewDecoder(r.Body).Decode(rr); err != nil && err != io.EOF {
+		return errorResponse(http.StatusBadRequest, ErrorCodeInvalidRequest, nil)
+	}
+
+	// Check for a volume with the specified name
+	volume, err := c.pool.Volume(name)
+	if err != nil {
+		// If volume does not exist, 404
+		if err == storage.ErrVolumeNotExists {
+			return errorResponse(http.StatusNotFound, ErrorCodeVolumeNotFound, nil)
+		}
+
+		// Any other errors
+		return http.StatusInternalServerError, nil, err
+	}
+
+	// Roll back the volume to the snapshot
+	if err := volume.Rollback(snapshot, rr.DestroyNewer); err != nil {
+		switch err {
+		// If snapshot name is invalid, 400
+		case storage.ErrInvalidSnapshotName:
+			return errorResponse(http.StatusBadRequest, ErrorCodeInvalidSnapshotName, nil)
+		// If snapshot does not exist, 404
+		case storage.ErrSnapshotNotExists:
+			return errorResponse(http.StatusNotFound, ErrorCodeSnapshotNotFound, nil)
+		// If newer snapshots exist and may not be destroyed, 409
+		case storage.ErrNewerSnapshotsExist:
+			return errorResponse(http.StatusConflict, ErrorCodeNewerSnapshotsExist, nil)
+		// If other volumes were cloned from newer snapshots, 409
+		case storage.ErrDependentClones:
+			return errorResponse(http.StatusConflict, ErrorCodeDependentClones, nil)
+		}
+
+		// Any other errors
+		return http.StatusInternalServerError, nil, err
+	}
+
+	// Return HTTP 204 on success
+	return http.StatusNoContent, nil, nil
+}
+
+// snapshotName returns the snapshot name from a HTTP request to the snapshot
+// API, or an empty string if no snapshot is named.
+func snapshotName(r *http.Request) string {
+	p := storagePath(r)
+	if len(p) < 3 {
+		return ""
+	}
+
+	return p[2]
+}
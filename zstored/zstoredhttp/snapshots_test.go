@@ -0,0 +1,194 @@
+package zstoredhttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/mdlayher/zstore/storage"
+)
+
+// TestSnapshots verifies that snapshots of a volume can be created, listed,
+// and destroyed through the snapshot API.
+func TestSnapshots(t *testing.T) {
+	pool := storage.NewMemPool("zstore", 0)
+
+	w := testStorageRequest(t, pool, "POST", "/v1/storage/foo", `{"size":"256M"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusCreated)
+	}
+
+	var tests = []struct {
+		description string
+		method      string
+		path        string
+		code        int
+	}{
+		{
+			description: "snapshot of unknown volume",
+			method:      "POST",
+			path:        "/v1/storage/bar/snapshots/one",
+			code:        http.StatusNotFound,
+		},
+		{
+			description: "snapshot without name",
+			method:      "POST",
+			path:        "/v1/storage/foo/snapshots",
+			code:        http.StatusNotFound,
+		},
+		{
+			description: "snapshot with invalid name",
+			method:      "POST",
+			path:        "/v1/storage/foo/snapshots/o@ne",
+			code:        http.StatusBadRequest,
+		},
+		{
+			description: "create snapshot",
+			method:      "POST",
+			path:        "/v1/storage/foo/snapshots/one",
+			code:        http.StatusCreated,
+		},
+		{
+			description: "snapshot already exists",
+			method:      "POST",
+			path:        "/v1/storage/foo/snapshots/one",
+			code:        http.StatusConflict,
+		},
+		{
+			description: "create second snapshot",
+			method:      "POST",
+			path:        "/v1/storage/foo/snapshots/two",
+			code:        http.StatusCreated,
+		},
+		{
+			description: "get single snapshot",
+			method:      "GET",
+			path:        "/v1/storage/foo/snapshots/two",
+			code:        http.StatusOK,
+		},
+		{
+			description: "get unknown snapshot",
+			method:      "GET",
+			path:        "/v1/storage/foo/snapshots/three",
+			code:        http.StatusNotFound,
+		},
+		{
+			description: "destroy snapshot",
+			method:      "DELETE",
+			path:        "/v1/storage/foo/snapshots/one",
+			code:        http.StatusNoContent,
+		},
+		{
+			description: "destroy unknown snapshot",
+			method:      "DELETE",
+			path:        "/v1/storage/foo/snapshots/one",
+			code:        http.StatusNotFound,
+		},
+		{
+			description: "unknown volume subresource",
+			method:      "GET",
+			path:        "/v1/storage/foo/bar",
+			code:        http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		w := testStorageRequest(t, pool, test.method, test.path, "")
+		if w.Code != test.code {
+			t.Fatalf("unexpected code: %v != %v [description: %s]", w.Code, test.code, test.description)
+		}
+	}
+
+	// Only the second snapshot should remain
+	w = testStorageRequest(t, pool, "GET", "/v1/storage/foo/snapshots", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusOK)
+	}
+
+	res := new(SnapshotResponse)
+	if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Snapshots) != 1 || res.Snapshots[0].Name != "two" {
+		t.Fatalf("unexpected snapshots: %v", res.Snapshots)
+	}
+}
+
+// TestSnapshotRollback verifies that a volume can be rolled back to one of
+// its snapshots through the snapshot API, and that newer snapshots are only
+// destroyed when requested.
+func TestSnapshotRollback(t *testing.T) {
+	pool := storage.NewMemPool("zstore", 0)
+
+	w := testStorageRequest(t, pool, "POST", "/v1/storage/foo", `{"size":"256M"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusCreated)
+	}
+
+	for _, name := range []string{"one", "two"} {
+		w := testStorageRequest(t, pool, "POST", "/v1/storage/foo/snapshots/"+name, "")
+		if w.Code != http.StatusCreated {
+			t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusCreated)
+		}
+	}
+
+	var tests = []struct {
+		description string
+		method      string
+		path        string
+		body        string
+		code        int
+	}{
+		{
+			description: "wrong method",
+			method:      "GET",
+			path:        "/v1/storage/foo/snapshots/one/rollback",
+			code:        http.StatusMethodNotAllowed,
+		},
+		{
+			description: "unknown snapshot",
+			method:      "POST",
+			path:        "/v1/storage/foo/snapshots/three/rollback",
+			code:        http.StatusNotFound,
+		},
+		{
+			description: "invalid request body",
+			method:      "POST",
+			path:        "/v1/storage/foo/snapshots/one/rollback",
+			body:        `{`,
+			code:        http.StatusBadRequest,
+		},
+		{
+			description: "newer snapshots exist",
+			method:      "POST",
+			path:        "/v1/storage/foo/snapshots/one/rollback",
+			code:        http.StatusConflict,
+		},
+		{
+			description: "most recent snapshot",
+			method:      "POST",
+			path:        "/v1/storage/foo/snapshots/two/rollback",
+			code:        http.StatusNoContent,
+		},
+		{
+			description: "destroy newer snapshots",
+			method:      "POST",
+			path:        "/v1/storage/foo/snapshots/one/rollback",
+			body:        `{"destroy_newer":true}`,
+			code:        http.StatusNoContent,
+		},
+		{
+			description: "newer snapshot destroyed",
+			method:      "GET",
+			path:        "/v1/storage/foo/snapshots/two",
+			code:        http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		w := testStorageRequest(t, pool, test.method, test.path, test.body)
+		if w.Code != test.code {
+			t.Fatalf("unexpected code: %v != %v [description: %s]", w.Code, test.code, test.description)
+		}
+	}
+}
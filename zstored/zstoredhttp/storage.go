@@ -1,17 +1,17 @@
 package zstoredhttp
 
 import (
-	"crypto/md5"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"log"
-	"net"
+	"math"
 	"net/http"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/mdlayher/zstore/storage"
 )
@@ -23,9 +23,34 @@ var (
 )
 
 // StorageRequest is a struct which represents a valid request to
-// the storage API.
+// the storage API.  Size is a size slug, or if the server permits exact
+// sizes, any size accepted by storage.ParseSize.  If Source is set, the
+// volume is cloned from an existing snapshot, and Size must be empty.  When
+// resizing a volume, Force must be set to permit shrinking it, which may
+// destroy data.
+//
+// Options and Class may only be set when creating a new volume.  Options
+// select ZFS properties of the volume; see storage.ParseVolumeOptions for the
+// options which are permitted.  Class selects a storage class configured on
+// the server, which sets ZFS properties and limits the sizes of the volume.
+//
+// When updating a volume, Size may be empty if Labels are set.  A PUT
+// request replaces all labels of a volume, while a PATCH request merges
+// Labels into the existing labels, removing any labels with empty values.
 type StorageRequest struct {
-	Size string `json:"size"`
+	Size    string            `json:"size,omitempty"`
+	Class   string            `json:"class,omitempty"`
+	Source  *StorageSource    `json:"source,omitempty"`
+	Force   bool              `json:"force,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// StorageSource identifies an existing volume and one of its snapshots,
+// from which a new volume may be cloned.
+type StorageSource struct {
+	Volume   string `json:"volume"`
+	Snapshot string `json:"snapshot"`
 }
 
 // StorageResponse is a struct which represents a response from the
@@ -34,10 +59,38 @@ type StorageResponse struct {
 	Volumes []*Volume `json:"volumes"`
 }
 
-// Volume is the JSON representation of a block storage volume.
+// Volume is the JSON representation of a block storage volume.  If the
+// volume is a clone, Origin is the name of the volume and snapshot it was
+// cloned from, in 'volume@snapshot' form.
+//
+// Used is the number of bytes consumed by the volume and its snapshots, and
+// Referenced is the number of bytes consumed by the volume's current data.
+// Device is the path of the volume's device on the storage host, and Class
+// is the name of the volume's storage class, if it has one.  Stats are the
+// volume's I/O statistics, which are only reported when a single volume is
+// retrieved, and only for volumes which track them.
 type Volume struct {
-	Name string `json:"name"`
-	Size uint64 `json:"size"`
+	Name          string            `json:"name"`
+	Size          uint64            `json:"size"`
+	Used          uint64            `json:"used"`
+	Referenced    uint64            `json:"referenced"`
+	CompressRatio float64           `json:"compressratio"`
+	Created       time.Time         `json:"created"`
+	Device        string            `json:"device,omitempty"`
+	Class         string            `json:"class,omitempty"`
+	Origin        string            `json:"origin,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	Stats         *VolumeStats      `json:"stats,omitempty"`
+}
+
+// VolumeStats are the number of read and write operations performed by a
+// volume, and the number of bytes it has read and written, since it was last
+// opened by the storage host.
+type VolumeStats struct {
+	Reads      uint64 `json:"reads"`
+	Writes     uint64 `json:"writes"`
+	ReadBytes  uint64 `json:"read_bytes"`
+	WriteBytes uint64 `json:"write_bytes"`
 }
 
 // StorageHandlerFunc is a function which accepts a volume name and HTTP
@@ -47,30 +100,76 @@ type StorageHandlerFunc func(string, *http.Request) (int, []byte, error)
 // StorageContext provides shared members required for zstored storage
 // HTTP handlers.
 type StorageContext struct {
-	pool storage.Pool
+	pool       storage.Pool
+	tenants    TenantIdentifier
+	catalog    *storage.SlugCatalog
+	exactSizes *SizeLimits
+	classes    map[string]*storage.Class
+	metrics    *metrics
+	monitor    *PoolMonitor
 }
 
-// ServeHTTP delegates requests to the Context to the correct handlers.
+// ServeHTTP delegates requests to the Context to the correct handlers, and
+// records metrics for each request.
 func (c *StorageContext) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	sw := &statusWriter{
+		ResponseWriter: w,
+		code:           http.StatusOK,
+	}
+
+	c.serveHTTP(sw, r)
+	c.metrics.observeRequest(r.Method, sw.code, time.Since(start))
+}
+
+// serveHTTP delegates requests to the Context to the correct handlers.
+func (c *StorageContext) serveHTTP(w http.ResponseWriter, r *http.Request) {
 	// Generate volume name based upon information from input HTTP request
 	name, err := c.volumeName(r)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		writeTenantError(w, err)
 		return
 	}
 
-	// Map of HTTP methods to the appropriate StorageHandlerFunc
-	methodFnMap := map[string]StorageHandlerFunc{
-		"DELETE": c.destroyVolume,
-		"GET":    c.getVolumeHandler,
-		"POST":   c.createVolume,
+	// Map of HTTP methods to the appropriate StorageHandlerFunc, depending on
+	// whether the request targets a volume or its snapshots
+	var methodFnMap map[string]StorageHandlerFunc
+	switch p := storagePath(r); {
+	case len(p) <= 1:
+		methodFnMap = map[string]StorageHandlerFunc{
+			"DELETE": c.destroyVolume,
+			"GET":    c.getVolumeHandler,
+			"PATCH":  c.updateVolume,
+			"POST":   c.createVolume,
+			"PUT":    c.updateVolume,
+		}
+	case p[1] == snapshotsPath && len(p) <= 3:
+		methodFnMap = map[string]StorageHandlerFunc{
+			"DELETE": c.destroySnapshot,
+			"GET":    c.getSnapshotHandler,
+			"POST":   c.createSnapshot,
+		}
+	case p[1] == snapshotsPath && len(p) == 4 && p[3] == rollbackPath:
+		methodFnMap = map[string]StorageHandlerFunc{
+			"POST": c.rollbackSnapshot,
+		}
+	default:
+		writeError(w, http.StatusNotFound, ErrorCodeNotFound, nil)
+		return
 	}
 
 	// Check for a valid StorageHandlerFunc, 405 if none found
 	fn, ok := methodFnMap[r.Method]
 	if !ok {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, nil)
+		return
+	}
+
+	// If the pool is degraded, volumes may only be retrieved, 503
+	if reason, ok := c.monitor.Degraded(); ok && r.Method != "GET" {
+		writeError(w, http.StatusServiceUnavailable, ErrorCodePoolDegraded, map[string]interface{}{
+			"reason": reason,
+		})
 		return
 	}
 
@@ -78,13 +177,12 @@ func (c *StorageContext) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	code, body, err := fn(name, r)
 	if err != nil {
 		log.Println(err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, ErrorCodeInternal, nil)
 		return
 	}
 
 	// Return necessary code and body
-	w.WriteHeader(code)
-	w.Write(body)
+	writeJSON(w, code, body)
 }
 
 // destroyVolume is a StorageHandlerFunc which destroys a volume via
@@ -95,7 +193,7 @@ func (c *StorageContext) destroyVolume(name string, r *http.Request) (int, []byt
 	if err != nil {
 		// If volume does not exist, 404
 		if err == storage.ErrVolumeNotExists {
-			return http.StatusNotFound, nil, nil
+			return errorResponse(http.StatusNotFound, ErrorCodeVolumeNotFound, nil)
 		}
 
 		// Any other errors
@@ -104,6 +202,11 @@ func (c *StorageContext) destroyVolume(name string, r *http.Request) (int, []byt
 
 	// Destroy the volume, and all recursive volumes
 	if err := volume.Destroy(); err != nil {
+		// If other volumes were cloned from its snapshots, 409
+		if err == storage.ErrDependentClones {
+			return errorResponse(http.StatusConflict, ErrorCodeDependentClones, nil)
+		}
+
 		return http.StatusInternalServerError, nil, err
 	}
 
@@ -125,7 +228,7 @@ func (c *StorageContext) getVolumeHandler(name string, r *http.Request) (int, []
 	}
 
 	// Invalid request
-	return http.StatusNotFound, nil, nil
+	return errorResponse(http.StatusNotFound, ErrorCodeNotFound, nil)
 }
 
 // getAllUserVolumeMetadata is a StorageHandlerFunc which returns metadata for all
@@ -133,7 +236,13 @@ func (c *StorageContext) getVolumeHandler(name string, r *http.Request) (int, []
 func (c *StorageContext) getAllUserVolumeMetadata(name string, r *http.Request) (int, []byte, error) {
 	// Ensure request is bucketed to pool and unique hash
 	if len(strings.Split(name, "/")) != 2 {
-		return http.StatusNotFound, nil, nil
+		return errorResponse(http.StatusNotFound, ErrorCodeNotFound, nil)
+	}
+
+	// Parse optional label selector which filters volumes
+	sel, err := storage.ParseLabelSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, ErrorCodeInvalidLabelSelector, nil)
 	}
 
 	// Attempt to fetch list of volumes for user; it is possible
@@ -143,13 +252,19 @@ func (c *StorageContext) getAllUserVolumeMetadata(name string, r *http.Request)
 		return http.StatusInternalServerError, nil, err
 	}
 
-	// Wrap all volumes in output format
-	out := make([]*Volume, len(volumes))
-	for i := range out {
-		out[i] = &Volume{
-			Name: path.Base(volumes[i].Name()),
-			Size: volumes[i].Size(),
+	// Wrap all selected volumes in output format
+	out := make([]*Volume, 0, len(volumes))
+	for _, v := range volumes {
+		vv, err := newVolume(v)
+		if err != nil {
+			return http.StatusInternalServerError, nil, err
+		}
+
+		if !sel.Matches(vv.Labels) {
+			continue
 		}
+
+		out = append(out, vv)
 	}
 
 	// Return JSON representation of volumes
@@ -164,7 +279,7 @@ func (c *StorageContext) getAllUserVolumeMetadata(name string, r *http.Request)
 func (c *StorageContext) getSingleVolumeMetadata(name string, r *http.Request) (int, []byte, error) {
 	// Ensure request name is bucketed to pool, unique hash, and volume name
 	if len(strings.Split(name, "/")) != 3 {
-		return http.StatusNotFound, nil, nil
+		return errorResponse(http.StatusNotFound, ErrorCodeNotFound, nil)
 	}
 
 	// Check for a volume with the specified name
@@ -172,21 +287,36 @@ func (c *StorageContext) getSingleVolumeMetadata(name string, r *http.Request) (
 	if err != nil {
 		// If volume does not exist, 404
 		if err == storage.ErrVolumeNotExists {
-			return http.StatusNotFound, nil, nil
+			return errorResponse(http.StatusNotFound, ErrorCodeVolumeNotFound, nil)
 		}
 
 		// Any other errors
 		return http.StatusInternalServerError, nil, err
 	}
 
-	// Return JSON representation of volume
+	out, err := newVolume(volume)
+	if err != nil {
+		return http.StatusInternalServerError, nil, err
+	}
+
+	// Statistics are reported on a best-effort basis, so that a volume can
+	// always be retrieved
+	s, err := volume.Stats()
+	if err != nil {
+		log.Printf("failed to retrieve I/O statistics of volume %q: %v", name, err)
+	}
+	if s != nil {
+		out.Stats = &VolumeStats{
+			Reads:      s.Reads,
+			Writes:     s.Writes,
+			ReadBytes:  s.ReadBytes,
+			WriteBytes: s.WriteBytes,
+		}
+	}
+
+	// Return JSON representation of volume and its statistics
 	body, err := json.Marshal(&StorageResponse{
-		Volumes: []*Volume{
-			&Volume{
-				Name: path.Base(volume.Name()),
-				Size: volume.Size(),
-			},
-		},
+		Volumes: []*Volume{out},
 	})
 	return http.StatusOK, body, err
 }
@@ -196,14 +326,14 @@ func (c *StorageContext) getSingleVolumeMetadata(name string, r *http.Request) (
 func (c *StorageContext) createVolume(name string, r *http.Request) (int, []byte, error) {
 	// Ensure request name is bucketed to pool, unique hash, and volume name
 	if len(strings.Split(name, "/")) != 3 {
-		return http.StatusNotFound, nil, nil
+		return errorResponse(http.StatusNotFound, ErrorCodeNotFound, nil)
 	}
 
 	// Check for a volume with the specified name
 	_, err := c.pool.Volume(name)
 	if err == nil {
 		// If no error, one already exists, so return 409
-		return http.StatusConflict, nil, nil
+		return errorResponse(http.StatusConflict, ErrorCodeVolumeExists, nil)
 	}
 
 	// For any other errors, return server error
@@ -211,80 +341,442 @@ func (c *StorageContext) createVolume(name string, r *http.Request) (int, []byte
 		return http.StatusInternalServerError, nil, err
 	}
 
-	// Parse volume size from HTTP request
-	size, err := storageSize(r)
+	// Parse volume creation request from HTTP request
+	sr, err := storageRequest(r)
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, ErrorCodeInvalidRequest, nil)
+	}
+
+	// Validate labels before the volume is created, so that a volume is
+	// never created without its labels
+	if err := storage.ValidateLabels(sr.Labels); err != nil {
+		return errorResponse(http.StatusBadRequest, ErrorCodeInvalidLabel, nil)
+	}
+
+	// If a source is specified, clone the volume instead
+	if sr.Source != nil {
+		return c.cloneVolume(name, sr)
+	}
+
+	// Look up the selected storage class, if any
+	var class *storage.Class
+	if sr.Class != "" {
+		var ok bool
+		if class, ok = c.classes[sr.Class]; !ok {
+			return errorResponse(http.StatusBadRequest, ErrorCodeInvalidClass, map[string]interface{}{
+				"classes": c.classNames(),
+			})
+		}
+	}
+
+	// Parse volume creation options, permitting only a fixed set of options
+	// which do not conflict with the storage class
+	options, err := storage.ParseVolumeOptions(sr.Options)
+	if err == nil && class != nil {
+		if options == nil {
+			options = new(storage.VolumeOptions)
+		}
+
+		options.Class = class
+		err = options.Validate()
+	}
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, ErrorCodeInvalidVolumeOption, map[string]interface{}{
+			"options": storage.VolumeOptionNames(),
+		})
+	}
+
+	// Parse volume size from request, which must be permitted by the
+	// storage class
+	size, err := c.storageSize(sr, class, options.VolumeBlockSize())
 	if err != nil {
 		// Check for invalid storage size slug
 		if err == errInvalidSize {
-			return http.StatusBadRequest, []byte(fmt.Sprintf("%s", storage.Slugs())), nil
+			return c.invalidSizeResponse(class)
 		}
 
 		// Any other error
 		return http.StatusInternalServerError, nil, err
 	}
 
-	// Generate a volume with the specified name and size
-	volume, err := c.pool.CreateVolume(name, size)
+	// Generate a volume with the specified name, size, and options
+	volume, err := c.pool.CreateVolume(name, size, options)
 	if err != nil {
 		// Check for out of space error, return 503
 		if err == storage.ErrPoolOutOfSpace {
-			return http.StatusServiceUnavailable, nil, nil
+			return c.outOfSpaceResponse(size)
+		}
+
+		return http.StatusInternalServerError, nil, err
+	}
+
+	// Label volume and return JSON representation of volume
+	return createdVolume(volume, sr.Labels)
+}
+
+// updateVolume is a StorageHandlerFunc which handles volume resizing and
+// label updates for the HTTP server.
+func (c *StorageContext) updateVolume(name string, r *http.Request) (int, []byte, error) {
+	// Ensure request name is bucketed to pool, unique hash, and volume name
+	if len(strings.Split(name, "/")) != 3 {
+		return errorResponse(http.StatusNotFound, ErrorCodeNotFound, nil)
+	}
+
+	// Check for a volume with the specified name
+	volume, err := c.pool.Volume(name)
+	if err != nil {
+		// If volume does not exist, 404
+		if err == storage.ErrVolumeNotExists {
+			return errorResponse(http.StatusNotFound, ErrorCodeVolumeNotFound, nil)
 		}
 
+		// Any other errors
 		return http.StatusInternalServerError, nil, err
 	}
 
+	// Parse volume update request from HTTP request
+	sr, err := storageRequest(r)
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, ErrorCodeInvalidRequest, nil)
+	}
+
+	// Parse new volume size from request; size may be omitted if only
+	// labels are updated
+	size := volume.Size()
+	if sr.Size != "" || sr.Labels == nil {
+		// Volumes of a storage class may only use its sizes, and exact
+		// sizes are rounded to the volume's block size
+		m, err := volume.Metadata()
+		if err != nil {
+			return http.StatusInternalServerError, nil, err
+		}
+		class := c.classes[m.Class]
+
+		size, err = c.storageSize(sr, class, m.BlockSize)
+		if err != nil {
+			// Check for invalid storage size slug
+			if err == errInvalidSize {
+				return c.invalidSizeResponse(class)
+			}
+
+			// Any other error
+			return http.StatusInternalServerError, nil, err
+		}
+	}
+
+	// Determine new labels before resizing, so invalid labels do not
+	// leave a volume partially updated
+	var labels map[string]string
+	if sr.Labels != nil {
+		labels, err = updatedLabels(volume, r.Method, sr.Labels)
+		if err != nil {
+			if err == storage.ErrInvalidLabel {
+				return errorResponse(http.StatusBadRequest, ErrorCodeInvalidLabel, nil)
+			}
+
+			return http.StatusInternalServerError, nil, err
+		}
+	}
+
+	// Shrinking a volume may destroy data, so it must be forced
+	if size < volume.Size() && !sr.Force {
+		return errorResponse(http.StatusConflict, ErrorCodeShrinkNotForced, nil)
+	}
+
+	// Resize the volume, unless it is already the requested size
+	if size != volume.Size() {
+		if err := volume.Resize(size); err != nil {
+			// Check for out of space error, return 503
+			if err == storage.ErrPoolOutOfSpace {
+				return c.outOfSpaceResponse(size)
+			}
+
+			return http.StatusInternalServerError, nil, err
+		}
+	}
+
+	// Replace labels, if requested
+	if labels != nil {
+		if err := volume.SetLabels(labels); err != nil {
+			return http.StatusInternalServerError, nil, err
+		}
+	}
+
 	// Return JSON representation of volume
-	body, err := json.Marshal(&StorageResponse{
-		Volumes: []*Volume{
-			&Volume{
-				Name: path.Base(volume.Name()),
-				Size: volume.Size(),
-			},
-		},
-	})
-	return http.StatusCreated, body, err
+	return volumeResponse(http.StatusOK, volume)
+}
+
+// cloneVolume handles new volume creation by cloning a snapshot of an existing
+// volume for the HTTP server.  It is invoked by createVolume when a source is
+// specified in the request.
+func (c *StorageContext) cloneVolume(name string, sr *StorageRequest) (int, []byte, error) {
+	// Clones always take the size, options, and class of their origin, and
+	// the source volume must belong to the same bucket as the new volume
+	src := sr.Source
+	if sr.Size != "" || sr.Options != nil || sr.Class != "" {
+		return errorResponse(http.StatusBadRequest, ErrorCodeInvalidRequest, nil)
+	}
+	if src.Volume == "" || strings.Contains(src.Volume, "/") {
+		return errorResponse(http.StatusBadRequest, ErrorCodeInvalidSource, nil)
+	}
+
+	// Clone a volume with the specified name from the source snapshot
+	volume, err := c.pool.CloneVolume(name, path.Join(path.Dir(name), src.Volume), src.Snapshot)
+	if err != nil {
+		switch err {
+		// If source volume or snapshot is invalid, 400
+		case storage.ErrVolumeNotExists, storage.ErrSnapshotNotExists, storage.ErrInvalidSnapshotName:
+			return errorResponse(http.StatusBadRequest, ErrorCodeInvalidSource, nil)
+		// If volume was created concurrently, 409
+		case storage.ErrVolumeExists:
+			return errorResponse(http.StatusConflict, ErrorCodeVolumeExists, nil)
+		// Check for out of space error, return 503
+		case storage.ErrPoolOutOfSpace:
+			return c.outOfSpaceResponse(0)
+		}
+
+		return http.StatusInternalServerError, nil, err
+	}
+
+	// Label volume and return JSON representation of volume
+	return createdVolume(volume, sr.Labels)
 }
 
 // volumeName uses HTTP server context and the current request to create a
 // volume name specific to this client.
 func (c *StorageContext) volumeName(r *http.Request) (string, error) {
-	// Retrieve IP address from HTTP request
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	// Identify the tenant whose bucket contains this client's volumes
+	tenant, err := c.tenants.Tenant(r)
 	if err != nil {
 		return "", err
 	}
 
+	// Volume name is the first path component after the API prefix, if
+	// one is present
+	var volume string
+	if p := storagePath(r); len(p) > 0 {
+		volume = p[0]
+	}
+
 	// Create a bucketed storage volume name which is limited to the
-	// zstored pool, a MD5'd IP address, and the user-specified
+	// zstored pool, the client's tenant ID, and the user-specified
 	// volume name
 	return filepath.Join(
 		c.pool.Name(),
-		fmt.Sprintf("%x", md5.Sum([]byte(host))),
-		// Strip API path prefix
-		path.Base(r.URL.Path[len(storageAPI):]),
+		tenant,
+		volume,
 	), nil
 }
 
-// storageSize returns a uint64 volume size after reading an input HTTP request
-// and parsing a size slug from the request.
-func storageSize(r *http.Request) (uint64, error) {
+// classNames returns the sorted names of all configured storage classes.
+func (c *StorageContext) classNames() []string {
+	names := make([]string, 0, len(c.classes))
+	for n := range c.classes {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// storagePath returns the components of a HTTP request's path which follow
+// the storage API prefix.
+func storagePath(r *http.Request) []string {
+	// Strip API path prefix
+	p := strings.Trim(r.URL.Path[len(storageAPI):], "/")
+	if p == "" {
+		return nil
+	}
+
+	return strings.Split(p, "/")
+}
+
+// newVolume creates the JSON representation of a storage.Volume.
+func newVolume(v storage.Volume) (*Volume, error) {
+	m, err := v.Metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	labels, err := v.Labels()
+	if err != nil {
+		return nil, err
+	}
+
+	out := &Volume{
+		Name:          path.Base(v.Name()),
+		Size:          v.Size(),
+		Used:          m.Used,
+		Referenced:    m.Referenced,
+		CompressRatio: m.CompressRatio,
+		Created:       m.Created,
+		Device:        m.Device,
+		Class:         m.Class,
+		Labels:        labels,
+	}
+
+	// Report only the volume and snapshot name of a clone's origin
+	if origin := v.Origin(); origin != "" {
+		out.Origin = path.Base(origin)
+	}
+
+	return out, nil
+}
+
+// createdVolume sets labels on a newly created volume, and returns a HTTP
+// status code and JSON body containing its representation.  If its labels
+// cannot be set, the volume is destroyed.
+func createdVolume(volume storage.Volume, labels map[string]string) (int, []byte, error) {
+	if len(labels) > 0 {
+		if err := volume.SetLabels(labels); err != nil {
+			if derr := volume.Destroy(); derr != nil {
+				log.Println(derr)
+			}
+
+			return http.StatusInternalServerError, nil, err
+		}
+	}
+
+	return volumeResponse(http.StatusCreated, volume)
+}
+
+// updatedLabels returns the labels which should be set on a volume by an
+// update request.  PUT requests replace all labels, while PATCH requests
+// merge labels into the volume's existing labels, removing any labels
+// with empty values.
+func updatedLabels(volume storage.Volume, method string, in map[string]string) (map[string]string, error) {
+	labels := in
+	if method == "PATCH" {
+		current, err := volume.Labels()
+		if err != nil {
+			return nil, err
+		}
+
+		labels = current
+		for k, v := range in {
+			if v == "" {
+				delete(labels, k)
+				continue
+			}
+
+			labels[k] = v
+		}
+	}
+
+	if err := storage.ValidateLabels(labels); err != nil {
+		return nil, err
+	}
+
+	return labels, nil
+}
+
+// volumeResponse returns a HTTP status code and JSON body containing the
+// representation of a single storage.Volume.
+func volumeResponse(code int, v storage.Volume) (int, []byte, error) {
+	out, err := newVolume(v)
+	if err != nil {
+		return http.StatusInternalServerError, nil, err
+	}
+
+	body, err := json.Marshal(&StorageResponse{
+		Volumes: []*Volume{out},
+	})
+	return code, body, err
+}
+
+// storageRequest decodes a StorageRequest from an input HTTP request.  If
+// the request has no body, an empty StorageRequest is returned.
+func storageRequest(r *http.Request) (*StorageRequest, error) {
 	// Decode HTTP request body into StorageRequest
 	sr := new(StorageRequest)
-	if err := json.NewDecoder(r.Body).Decode(sr); err != nil {
-		// If no request body, return invalid size
-		if err == io.EOF {
+	if err := json.NewDecoder(r.Body).Decode(sr); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return sr, nil
+}
+
+// outOfSpaceResponse returns a HTTP status code and JSON error body for a
+// pool which cannot allocate the requested size in bytes, including the
+// number of bytes which are free in the pool.  If size is 0, the requested
+// size is not known, and is omitted.
+func (c *StorageContext) outOfSpaceResponse(size uint64) (int, []byte, error) {
+	details := make(map[string]interface{})
+	if size != 0 {
+		details["size"] = size
+	}
+
+	// Free space is reported on a best-effort basis, and pools with no limit
+	// on their capacity cannot run out of space
+	free, err := c.pool.Free()
+	switch {
+	case err != nil:
+		log.Printf("failed to retrieve free space of pool %q: %v", c.pool.Name(), err)
+	case free != math.MaxUint64:
+		details["free"] = free
+	}
+
+	return errorResponse(http.StatusServiceUnavailable, ErrorCodePoolOutOfSpace, details)
+}
+
+// invalidSizeResponse returns a HTTP status code and JSON error body for an
+// invalid size, listing the sizes which are valid for the storage class, or
+// for any volume if class is nil.  If exact sizes are permitted, their limits
+// are also returned.
+func (c *StorageContext) invalidSizeResponse(class *storage.Class) (int, []byte, error) {
+	var sizes []string
+	if class != nil {
+		sizes = class.Slugs()
+	}
+
+	details := make(map[string]interface{})
+	if sizes == nil {
+		sizes = c.catalog.Slugs()
+
+		if l := c.exactSizes; l != nil {
+			details["min"] = l.Min
+			details["max"] = l.Max
+		}
+	}
+	details["sizes"] = sizes
+
+	return errorResponse(http.StatusBadRequest, ErrorCodeInvalidSize, details)
+}
+
+// storageSize returns a uint64 volume size after parsing a size slug from
+// an input StorageRequest.  The slug must be in the server's catalog, and if
+// class is not nil, it must be permitted by the storage class.
+//
+// If exact sizes are permitted, and the storage class does not limit its
+// sizes, the size may instead be any size accepted by storage.ParseSize.  It
+// is rounded up to a multiple of blockSize, and must be within the limits of
+// exact sizes.
+func (c *StorageContext) storageSize(sr *StorageRequest, class *storage.Class, blockSize uint64) (uint64, error) {
+	// Check if slug is valid, return size
+	size, ok := c.catalog.SlugSize(sr.Size)
+	if ok {
+		if class != nil && !class.AllowsSize(sr.Size) {
 			return 0, errInvalidSize
 		}
 
-		return 0, err
+		return uint64(size), nil
 	}
 
-	// Check if slug is valid, return size
-	size, ok := storage.SlugSize(sr.Size)
-	if !ok {
+	// Check if an exact size is permitted
+	l := c.exactSizes
+	if l == nil || (class != nil && len(class.Sizes) > 0) {
+		return 0, errInvalidSize
+	}
+
+	exact, err := storage.ParseSize(sr.Size)
+	if err != nil {
+		return 0, errInvalidSize
+	}
+
+	exact = storage.RoundSize(exact, blockSize)
+	if exact == 0 || exact < l.Min || exact > l.Max {
 		return 0, errInvalidSize
 	}
 
-	return uint64(size), nil
+	return exact, nil
 }
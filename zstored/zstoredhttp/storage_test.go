@@ -0,0 +1,731 @@
+package zstoredhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mdlayher/zstore/storage"
+	"github.com/mdlayher/zstore/storage/zfsutil"
+)
+
+// TestStorageCreateVolume verifies that volumes can be created through the
+// storage API, and that invalid requests are rejected.
+func TestStorageCreateVolume(t *testing.T) {
+	pool := storage.NewMemPool("zstore", 1*storage.GB)
+
+	var tests = []struct {
+		description string
+		body        string
+		code        int
+		errCode     ErrorCode
+	}{
+		{
+			description: "no request body",
+			code:        http.StatusBadRequest,
+			errCode:     ErrorCodeInvalidSize,
+		},
+		{
+			description: "malformed request body",
+			body:        `{"size":`,
+			code:        http.StatusBadRequest,
+			errCode:     ErrorCodeInvalidRequest,
+		},
+		{
+			description: "invalid size slug",
+			body:        `{"size":"3G"}`,
+			code:        http.StatusBadRequest,
+			errCode:     ErrorCodeInvalidSize,
+		},
+		{
+			description: "unknown option",
+			body:        `{"size":"512M","options":{"copies":"3"}}`,
+			code:        http.StatusBadRequest,
+			errCode:     ErrorCodeInvalidVolumeOption,
+		},
+		{
+			description: "invalid compression option",
+			body:        `{"size":"512M","options":{"compression":"foo"}}`,
+			code:        http.StatusBadRequest,
+			errCode:     ErrorCodeInvalidVolumeOption,
+		},
+		{
+			description: "valid size slug",
+			body:        `{"size":"512M"}`,
+			code:        http.StatusCreated,
+		},
+		{
+			description: "volume already exists",
+			body:        `{"size":"512M"}`,
+			code:        http.StatusConflict,
+			errCode:     ErrorCodeVolumeExists,
+		},
+	}
+
+	for _, test := range tests {
+		w := testStorageRequest(t, pool, "POST", "/v1/storage/foo", test.body)
+		if w.Code != test.code {
+			t.Fatalf("unexpected code: %v != %v [description: %s]", w.Code, test.code, test.description)
+		}
+
+		if test.errCode == "" {
+			continue
+		}
+		if e := testError(t, w); e.Code != test.errCode {
+			t.Fatalf("unexpected error code: %v != %v [description: %s]", e.Code, test.errCode, test.description)
+		}
+	}
+
+	// Pool cannot satisfy another large volume
+	w := testStorageRequest(t, pool, "POST", "/v1/storage/bar", `{"size":"1G"}`)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusServiceUnavailable)
+	}
+	free, err := pool.Free()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := testError(t, w)
+	if e.Code != ErrorCodePoolOutOfSpace {
+		t.Fatalf("unexpected error code: %v != %v", e.Code, ErrorCodePoolOutOfSpace)
+	}
+	// JSON numbers are decoded as float64
+	if size := e.Details["size"]; size != float64(1*storage.GB) {
+		t.Fatalf("unexpected requested size: %v != %v", size, 1*storage.GB)
+	}
+	if f := e.Details["free"]; f != float64(free) {
+		t.Fatalf("unexpected free space: %v != %v", f, free)
+	}
+
+	// Sparse volumes reserve no space, so they can still be created
+	w = testStorageRequest(t, pool, "POST", "/v1/storage/bar", `{"size":"1G","options":{"compression":"lz4","sparse":"true"}}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusCreated)
+	}
+}
+
+// TestStorageErrors verifies that errors from the storage API use the JSON
+// error format, and that details are provided for invalid sizes.
+func TestStorageErrors(t *testing.T) {
+	pool := storage.NewMemPool("zstore", 0)
+
+	var tests = []struct {
+		description string
+		method      string
+		path        string
+		code        int
+		errCode     ErrorCode
+	}{
+		{
+			description: "unknown volume",
+			method:      "GET",
+			path:        "/v1/storage/foo",
+			code:        http.StatusNotFound,
+			errCode:     ErrorCodeVolumeNotFound,
+		},
+		{
+			description: "unknown path",
+			method:      "GET",
+			path:        "/v1/storage/foo/bar",
+			code:        http.StatusNotFound,
+			errCode:     ErrorCodeNotFound,
+		},
+		{
+			description: "method not allowed",
+			method:      "HEAD",
+			path:        "/v1/storage/foo",
+			code:        http.StatusMethodNotAllowed,
+			errCode:     ErrorCodeMethodNotAllowed,
+		},
+	}
+
+	for _, test := range tests {
+		w := testStorageRequest(t, pool, test.method, test.path, "")
+		if w.Code != test.code {
+			t.Fatalf("unexpected code: %v != %v [description: %s]", w.Code, test.code, test.description)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("unexpected content type: %q [description: %s]", ct, test.description)
+		}
+
+		e := testError(t, w)
+		if e.Code != test.errCode {
+			t.Fatalf("unexpected error code: %v != %v [description: %s]", e.Code, test.errCode, test.description)
+		}
+		if e.Message == "" {
+			t.Fatalf("empty error message [description: %s]", test.description)
+		}
+	}
+
+	// Invalid sizes report the list of valid sizes
+	w := testStorageRequest(t, pool, "POST", "/v1/storage/foo", `{"size":"3G"}`)
+	e := testError(t, w)
+	sizes, ok := e.Details["sizes"].([]interface{})
+	if !ok || len(sizes) != len(storage.Slugs()) {
+		t.Fatalf("unexpected sizes in details: %v", e.Details)
+	}
+}
+
+// TestStorageGetVolumes verifies that metadata for one or more volumes can be
+// retrieved through the storage API.
+func TestStorageGetVolumes(t *testing.T) {
+	pool := storage.NewMemPool("zstore", 0)
+
+	// No volumes for this user yet
+	w := testStorageRequest(t, pool, "GET", "/v1/storage/foo", "")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusNotFound)
+	}
+
+	for _, name := range []string{"foo", "bar"} {
+		w := testStorageRequest(t, pool, "POST", "/v1/storage/"+name, `{"size":"256M"}`)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusCreated)
+		}
+	}
+
+	// Retrieve a single volume
+	w = testStorageRequest(t, pool, "GET", "/v1/storage/foo", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusOK)
+	}
+
+	res := new(StorageResponse)
+	if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Volumes) != 1 {
+		t.Fatalf("unexpected number of volumes: %v != %v", len(res.Volumes), 1)
+	}
+	if v := res.Volumes[0]; v.Name != "foo" || v.Size != 256*storage.MB || v.Referenced != 256*storage.MB || v.Created.IsZero() || v.Stats != nil {
+		t.Fatalf("unexpected volume: %v", v)
+	}
+
+	// Retrieve all volumes for this user
+	w = testStorageRequest(t, pool, "GET", "/v1/storage/", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusOK)
+	}
+
+	res = new(StorageResponse)
+	if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Volumes) != 2 {
+		t.Fatalf("unexpected number of volumes: %v != %v", len(res.Volumes), 2)
+	}
+}
+
+// TestStorageVolumeStats verifies that the I/O statistics of a volume are
+// reported when it is retrieved, if it tracks them, and that a volume can be
+// retrieved when its statistics cannot.
+func TestStorageVolumeStats(t *testing.T) {
+	pool := &statsPool{
+		MemPool: storage.NewMemPool("zstore", 0),
+		stats: &storage.PoolStats{
+			Volumes: map[string]*zfsutil.IOStats{
+				"zstore/" + testBucket + "/foo": {
+					Reads:      2215,
+					Writes:     9120,
+					ReadBytes:  145162240,
+					WriteBytes: 597688320,
+				},
+			},
+		},
+	}
+
+	for _, name := range []string{"foo", "bar"} {
+		w := testStorageRequest(t, pool, "POST", "/v1/storage/"+name, `{"size":"256M"}`)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusCreated)
+		}
+	}
+
+	var tests = []struct {
+		description string
+		name        string
+		err         error
+		stats       *VolumeStats
+	}{
+		{
+			description: "volume with statistics",
+			name:        "foo",
+			stats: &VolumeStats{
+				Reads:      2215,
+				Writes:     9120,
+				ReadBytes:  145162240,
+				WriteBytes: 597688320,
+			},
+		},
+		{
+			description: "volume without statistics",
+			name:        "bar",
+		},
+		{
+			description: "statistics cannot be retrieved",
+			name:        "foo",
+			err:         errors.New("invalid kstat"),
+		},
+	}
+
+	for _, test := range tests {
+		pool.err = test.err
+
+		w := testStorageRequest(t, pool, "GET", "/v1/storage/"+test.name, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected code: %v != %v [description: %s]", w.Code, http.StatusOK, test.description)
+		}
+
+		res := new(StorageResponse)
+		if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Volumes) != 1 {
+			t.Fatalf("unexpected number of volumes: %v != %v [description: %s]", len(res.Volumes), 1, test.description)
+		}
+		if stats := res.Volumes[0].Stats; !reflect.DeepEqual(stats, test.stats) {
+			t.Fatalf("unexpected stats: %+v != %+v [description: %s]", stats, test.stats, test.description)
+		}
+	}
+}
+
+// TestStorageDestroyVolume verifies that volumes can be destroyed through the
+// storage API.
+func TestStorageDestroyVolume(t *testing.T) {
+	pool := storage.NewMemPool("zstore", 0)
+
+	w := testStorageRequest(t, pool, "POST", "/v1/storage/foo", `{"size":"256M"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusCreated)
+	}
+
+	// First destroy succeeds, second cannot find the volume
+	for _, code := range []int{http.StatusNoContent, http.StatusNotFound} {
+		w := testStorageRequest(t, pool, "DELETE", "/v1/storage/foo", "")
+		if w.Code != code {
+			t.Fatalf("unexpected code: %v != %v", w.Code, code)
+		}
+	}
+}
+
+// TestStorageCloneVolume verifies that volumes can be cloned from snapshots
+// of other volumes through the storage API.
+func TestStorageCloneVolume(t *testing.T) {
+	pool := storage.NewMemPool("zstore", 0)
+
+	w := testStorageRequest(t, pool, "POST", "/v1/storage/golden", `{"size":"1G"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusCreated)
+	}
+	w = testStorageRequest(t, pool, "POST", "/v1/storage/golden/snapshots/base", "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusCreated)
+	}
+
+	var tests = []struct {
+		description string
+		body        string
+		code        int
+	}{
+		{
+			description: "size and source",
+			body:        `{"size":"1G","source":{"volume":"golden","snapshot":"base"}}`,
+			code:        http.StatusBadRequest,
+		},
+		{
+			description: "source volume in another bucket",
+			body:        `{"source":{"volume":"foo/golden","snapshot":"base"}}`,
+			code:        http.StatusBadRequest,
+		},
+		{
+			description: "unknown source volume",
+			body:        `{"source":{"volume":"silver","snapshot":"base"}}`,
+			code:        http.StatusBadRequest,
+		},
+		{
+			description: "unknown source snapshot",
+			body:        `{"source":{"volume":"golden","snapshot":"other"}}`,
+			code:        http.StatusBadRequest,
+		},
+		{
+			description: "clone snapshot",
+			body:        `{"source":{"volume":"golden","snapshot":"base"}}`,
+			code:        http.StatusCreated,
+		},
+	}
+
+	for _, test := range tests {
+		w := testStorageRequest(t, pool, "POST", "/v1/storage/clone", test.body)
+		if w.Code != test.code {
+			t.Fatalf("unexpected code: %v != %v [description: %s]", w.Code, test.code, test.description)
+		}
+	}
+
+	// Clone reports its origin and size
+	w = testStorageRequest(t, pool, "GET", "/v1/storage/clone", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusOK)
+	}
+
+	res := new(StorageResponse)
+	if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+	if v := res.Volumes[0]; v.Origin != "golden@base" || v.Size != 1*storage.GB {
+		t.Fatalf("unexpected volume: %v", v)
+	}
+
+	// Origin snapshot cannot be destroyed while the clone exists
+	w = testStorageRequest(t, pool, "DELETE", "/v1/storage/golden/snapshots/base", "")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusConflict)
+	}
+	w = testStorageRequest(t, pool, "DELETE", "/v1/storage/golden", "")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusConflict)
+	}
+}
+
+// TestStorageResizeVolume verifies that volumes can be resized through the
+// storage API, and that shrinking a volume must be forced.
+func TestStorageResizeVolume(t *testing.T) {
+	pool := storage.NewMemPool("zstore", 4*storage.GB)
+
+	w := testStorageRequest(t, pool, "POST", "/v1/storage/foo", `{"size":"1G"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("unexpected code: %v != %v", w.Code, http.StatusCreated)
+	}
+
+	var tests = []struct {
+		description string
+		method      string
+		path        string
+		body        string
+		code        int
+		size        uint64
+	}{
+		{
+			description: "unknown volume",
+			method:      "PUT",
+			path:        "/v1/storage/bar",
+			body:        `{"size":"2G"}`,
+			code:        http.StatusNotFound,
+		},
+		{
+			description: "invalid size slug",
+			method:      "PUT",
+			path:        "/v1/storage/foo",
+			body:        `{"size":"3G"}`,
+			code:        http.StatusBadRequest,
+		},
+		{
+			description: "grow volume",
+			method:      "PUT",
+			path:        "/v1/storage/foo",
+			body:        `{"size":"2G"}`,
+			code:        http.StatusOK,
+			size:        2 * storage.GB,
+		},
+		{
+			description: "same size",
+			method:      "PATCH",
+			path:        "/v1/storage/foo",
+			body:        `{"size":"2G"}`,
+			code:        http.StatusOK,
+			size:        2 * storage.GB,
+		},
+		{
+			description: "shrink volume without force",
+			method:      "PATCH",
+			path:        "/v1/storage/foo",
+			body:        `{"size":"512M"}`,
+			code:        http.StatusConflict,
+		},
+		{
+			description: "shrink volume with force",
+			method:      "PATCH",
+			path:        "/v1/storage/foo",
+			body:        `{"size":"512M","force":true}`,
+			code:        http.StatusOK,
+			size:        512 * storage.MB,
+		},
+		{
+			description: "pool out of space",
+			method:      "PUT",
+			path:        "/v1/storage/foo",
+			body:        `{"size":"8G"}`,
+			code:        http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, test := range tests {
+		w := testStorageRequest(t, pool, test.method, test.path, test.body)
+		if w.Code != test.code {
+			t.Fatalf("unexpected code: %v != %v [description: %s]", w.Code, test.code, test.description)
+		}
+
+		if test.code != http.StatusOK {
+			continue
+		}
+
+		res := new(StorageResponse)
+		if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+			t.Fatal(err)
+		}
+		if size := res.Volumes[0].Size; size != test.size {
+			t.Fatalf("unexpected size: %v != %v [description: %s]", size, test.size, test.description)
+		}
+	}
+}
+
+// TestStorageLabels verifies that volume labels can be set at creation,
+// updated, and used to filter volumes through the storage API.
+func TestStorageLabels(t *testing.T) {
+	pool := storage.NewMemPool("zstore", 0)
+
+	for _, v := range []struct {
+		name string
+		body string
+		code int
+	}{
+		{name: "foo", body: `{"size":"256M","labels":{"env":"prod","tier":"gold"}}`, code: http.StatusCreated},
+		{name: "bar", body: `{"size":"256M","labels":{"env":"dev"}}`, code: http.StatusCreated},
+		{name: "baz", body: `{"size":"256M","labels":{"Env":"dev"}}`, code: http.StatusBadRequest},
+	} {
+		w := testStorageRequest(t, pool, "POST", "/v1/storage/"+v.name, v.body)
+		if w.Code != v.code {
+			t.Fatalf("unexpected code: %v != %v [volume: %s]", w.Code, v.code, v.name)
+		}
+	}
+
+	// Invalid labels prevent volume creation
+	if _, err := pool.Volume("zstore/" + testBucket + "/baz"); err != storage.ErrVolumeNotExists {
+		t.Fatalf("unexpected error for volume with invalid labels: %v != %v", err, storage.ErrVolumeNotExists)
+	}
+
+	var tests = []struct {
+		description string
+		method      string
+		path        string
+		body        string
+		code        int
+		volumes     []string
+		labels      map[string]string
+	}{
+		{
+			description: "select by label",
+			method:      "GET",
+			path:        "/v1/storage/?selector=env%3Dprod",
+			code:        http.StatusOK,
+			volumes:     []string{"foo"},
+			labels:      map[string]string{"env": "prod", "tier": "gold"},
+		},
+		{
+			description: "invalid selector",
+			method:      "GET",
+			path:        "/v1/storage/?selector=Env",
+			code:        http.StatusBadRequest,
+		},
+		{
+			description: "merge labels",
+			method:      "PATCH",
+			path:        "/v1/storage/foo",
+			body:        `{"labels":{"env":"staging","tier":""}}`,
+			code:        http.StatusOK,
+			volumes:     []string{"foo"},
+			labels:      map[string]string{"env": "staging"},
+		},
+		{
+			description: "replace labels",
+			method:      "PUT",
+			path:        "/v1/storage/foo",
+			body:        `{"labels":{"team":"storage"}}`,
+			code:        http.StatusOK,
+			volumes:     []string{"foo"},
+			labels:      map[string]string{"team": "storage"},
+		},
+		{
+			description: "replace labels with empty value",
+			method:      "PUT",
+			path:        "/v1/storage/foo",
+			body:        `{"labels":{"team":""}}`,
+			code:        http.StatusBadRequest,
+		},
+		{
+			description: "select by missing label",
+			method:      "GET",
+			path:        "/v1/storage/?selector=!env",
+			code:        http.StatusOK,
+			volumes:     []string{"foo"},
+			labels:      map[string]string{"team": "storage"},
+		},
+	}
+
+	for _, test := range tests {
+		w := testStorageRequest(t, pool, test.method, test.path, test.body)
+		if w.Code != test.code {
+			t.Fatalf("unexpected code: %v != %v [description: %s]", w.Code, test.code, test.description)
+		}
+
+		if test.code != http.StatusOK {
+			continue
+		}
+
+		res := new(StorageResponse)
+		if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Volumes) != len(test.volumes) {
+			t.Fatalf("unexpected number of volumes: %v != %v [description: %s]", len(res.Volumes), len(test.volumes), test.description)
+		}
+
+		for i, v := range res.Volumes {
+			if v.Name != test.volumes[i] {
+				t.Fatalf("unexpected volume: %v != %v [description: %s]", v.Name, test.volumes[i], test.description)
+			}
+			if !reflect.DeepEqual(v.Labels, test.labels) {
+				t.Fatalf("unexpected labels: %v != %v [description: %s]", v.Labels, test.labels, test.description)
+			}
+		}
+	}
+}
+
+// TestStorageClasses verifies that storage classes can be selected when
+// creating volumes, and that they limit the sizes of their volumes.
+func TestStorageClasses(t *testing.T) {
+	pool := storage.NewMemPool("zstore", 0)
+	config := &Config{
+		Classes: []*storage.Class{{
+			Name:       "archive",
+			Properties: map[string]string{"compression": "gzip-9"},
+			Sizes:      []string{"4G", "8G"},
+		}},
+	}
+
+	var tests = []struct {
+		description string
+		method      string
+		path        string
+		body        string
+		code        int
+		errCode     ErrorCode
+		sizes       int
+	}{
+		{
+			description: "unknown class",
+			method:      "POST",
+			path:        "/v1/storage/foo",
+			body:        `{"size":"4G","class":"fast"}`,
+			code:        http.StatusBadRequest,
+			errCode:     ErrorCodeInvalidClass,
+		},
+		{
+			description: "size not permitted by class",
+			method:      "POST",
+			path:        "/v1/storage/foo",
+			body:        `{"size":"1G","class":"archive"}`,
+			code:        http.StatusBadRequest,
+			errCode:     ErrorCodeInvalidSize,
+			sizes:       2,
+		},
+		{
+			description: "option conflicts with class",
+			method:      "POST",
+			path:        "/v1/storage/foo",
+			body:        `{"size":"4G","class":"archive","options":{"compression":"lz4"}}`,
+			code:        http.StatusBadRequest,
+			errCode:     ErrorCodeInvalidVolumeOption,
+		},
+		{
+			description: "valid class",
+			method:      "POST",
+			path:        "/v1/storage/foo",
+			body:        `{"size":"4G","class":"archive","options":{"sparse":"true"}}`,
+			code:        http.StatusCreated,
+		},
+		{
+			description: "resize to size not permitted by class",
+			method:      "PUT",
+			path:        "/v1/storage/foo",
+			body:        `{"size":"2G","force":true}`,
+			code:        http.StatusBadRequest,
+			errCode:     ErrorCodeInvalidSize,
+			sizes:       2,
+		},
+		{
+			description: "resize to size permitted by class",
+			method:      "PUT",
+			path:        "/v1/storage/foo",
+			body:        `{"size":"8G"}`,
+			code:        http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		w := testConfigRequest(t, pool, config, test.method, test.path, test.body)
+		if w.Code != test.code {
+			t.Fatalf("unexpected code: %v != %v [description: %s]", w.Code, test.code, test.description)
+		}
+
+		if test.errCode == "" {
+			continue
+		}
+
+		e := testError(t, w)
+		if e.Code != test.errCode {
+			t.Fatalf("unexpected error code: %v != %v [description: %s]", e.Code, test.errCode, test.description)
+		}
+		if sizes, _ := e.Details["sizes"].([]interface{}); len(sizes) != test.sizes {
+			t.Fatalf("unexpected sizes in details: %v [description: %s]", e.Details, test.description)
+		}
+	}
+
+	// Volume reports its storage class
+	w := testConfigRequest(t, pool, config, "GET", "/v1/storage/foo", "")
+	res := new(StorageResponse)
+	if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+	if v := res.Volumes[0]; v.Class != "archive" || v.Size != 8*storage.GB {
+		t.Fatalf("unexpected volume: %+v", v)
+	}
+}
+
+// testBucket is the bucket used for requests made by testStorageRequest.
+var testBucket = ipTenant("192.168.1.1")
+
+// testError decodes an Error from a recorded HTTP response.
+func testError(t *testing.T, w *httptest.ResponseRecorder) *Error {
+	e := new(Error)
+	if err := json.Unmarshal(w.Body.Bytes(), e); err != nil {
+		t.Fatal(err)
+	}
+
+	return e
+}
+
+// testStorageRequest performs a HTTP request against the storage API, backed
+// by the input Pool, and returns the recorded response.
+func testStorageRequest(t *testing.T, pool storage.Pool, method string, path string, body string) *httptest.ResponseRecorder {
+	return testConfigRequest(t, pool, nil, method, path, body)
+}
+
+// testConfigRequest performs a HTTP request against the storage API, backed
+// by the input Pool and using the input Config, and returns the recorded
+// response.
+func testConfigRequest(t *testing.T, pool storage.Pool, config *Config, method string, path string, body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "192.168.1.1:12345"
+
+	w := httptest.NewRecorder()
+	NewServeMux(pool, config).ServeHTTP(w, req)
+	return w
+}
@@ -0,0 +1,237 @@
+package zstoredhttp
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/mdlayher/zstore/zstored/zstoredauth"
+)
+
+var (
+	// ErrNoTenant is returned by a TenantIdentifier when a HTTP request does
+	// not carry the credentials needed to identify its tenant.
+	ErrNoTenant = errors.New("no tenant identified")
+)
+
+// A TenantIdentifier identifies the tenant which made a HTTP request.  The
+// tenant ID is used as the name of the storage bucket which contains the
+// tenant's volumes, and must be a valid bucket name.
+//
+// Identifiers should return ErrNoTenant or zstoredauth.ErrInvalidToken if
+// a request carries no usable credentials, and zstoredauth.ErrTokenRevoked
+// if its credentials were revoked.  All other errors are server errors.
+type TenantIdentifier interface {
+	Tenant(r *http.Request) (string, error)
+}
+
+// writeTenantError writes the appropriate HTTP error response for an error
+// returned by a TenantIdentifier.
+func writeTenantError(w http.ResponseWriter, err error) {
+	switch err {
+	// If no valid API token is present, 401
+	case zstoredauth.ErrInvalidToken:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="zstored"`)
+		writeError(w, http.StatusUnauthorized, ErrorCodeUnauthorized, nil)
+		return
+	// If no tenant could otherwise be identified, 401
+	case ErrNoTenant:
+		writeError(w, http.StatusUnauthorized, ErrorCodeUnauthorized, nil)
+		return
+	// If API token was revoked, 403
+	case zstoredauth.ErrTokenRevoked:
+		writeError(w, http.StatusForbidden, ErrorCodeForbidden, nil)
+		return
+	}
+
+	log.Println(err)
+	writeError(w, http.StatusInternalServerError, ErrorCodeInternal, nil)
+}
+
+var _ TenantIdentifier = RemoteIP{}
+
+// RemoteIP is a TenantIdentifier which identifies tenants by the MD5 hash of
+// the IP address which made a HTTP request.
+type RemoteIP struct{}
+
+// Tenant returns the MD5 hash of the IP address which made a HTTP request.
+// ErrNoTenant is returned if a trusted proxy did not specify the client's
+// address in its PROXY protocol header.
+func (RemoteIP) Tenant(r *http.Request) (string, error) {
+	// Retrieve IP address from HTTP request
+	host, err := remoteHost(r)
+	if err != nil {
+		return "", err
+	}
+
+	return ipTenant(host), nil
+}
+
+// remoteHost returns the host of the address which made a HTTP request.
+// ErrNoTenant is returned for connections whose trusted proxy did not specify
+// a client.
+func remoteHost(r *http.Request) (string, error) {
+	if r.RemoteAddr == unknownClient.String() {
+		return "", ErrNoTenant
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	return host, err
+}
+
+var _ TenantIdentifier = &ForwardedFor{}
+
+// ForwardedFor is a TenantIdentifier which identifies tenants by the MD5 hash
+// of their IP address, as reported by trusted proxies in the X-Forwarded-For
+// header.  Requests which do not arrive from a trusted proxy are identified
+// by their remote IP address.
+type ForwardedFor struct {
+	trusted []*net.IPNet
+}
+
+// NewForwardedFor creates a new ForwardedFor which trusts X-Forwarded-For
+// headers set by proxies within the specified networks.
+func NewForwardedFor(trusted []*net.IPNet) *ForwardedFor {
+	return &ForwardedFor{
+		trusted: trusted,
+	}
+}
+
+// Tenant returns the MD5 hash of the client IP address for a HTTP request.
+// X-Forwarded-For is read from right to left, and the first address which
+// does not belong to a trusted proxy is used, so that clients cannot spoof
+// their address by sending their own header.  ErrNoTenant is returned if a
+// trusted proxy forwards no address, or only addresses of trusted proxies.
+func (f *ForwardedFor) Tenant(r *http.Request) (string, error) {
+	host, err := remoteHost(r)
+	if err != nil {
+		return "", err
+	}
+
+	// Requests which did not pass through a trusted proxy are identified
+	// by their own address
+	if !f.isTrusted(host) {
+		return ipTenant(host), nil
+	}
+
+	// Proxies may append to or add additional headers, so consider all
+	// addresses in order
+	var addrs []string
+	for _, h := range r.Header[http.CanonicalHeaderKey("X-Forwarded-For")] {
+		for _, a := range strings.Split(h, ",") {
+			addrs = append(addrs, strings.TrimSpace(a))
+		}
+	}
+
+	for i := len(addrs) - 1; i >= 0; i-- {
+		ip := net.ParseIP(addrs[i])
+		if ip == nil {
+			return "", ErrNoTenant
+		}
+
+		if a := ip.String(); !f.isTrusted(a) {
+			return ipTenant(a), nil
+		}
+	}
+
+	// Proxies do not have tenants of their own, so a trusted proxy which
+	// forwards no client address cannot be identified
+	return "", ErrNoTenant
+}
+
+// isTrusted determines if an IP address belongs to a trusted proxy.
+func (f *ForwardedFor) isTrusted(host string) bool {
+	return trustedIP(f.trusted, net.ParseIP(host))
+}
+
+var _ TenantIdentifier = ClientCertificate{}
+
+// ClientCertificate is a TenantIdentifier which identifies tenants by the
+// verified TLS client certificate presented with a HTTP request.  By default,
+// the certificate's subject common name is used.  If SAN is true, the first
+// DNS subject alternative name which is a valid tenant ID is used instead.
+type ClientCertificate struct {
+	SAN bool
+}
+
+// Tenant returns the tenant ID from the TLS client certificate for a HTTP
+// request.
+func (c ClientCertificate) Tenant(r *http.Request) (string, error) {
+	// Certificates must be verified by the TLS server, so only verified
+	// chains are considered
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", ErrNoTenant
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+
+	if !c.SAN {
+		if !zstoredauth.ValidTenant(cert.Subject.CommonName) {
+			return "", ErrNoTenant
+		}
+
+		return cert.Subject.CommonName, nil
+	}
+
+	for _, name := range cert.DNSNames {
+		if zstoredauth.ValidTenant(name) {
+			return name, nil
+		}
+	}
+
+	return "", ErrNoTenant
+}
+
+var _ TenantIdentifier = &BearerToken{}
+
+// BearerToken is a TenantIdentifier which identifies tenants by the API token
+// sent in the Authorization header of a HTTP request.
+type BearerToken struct {
+	tokens *zstoredauth.TokenStore
+}
+
+// NewBearerToken creates a new BearerToken which checks API tokens against
+// the specified TokenStore.
+func NewBearerToken(tokens *zstoredauth.TokenStore) *BearerToken {
+	return &BearerToken{
+		tokens: tokens,
+	}
+}
+
+// Tenant returns the tenant ID identified by the bearer token for a HTTP
+// request.
+func (b *BearerToken) Tenant(r *http.Request) (string, error) {
+	// Retrieve bearer token from HTTP request
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return "", zstoredauth.ErrInvalidToken
+	}
+
+	return b.tokens.Tenant(strings.TrimSpace(auth[len(prefix):]))
+}
+
+// ipTenant returns the tenant ID for an IP address, which is the MD5 hash of
+// its string form.
+func ipTenant(host string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(host)))
+}
+
+// trustedIP determines if an IP address belongs to any of a list of trusted
+// networks.
+func trustedIP(trusted []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,299 @@
+package zstoredhttp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mdlayher/zstore/storage"
+)
+
+// TestForwardedForTenant verifies that ForwardedFor only trusts the
+// X-Forwarded-For header when requests arrive from a trusted proxy.
+func TestForwardedForTenant(t *testing.T) {
+	_, trusted, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewForwardedFor([]*net.IPNet{trusted})
+
+	var tests = []struct {
+		description string
+		remote      string
+		forwarded   []string
+		ip          string
+		err         error
+	}{
+		{
+			description: "untrusted remote, no header",
+			remote:      "192.168.1.1:12345",
+			ip:          "192.168.1.1",
+		},
+		{
+			description: "untrusted remote, spoofed header",
+			remote:      "192.168.1.1:12345",
+			forwarded:   []string{"192.168.1.2"},
+			ip:          "192.168.1.1",
+		},
+		{
+			description: "trusted proxy, no header",
+			remote:      "10.0.0.1:12345",
+			err:         ErrNoTenant,
+		},
+		{
+			description: "trusted proxy chain, no client",
+			remote:      "10.0.0.1:12345",
+			forwarded:   []string{"10.0.0.3, 10.0.0.2"},
+			err:         ErrNoTenant,
+		},
+		{
+			description: "trusted proxy, single client",
+			remote:      "10.0.0.1:12345",
+			forwarded:   []string{"192.168.1.1"},
+			ip:          "192.168.1.1",
+		},
+		{
+			description: "trusted proxy chain, spoofed client",
+			remote:      "10.0.0.1:12345",
+			forwarded:   []string{"192.168.1.2, 192.168.1.1", "10.0.0.2"},
+			ip:          "192.168.1.1",
+		},
+		{
+			description: "trusted proxy, invalid header",
+			remote:      "10.0.0.1:12345",
+			forwarded:   []string{"foo"},
+			err:         ErrNoTenant,
+		},
+	}
+
+	for _, test := range tests {
+		r := &http.Request{
+			RemoteAddr: test.remote,
+			Header: http.Header{
+				"X-Forwarded-For": test.forwarded,
+			},
+		}
+
+		tenant, err := f.Tenant(r)
+		if err != test.err {
+			t.Fatalf("unexpected error: %v != %v [description: %s]", err, test.err, test.description)
+		}
+		if err != nil {
+			continue
+		}
+
+		if want := ipTenant(test.ip); tenant != want {
+			t.Fatalf("unexpected tenant: %v != %v [description: %s]", tenant, want, test.description)
+		}
+	}
+}
+
+// TestClientCertificateTenant verifies that ClientCertificate identifies
+// tenants only by the common name or DNS SANs of a verified certificate.
+func TestClientCertificateTenant(t *testing.T) {
+	cert := func(cn string, sans ...string) *tls.ConnectionState {
+		return &tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{{
+				Subject:  pkix.Name{CommonName: cn},
+				DNSNames: sans,
+			}}},
+		}
+	}
+
+	var tests = []struct {
+		description string
+		san         bool
+		state       *tls.ConnectionState
+		tenant      string
+		err         error
+	}{
+		{
+			description: "no TLS",
+			err:         ErrNoTenant,
+		},
+		{
+			description: "no verified certificate",
+			state:       &tls.ConnectionState{},
+			err:         ErrNoTenant,
+		},
+		{
+			description: "invalid tenant ID",
+			state:       cert("Foo/Bar"),
+			err:         ErrNoTenant,
+		},
+		{
+			description: "OK",
+			state:       cert("acme"),
+			tenant:      "acme",
+		},
+		{
+			description: "SAN, no valid DNS names",
+			san:         true,
+			state:       cert("acme", "acme.example.com"),
+			err:         ErrNoTenant,
+		},
+		{
+			description: "SAN OK",
+			san:         true,
+			state:       cert("Acme Corporation", "acme.example.com", "acme"),
+			tenant:      "acme",
+		},
+	}
+
+	for _, test := range tests {
+		c := ClientCertificate{SAN: test.san}
+		tenant, err := c.Tenant(&http.Request{TLS: test.state})
+		if err != test.err {
+			t.Fatalf("unexpected error: %v != %v [description: %s]", err, test.err, test.description)
+		}
+		if tenant != test.tenant {
+			t.Fatalf("unexpected tenant: %v != %v [description: %s]", tenant, test.tenant, test.description)
+		}
+	}
+}
+
+// TestParseProxyHeader verifies that PROXY protocol version 1 headers are
+// parsed correctly, and that invalid headers are rejected.
+func TestParseProxyHeader(t *testing.T) {
+	var tests = []struct {
+		header string
+		addr   string
+		err    error
+	}{
+		{
+			header: "PROXY TCP4 192.168.1.1 10.0.0.1 12345 5000\r\n",
+			addr:   "192.168.1.1:12345",
+		},
+		{
+			header: "PROXY TCP6 2001:db8::1 2001:db8::2 12345 5000\r\n",
+			addr:   "[2001:db8::1]:12345",
+		},
+		{
+			header: "PROXY UNKNOWN\r\n",
+			addr:   "unknown",
+		},
+		{
+			header: "GET / HTTP/1.1\r\n",
+			err:    errInvalidProxyHeader,
+		},
+		{
+			header: "PROXY TCP4 192.168.1.1 10.0.0.1 12345 5000\n",
+			err:    errInvalidProxyHeader,
+		},
+		{
+			header: "PROXY TCP4 2001:db8::1 10.0.0.1 12345 5000\r\n",
+			err:    errInvalidProxyHeader,
+		},
+		{
+			header: "PROXY TCP4 192.168.1.1 10.0.0.1 123456 5000\r\n",
+			err:    errInvalidProxyHeader,
+		},
+		{
+			header: "PROXY TCP4 192.168.1.1 10.0.0.1 12345" + strings.Repeat(" ", proxyHeaderMax) + "5000\r\n",
+			err:    errInvalidProxyHeader,
+		},
+	}
+
+	for _, test := range tests {
+		addr, err := parseProxyHeader(bufio.NewReaderSize(strings.NewReader(test.header), proxyHeaderMax))
+		if err != test.err {
+			t.Fatalf("unexpected error: %v != %v [header: %q]", err, test.err, test.header)
+		}
+		if err != nil {
+			continue
+		}
+
+		if s := addr.String(); s != test.addr {
+			t.Fatalf("unexpected address: %v != %v [header: %q]", s, test.addr, test.header)
+		}
+	}
+}
+
+// TestProxyListener verifies that connections from trusted proxies report
+// the client address from their PROXY protocol header.
+func TestProxyListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	_, trusted, err := net.ParseCIDR("127.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l = NewProxyListener(l, []*net.IPNet{trusted})
+
+	go func() {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		c.Write([]byte("PROXY TCP4 192.168.1.1 127.0.0.1 12345 5000\r\nzstore"))
+	}()
+
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if addr := c.RemoteAddr().String(); addr != "192.168.1.1:12345" {
+		t.Fatalf("unexpected remote address: %v != %v", addr, "192.168.1.1:12345")
+	}
+
+	b := make([]byte, 6)
+	if _, err := io.ReadFull(c, b); err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); s != "zstore" {
+		t.Fatalf("unexpected data: %q != %q", s, "zstore")
+	}
+}
+
+// TestProxyListenerUnknown verifies that connections whose trusted proxy does
+// not specify a client are never identified as the proxy itself.
+func TestProxyListenerUnknown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	_, trusted, err := net.ParseCIDR("127.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l = NewProxyListener(l, []*net.IPNet{trusted})
+
+	// Without administrators, any identified tenant would be forbidden
+	go http.Serve(l, NewServeMux(storage.NewMemPool("zstore", 0), &Config{}))
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.Write([]byte("PROXY UNKNOWN\r\nGET /v1/pool HTTP/1.1\r\nHost: zstore\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected code: %v != %v", res.StatusCode, http.StatusUnauthorized)
+	}
+}
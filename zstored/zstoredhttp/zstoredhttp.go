@@ -12,14 +12,109 @@ const (
 	storageAPI = "/v1/storage/"
 )
 
-// NewServeMux returns a http.Handler for the zstored HTTP server.
-func NewServeMux(pool storage.Pool) http.Handler {
+// Config is optional configuration for the zstored HTTP server.
+//
+// Tenants identifies the tenant whose bucket contains the volumes of each
+// request; if nil, RemoteIP is used.  Sizes is the catalog of size slugs
+// which may be used to create and resize volumes; if nil, the default catalog
+// is used.  If ExactSizes is not nil, volumes may also be created and resized
+// with exact sizes within its limits, rather than only with size slugs.
+// Classes are the storage classes which may be selected when creating a
+// volume, and must be valid according to storage.ValidateClasses.  Admins are
+// the IDs of the tenants which may use administrative APIs, such as the pool
+// status API; if empty, no tenant may use them.  Ready determines if the
+// server is ready to serve storage requests; if nil, the server is ready
+// while its pool is online.  If Monitor is not nil, the server enters a
+// read-only degraded mode while the monitored pool is not online.
+type Config struct {
+	Tenants    TenantIdentifier
+	Sizes      *storage.SlugCatalog
+	ExactSizes *SizeLimits
+	Classes    []*storage.Class
+	Admins     []string
+	Ready      ReadinessCheck
+	Monitor    *PoolMonitor
+}
+
+// SizeLimits are the minimum and maximum sizes in bytes of volumes which are
+// created or resized with exact sizes.  Exact sizes are rounded up to a
+// multiple of a volume's block size before they are compared to the limits.
+type SizeLimits struct {
+	Min uint64 `json:"min"`
+	Max uint64 `json:"max"`
+}
+
+// NewServeMux returns a http.Handler for the zstored HTTP server, which
+// serves volumes from the specified pool.  If config is nil, a default
+// configuration is used.
+func NewServeMux(pool storage.Pool, config *Config) http.Handler {
+	if config == nil {
+		config = &Config{}
+	}
+
+	tenants := config.Tenants
+	if tenants == nil {
+		tenants = RemoteIP{}
+	}
+
+	catalog := config.Sizes
+	if catalog == nil {
+		catalog = storage.DefaultSlugCatalog()
+	}
+
+	classes := make(map[string]*storage.Class, len(config.Classes))
+	for _, c := range config.Classes {
+		classes[c.Name] = c
+	}
+
+	ready := config.Ready
+	if ready == nil {
+		ready = poolReady(pool)
+	}
+
+	admins := make(map[string]struct{}, len(config.Admins))
+	for _, a := range config.Admins {
+		admins[a] = struct{}{}
+	}
+
+	metrics := newMetrics(pool)
+
 	// Set up HTTP handlers
 	mux := http.NewServeMux()
 	//   - Storage provisioning API
 	mux.Handle(storageAPI, &StorageContext{
-		pool: pool,
+		pool:       pool,
+		tenants:    tenants,
+		catalog:    catalog,
+		exactSizes: config.ExactSizes,
+		classes:    classes,
+		metrics:    metrics,
+		monitor:    config.Monitor,
+	})
+	//   - Size slug catalog API
+	mux.Handle(sizesAPI, &sizesHandler{
+		pool:    pool,
+		catalog: catalog,
+	})
+	//   - Discovery API
+	mux.Handle(discoveryAPI, &discoveryHandler{
+		pool:       pool,
+		catalog:    catalog,
+		exactSizes: config.ExactSizes,
+		features:   features(config),
+	})
+	//   - Pool status API
+	mux.Handle(poolAPI, &poolHandler{
+		pool:    pool,
+		tenants: tenants,
+		admins:  admins,
+		monitor: config.Monitor,
 	})
+	//   - Health and readiness checks
+	mux.HandleFunc(healthAPI, healthHandler)
+	mux.Handle(readyAPI, readyHandler(ready))
+	//   - Prometheus metrics
+	mux.Handle(metricsAPI, metrics.handler())
 
 	return mux
 }
@@ -0,0 +1,95 @@
+package zstoredhttp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mdlayher/zstore/storage"
+	"github.com/mdlayher/zstore/zstored/zstoredauth"
+)
+
+// TestNewServeMuxTokens verifies that API tokens are required when a token
+// store is configured, and that volumes are bucketed by tenant ID.
+func TestNewServeMuxTokens(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "zstoredhttp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokens := zstoredauth.NewTokenStore(filepath.Join(dir, "tokens.json"))
+	token, tok, err := tokens.Mint("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := storage.NewMemPool("zstore", 0)
+	mux := NewServeMux(pool, &Config{
+		Tenants: NewBearerToken(tokens),
+	})
+
+	request := func(method string, path string, body string, auth string) int {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = "192.168.1.1:12345"
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	var tests = []struct {
+		description string
+		auth        string
+		code        int
+	}{
+		{
+			description: "no token",
+			code:        http.StatusUnauthorized,
+		},
+		{
+			description: "not a bearer token",
+			auth:        "Basic " + token,
+			code:        http.StatusUnauthorized,
+		},
+		{
+			description: "unknown token",
+			auth:        "Bearer foo",
+			code:        http.StatusUnauthorized,
+		},
+		{
+			description: "valid token",
+			auth:        "Bearer " + token,
+			code:        http.StatusCreated,
+		},
+	}
+
+	for _, test := range tests {
+		if code := request("POST", "/v1/storage/foo", `{"size":"256M"}`, test.auth); code != test.code {
+			t.Fatalf("unexpected code: %v != %v [description: %s]", code, test.code, test.description)
+		}
+	}
+
+	// Volume is bucketed by tenant ID
+	if _, err := pool.Volume("zstore/acme/foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Revoked tokens are forbidden
+	if err := tokens.Revoke(tok.ID); err != nil {
+		t.Fatal(err)
+	}
+	if code := request("GET", "/v1/storage/foo", "", "Bearer "+token); code != http.StatusForbidden {
+		t.Fatalf("unexpected code: %v != %v", code, http.StatusForbidden)
+	}
+}